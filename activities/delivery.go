@@ -3,16 +3,115 @@ package activities
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"math/rand"
 	"time"
+
+	"pizza-order-dag-demo/metrics"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// ErrNoDriversAvailable is the application error type returned when nobody in
+// the requested driver pool can take a delivery. The workflow escalates to a
+// wider pool instead of retrying.
+const ErrNoDriversAvailable = "NoDriversAvailable"
+
+// ErrOutsideDeliveryArea is the application error type returned for addresses
+// beyond MaxDeliveryRadiusKm. It is not retryable.
+const ErrOutsideDeliveryArea = "OutsideDeliveryArea"
+
+// Delivery fee pricing: a flat base plus a per-kilometre charge
+const (
+	BaseDeliveryFee  = 2.99
+	DeliveryFeePerKm = 0.50
+)
+
+// MaxDeliveryRadiusKm is the farthest the shop delivers. The worker and API
+// server override it with MAX_DELIVERY_RADIUS_KM.
+var MaxDeliveryRadiusKm = 15.0
+
+// Escalation levels widen the driver pool when a zone has nobody available
+const (
+	EscalationZone     = 0 // Drivers serving the delivery zone
+	EscalationAllZones = 1 // Plus drivers from every other zone
+	EscalationPremium  = 2 // Plus premium couriers
 )
 
+// premiumDrivers cover every zone, at a premium, as a last resort
+var premiumDrivers = []string{"Priority Courier Co.", "Rapid Premium Dispatch"}
+
+// deliveryZones lists the simulated delivery zones in hash order
+var deliveryZones = []string{"NORTH", "SOUTH", "EAST", "WEST", "OUTSKIRTS"}
+
+// zoneDrivers maps each delivery zone to the drivers serving it.
+// OUTSKIRTS has no drivers, so deliveries there always fail.
+var zoneDrivers = map[string][]string{
+	"NORTH":     {"John Smith", "Maria Garcia"},
+	"SOUTH":     {"James Wilson"},
+	"EAST":      {"Emma Johnson", "Ali Hassan"},
+	"WEST":      {"Maria Garcia", "Ali Hassan"},
+	"OUTSKIRTS": {},
+}
+
+// ZoneForAddress simulates geocoding by hashing the address into a delivery zone.
+// It is deterministic, so it is safe to call from workflow code.
+func ZoneForAddress(address string) string {
+	h := fnv.New32a()
+	h.Write([]byte(address))
+	return deliveryZones[h.Sum32()%uint32(len(deliveryZones))]
+}
+
+// DistanceForAddress simulates geocoding by hashing the address into a distance
+// from the shop, 0.5-25 km. It is deterministic, so the API server can check an
+// address against MaxDeliveryRadiusKm before the order starts.
+func DistanceForAddress(address string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte("distance:" + address))
+	return 0.5 + float64(h.Sum32()%246)/10
+}
+
+// DeliveryFeeFor prices a delivery of the given distance, rounded to cents
+func DeliveryFeeFor(distanceKm float64) float64 {
+	return math.Round((BaseDeliveryFee+DeliveryFeePerKm*distanceKm)*100) / 100
+}
+
+// driverPool returns the drivers available to a zone at an escalation level
+func driverPool(zone string, level int) []string {
+	pool := append([]string{}, zoneDrivers[zone]...)
+	if level >= EscalationAllZones {
+		seen := make(map[string]bool)
+		for _, driver := range pool {
+			seen[driver] = true
+		}
+		for _, z := range deliveryZones {
+			for _, driver := range zoneDrivers[z] {
+				if !seen[driver] {
+					seen[driver] = true
+					pool = append(pool, driver)
+				}
+			}
+		}
+	}
+	if level >= EscalationPremium {
+		pool = append(pool, premiumDrivers...)
+	}
+	return pool
+}
+
 // DeliveryInput represents delivery request data
 type DeliveryInput struct {
 	OrderID         string
 	CustomerName    string
 	DeliveryAddress string
-	EstimatedTime   int // minutes
+	Zone            string // Delivery zone, see ZoneForAddress
+	EstimatedTime   int    // minutes
+	EscalationLevel int    // Widens the driver pool, see driverPool
+
+	// Optional customer delivery window; the ETA is kept inside it
+	WindowStart *time.Time
+	WindowEnd   *time.Time
 }
 
 // DeliveryResult represents delivery service response
@@ -24,32 +123,69 @@ type DeliveryResult struct {
 	Status           string
 }
 
+// DeliveryFeeEstimate is the priced distance to a delivery address
+type DeliveryFeeEstimate struct {
+	Fee        float64
+	DistanceKm float64
+}
+
 // DeliveryActivities holds delivery-related activities
 type DeliveryActivities struct{}
 
+// EstimateDeliveryFee simulates a distance lookup and prices the delivery.
+// Addresses beyond MaxDeliveryRadiusKm fail with ErrOutsideDeliveryArea.
+func (a *DeliveryActivities) EstimateDeliveryFee(ctx context.Context, address string) (*DeliveryFeeEstimate, error) {
+	// Simulate geocoding API latency
+	time.Sleep(time.Duration(100+rand.Intn(200)) * time.Millisecond)
+
+	distance := DistanceForAddress(address)
+	if distance > MaxDeliveryRadiusKm {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("%s is outside the delivery area (%.1f km, max %.1f km)", address, distance, MaxDeliveryRadiusKm),
+			ErrOutsideDeliveryArea, nil)
+	}
+
+	estimate := &DeliveryFeeEstimate{Fee: DeliveryFeeFor(distance), DistanceKm: distance}
+	fmt.Printf("✓ Delivery fee estimated: $%.2f for %.1f km\n", estimate.Fee, estimate.DistanceKm)
+	return estimate, nil
+}
+
 // ScheduleDelivery simulates calling a delivery service API (Uber, DoorDash, etc.)
 func (a *DeliveryActivities) ScheduleDelivery(ctx context.Context, input DeliveryInput) (*DeliveryResult, error) {
 	// Simulate API call latency
 	time.Sleep(time.Duration(300+rand.Intn(700)) * time.Millisecond)
 
-	// Simulate random failures (5% chance - no drivers available)
+	// Only drivers in the pool for the order's zone can take it
+	drivers := driverPool(input.Zone, input.EscalationLevel)
+	if len(drivers) == 0 {
+		metrics.DeliveryFailures.Inc()
+		return nil, temporal.NewApplicationError("no delivery drivers available in your area", ErrNoDriversAvailable)
+	}
+
+	// Simulate random failures (5% chance - all pool drivers busy)
 	if rand.Float64() < 0.05 {
-		return nil, fmt.Errorf("no delivery drivers available in your area")
+		metrics.DeliveryFailures.Inc()
+		return nil, temporal.NewApplicationError("no delivery drivers available in your area", ErrNoDriversAvailable)
 	}
 
-	// Random driver names for simulation
-	drivers := []string{"John Smith", "Maria Garcia", "James Wilson", "Emma Johnson", "Ali Hassan"}
+	eta := time.Now().Add(time.Duration(input.EstimatedTime) * time.Minute)
+	if input.WindowStart != nil && eta.Before(*input.WindowStart) {
+		eta = *input.WindowStart // Driver holds the order until the window opens
+	}
+	if input.WindowEnd != nil && eta.After(*input.WindowEnd) {
+		eta = *input.WindowEnd // Driver prioritizes the order to make the window
+	}
 
 	result := &DeliveryResult{
 		DeliveryID:       fmt.Sprintf("DEL-%s", generateRandomID(10)),
 		DriverName:       drivers[rand.Intn(len(drivers))],
-		EstimatedArrival: time.Now().Add(time.Duration(input.EstimatedTime) * time.Minute),
+		EstimatedArrival: eta,
 		TrackingURL:      fmt.Sprintf("https://tracking.example.com/%s", generateRandomID(12)),
 		Status:           "DRIVER_ASSIGNED",
 	}
 
-	fmt.Printf("✓ Delivery scheduled: Driver %s will arrive in ~%d minutes (ID: %s)\n",
-		result.DriverName, input.EstimatedTime, result.DeliveryID)
+	fmt.Printf("✓ Delivery scheduled: Driver %s (zone %s, escalation %d) will arrive in ~%d minutes (ID: %s)\n",
+		result.DriverName, input.Zone, input.EscalationLevel, input.EstimatedTime, result.DeliveryID)
 
 	return result, nil
 }
@@ -0,0 +1,84 @@
+package activities
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// FakeGateway is a deterministic PaymentGateway for tests.
+// It declines any amount listed in DeclineAmounts and records every call.
+type FakeGateway struct {
+	DeclineAmounts []float64
+
+	mu             sync.Mutex
+	charges        []PaymentInput
+	refunds        []string
+	partialRefunds []PartialRefund
+}
+
+// PartialRefund is a partial refund recorded by FakeGateway
+type PartialRefund struct {
+	TransactionID string
+	Amount        float64
+}
+
+// Charge succeeds instantly unless the amount is configured to decline
+func (g *FakeGateway) Charge(ctx context.Context, input PaymentInput) (*PaymentResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.charges = append(g.charges, input)
+	for _, amount := range g.DeclineAmounts {
+		if input.Amount == amount {
+			return nil, fmt.Errorf("payment gateway error: card declined for $%.2f", input.Amount)
+		}
+	}
+
+	return &PaymentResult{
+		TransactionID: fmt.Sprintf("FAKE-TXN-%d", len(g.charges)),
+		Status:        "SUCCESS",
+		Amount:        input.Amount,
+		Timestamp:     time.Now(),
+	}, nil
+}
+
+// Refund records the refunded transaction
+func (g *FakeGateway) Refund(ctx context.Context, transactionID string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.refunds = append(g.refunds, transactionID)
+	return nil
+}
+
+// PartialRefund records the partially refunded transaction and amount
+func (g *FakeGateway) PartialRefund(ctx context.Context, transactionID string, amount float64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.partialRefunds = append(g.partialRefunds, PartialRefund{TransactionID: transactionID, Amount: amount})
+	return nil
+}
+
+// Charges returns every charge attempted so far
+func (g *FakeGateway) Charges() []PaymentInput {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]PaymentInput(nil), g.charges...)
+}
+
+// Refunds returns every refunded transaction ID so far
+func (g *FakeGateway) Refunds() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.refunds...)
+}
+
+// PartialRefunds returns every partial refund so far
+func (g *FakeGateway) PartialRefunds() []PartialRefund {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]PartialRefund(nil), g.partialRefunds...)
+}
@@ -0,0 +1,122 @@
+package activities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// PointsPerDollar is the loyalty accrual rate
+const PointsPerDollar = 1
+
+// DefaultLedgerPath is where the simulated loyalty ledger lives. The worker
+// writes it and the API server reads it, so it must be shared between them.
+const DefaultLedgerPath = "loyalty-ledger.json"
+
+// LoyaltyLedger is a simulated customer points database, stored as a JSON file
+type LoyaltyLedger struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// ledgerData is the on-disk ledger format. Orders records what each order was
+// awarded, so accruals are idempotent per order.
+type ledgerData struct {
+	Customers map[string]int `json:"customers"`
+	Orders    map[string]int `json:"orders"`
+}
+
+// NewLoyaltyLedger opens the ledger at path (DefaultLedgerPath if empty)
+func NewLoyaltyLedger(path string) *LoyaltyLedger {
+	if path == "" {
+		path = DefaultLedgerPath
+	}
+	return &LoyaltyLedger{Path: path}
+}
+
+// Points returns a customer's point balance
+func (l *LoyaltyLedger) Points(customerEmail string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := l.load()
+	if err != nil {
+		return 0, err
+	}
+	return data.Customers[customerEmail], nil
+}
+
+// Award credits points for an order. Awarding the same order again returns
+// the original award without crediting anything.
+func (l *LoyaltyLedger) Award(orderID, customerEmail string, points int) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	data, err := l.load()
+	if err != nil {
+		return 0, err
+	}
+	if awarded, ok := data.Orders[orderID]; ok {
+		return awarded, nil
+	}
+
+	data.Orders[orderID] = points
+	data.Customers[customerEmail] += points
+	return points, l.save(data)
+}
+
+func (l *LoyaltyLedger) load() (*ledgerData, error) {
+	data := &ledgerData{Customers: map[string]int{}, Orders: map[string]int{}}
+	raw, err := os.ReadFile(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read loyalty ledger: %w", err)
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return nil, fmt.Errorf("failed to parse loyalty ledger: %w", err)
+	}
+	return data, nil
+}
+
+func (l *LoyaltyLedger) save(data *ledgerData) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(l.Path, raw, 0o644)
+}
+
+// LoyaltyActivities holds loyalty-program activities
+type LoyaltyActivities struct {
+	Ledger *LoyaltyLedger // nil uses the ledger at DefaultLedgerPath
+}
+
+var defaultLedger = NewLoyaltyLedger("")
+
+func (a *LoyaltyActivities) ledger() *LoyaltyLedger {
+	if a.Ledger == nil {
+		return defaultLedger
+	}
+	return a.Ledger
+}
+
+// AccrueLoyaltyPoints awards points for a completed order (PointsPerDollar,
+// rounded down). It is idempotent per order, so activity retries never
+// double-award. Returns the points awarded to the order.
+func (a *LoyaltyActivities) AccrueLoyaltyPoints(ctx context.Context, orderID, customerEmail string, amount float64) (int, error) {
+	points := int(math.Floor(amount * PointsPerDollar))
+	awarded, err := a.ledger().Award(orderID, customerEmail, points)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("✓ Loyalty points: %d awarded to %s for order %s\n", awarded, customerEmail, orderID)
+	return awarded, nil
+}
@@ -17,7 +17,9 @@ type NotificationInput struct {
 }
 
 // NotificationActivities holds notification-related activities
-type NotificationActivities struct{}
+type NotificationActivities struct {
+	Sender func(ctx context.Context, input NotificationInput) error // nil uses SendNotification's simulation
+}
 
 // SendNotification simulates calling a notification service (Twilio, SendGrid, etc.)
 func (a *NotificationActivities) SendNotification(ctx context.Context, input NotificationInput) error {
@@ -43,21 +45,81 @@ func (a *NotificationActivities) SendNotification(ctx context.Context, input Not
 	return nil
 }
 
-// SendOrderConfirmation sends order confirmation notification
-func (a *NotificationActivities) SendOrderConfirmation(ctx context.Context, orderID, customerName, customerEmail string) error {
-	return a.SendNotification(ctx, NotificationInput{
-		CustomerName:  customerName,
-		CustomerEmail: customerEmail,
-		Message:       fmt.Sprintf("Order %s confirmed! Your pizza is being prepared.", orderID),
-		Type:          "EMAIL",
-	})
+// Recipient identifies who to notify and on which channel
+type Recipient struct {
+	CustomerName  string
+	CustomerEmail string
+	CustomerPhone string
+	Channel       string // "SMS", "EMAIL", "PUSH" - empty uses the message's default channel
 }
 
-// SendDeliveryNotification sends delivery status notification
-func (a *NotificationActivities) SendDeliveryNotification(ctx context.Context, customerName, driverName string, eta time.Time) error {
-	return a.SendNotification(ctx, NotificationInput{
-		CustomerName: customerName,
-		Message:      fmt.Sprintf("Your pizza is on the way! Driver: %s, ETA: %s", driverName, eta.Format("3:04 PM")),
-		Type:         "SMS",
+// notify sends a message to the recipient, using defaultType when no channel is chosen
+func (a *NotificationActivities) notify(ctx context.Context, recipient Recipient, defaultType, message string) error {
+	notificationType := recipient.Channel
+	if notificationType == "" {
+		notificationType = defaultType
+	}
+
+	send := a.SendNotification
+	if a.Sender != nil {
+		send = a.Sender
+	}
+	return send(ctx, NotificationInput{
+		CustomerName:  recipient.CustomerName,
+		CustomerEmail: recipient.CustomerEmail,
+		CustomerPhone: recipient.CustomerPhone,
+		Message:       message,
+		Type:          notificationType,
 	})
 }
+
+// SendOrderConfirmation sends order confirmation notification (EMAIL by default)
+func (a *NotificationActivities) SendOrderConfirmation(ctx context.Context, recipient Recipient, orderID string) error {
+	return a.notify(ctx, recipient, "EMAIL",
+		fmt.Sprintf("Order %s confirmed! Your pizza is being prepared.", orderID))
+}
+
+// SendDeliveryNotification sends delivery status notification (SMS by default)
+func (a *NotificationActivities) SendDeliveryNotification(ctx context.Context, recipient Recipient, driverName string, eta time.Time) error {
+	return a.notify(ctx, recipient, "SMS",
+		fmt.Sprintf("Your pizza is on the way! Driver: %s, ETA: %s", driverName, eta.Format("3:04 PM")))
+}
+
+// SendDeliveredNotification tells the customer their pizza arrived, with the driver's photo proof (SMS by default)
+func (a *NotificationActivities) SendDeliveredNotification(ctx context.Context, recipient Recipient, proofURL string) error {
+	return a.notify(ctx, recipient, "SMS",
+		fmt.Sprintf("Your pizza has been delivered! Photo: %s", proofURL))
+}
+
+// SendPickupReadyNotification tells the customer their order can be collected (SMS by default)
+func (a *NotificationActivities) SendPickupReadyNotification(ctx context.Context, recipient Recipient, orderID string) error {
+	return a.notify(ctx, recipient, "SMS",
+		fmt.Sprintf("Order %s is ready for pickup!", orderID))
+}
+
+// SendDeliveryFailedNotification tells the customer no driver could be found (SMS by default)
+func (a *NotificationActivities) SendDeliveryFailedNotification(ctx context.Context, recipient Recipient, orderID string) error {
+	return a.notify(ctx, recipient, "SMS",
+		fmt.Sprintf("Sorry, we couldn't find a driver for order %s. Our team will contact you shortly.", orderID))
+}
+
+// SendDeliveryStatusNotification tells the customer the delivery service reported a new status (SMS by default)
+func (a *NotificationActivities) SendDeliveryStatusNotification(ctx context.Context, recipient Recipient, status string) error {
+	return a.notify(ctx, recipient, "SMS",
+		fmt.Sprintf("Delivery update: your order is now %s.", status))
+}
+
+// SendOrderFailedNotification apologizes for an order that couldn't be fulfilled, with the refund (SMS by default)
+func (a *NotificationActivities) SendOrderFailedNotification(ctx context.Context, recipient Recipient, orderID string, refund float64) error {
+	message := fmt.Sprintf("We're sorry, we couldn't complete order %s.", orderID)
+	if refund > 0 {
+		message += fmt.Sprintf(" You've been refunded $%.2f.", refund)
+	}
+	return a.notify(ctx, recipient, "SMS", message)
+}
+
+// SendDeliveryWindowConfirmation confirms the customer's requested delivery window (EMAIL by default)
+func (a *NotificationActivities) SendDeliveryWindowConfirmation(ctx context.Context, recipient Recipient, start, end time.Time) error {
+	return a.notify(ctx, recipient, "EMAIL",
+		fmt.Sprintf("Your pizza will be delivered between %s and %s.", start.Format("3:04 PM"), end.Format("3:04 PM")))
+}
@@ -0,0 +1,44 @@
+package activities
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNotificationType(t *testing.T) {
+	var sent []NotificationInput
+	notifications := &NotificationActivities{Sender: func(ctx context.Context, input NotificationInput) error {
+		sent = append(sent, input)
+		return nil
+	}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		channel string
+		send    func(Recipient) error
+		want    string
+	}{
+		{name: "confirmation default", send: func(r Recipient) error { return notifications.SendOrderConfirmation(ctx, r, "order-1") }, want: "EMAIL"},
+		{name: "delivery default", send: func(r Recipient) error { return notifications.SendDeliveryNotification(ctx, r, "Dana", time.Now()) }, want: "SMS"},
+		{name: "confirmation by SMS", channel: "SMS", send: func(r Recipient) error { return notifications.SendOrderConfirmation(ctx, r, "order-1") }, want: "SMS"},
+		{name: "delivery by email", channel: "EMAIL", send: func(r Recipient) error { return notifications.SendDeliveryNotification(ctx, r, "Dana", time.Now()) }, want: "EMAIL"},
+		{name: "delivery by push", channel: "PUSH", send: func(r Recipient) error { return notifications.SendDeliveryNotification(ctx, r, "Dana", time.Now()) }, want: "PUSH"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent = nil
+			recipient := Recipient{CustomerName: "alice", CustomerEmail: "alice@example.com", CustomerPhone: "555-0100", Channel: tt.channel}
+			if err := tt.send(recipient); err != nil {
+				t.Fatal(err)
+			}
+			if len(sent) != 1 || sent[0].Type != tt.want {
+				t.Fatalf("sent %+v, want one %s", sent, tt.want)
+			}
+			if sent[0].CustomerEmail != recipient.CustomerEmail || sent[0].CustomerPhone != recipient.CustomerPhone {
+				t.Errorf("sent to %+v, want %+v", sent[0], recipient)
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
+
+	"pizza-order-dag-demo/metrics"
 )
 
 // PaymentInput represents payment request data
@@ -12,6 +15,11 @@ type PaymentInput struct {
 	OrderID      string
 	CustomerName string
 	Amount       float64
+	Method       string // types.PaymentMethod*; empty means card
+
+	// IdempotencyKey identifies the charge across activity retries: a repeat
+	// with the same key returns the original result instead of charging again
+	IdempotencyKey string
 }
 
 // PaymentResult represents payment response
@@ -22,12 +30,127 @@ type PaymentResult struct {
 	Timestamp     time.Time
 }
 
+// PaymentGateway charges and refunds customers.
+// Implement it to plug in a real provider (Stripe, PayPal, etc.) without
+// touching activity registration or the workflow.
+type PaymentGateway interface {
+	Charge(ctx context.Context, input PaymentInput) (*PaymentResult, error)
+	Refund(ctx context.Context, transactionID string) error
+
+	// PartialRefund returns amount of a charge to the customer, leaving the
+	// rest charged; a later Refund returns what remains
+	PartialRefund(ctx context.Context, transactionID string, amount float64) error
+}
+
 // PaymentActivities holds payment-related activities
-type PaymentActivities struct{}
+type PaymentActivities struct {
+	Gateway PaymentGateway // nil uses SimulatedGateway
+
+	mu      sync.Mutex
+	charges map[string]*pendingCharge // Charges in flight or succeeded, by idempotency key
+}
+
+// pendingCharge is a charge for one idempotency key. done is closed once the
+// gateway answers; result and err are set before that.
+type pendingCharge struct {
+	done   chan struct{}
+	result *PaymentResult
+	err    error
+}
+
+// gateway returns the configured gateway, defaulting to the simulation
+func (a *PaymentActivities) gateway() PaymentGateway {
+	if a.Gateway == nil {
+		return &SimulatedGateway{}
+	}
+	return a.Gateway
+}
 
-// ProcessPayment simulates calling a payment gateway API (Stripe, PayPal, etc.)
+// ProcessPayment charges the customer through the payment gateway.
 // This is a non-deterministic activity that should NEVER be in workflow code!
+//
+// Charges are remembered by IdempotencyKey, so a retry after the gateway
+// charged but the result was lost returns the original PaymentResult. A
+// repeat that arrives while the first is still with the gateway waits for it
+// and gets the same result, instead of charging twice. The record is in
+// memory: it covers retries on this worker, not restarts.
 func (a *PaymentActivities) ProcessPayment(ctx context.Context, input PaymentInput) (*PaymentResult, error) {
+	if input.IdempotencyKey == "" {
+		result, err := a.gateway().Charge(ctx, input)
+		if err != nil {
+			metrics.PaymentFailures.Inc()
+		}
+		return result, err
+	}
+
+	a.mu.Lock()
+	if prior, ok := a.charges[input.IdempotencyKey]; ok {
+		a.mu.Unlock()
+		select {
+		case <-prior.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if prior.err != nil {
+			return nil, prior.err
+		}
+		fmt.Printf("✓ Payment already processed for key %s (TxnID: %s)\n", input.IdempotencyKey, prior.result.TransactionID)
+		return prior.result, nil
+	}
+	if a.charges == nil {
+		a.charges = make(map[string]*pendingCharge)
+	}
+	charge := &pendingCharge{done: make(chan struct{})}
+	a.charges[input.IdempotencyKey] = charge
+	a.mu.Unlock()
+
+	result, err := a.gateway().Charge(ctx, input)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	charge.result, charge.err = result, err
+	close(charge.done)
+	if err != nil {
+		// Failed charges aren't remembered, so the activity's retry charges again
+		metrics.PaymentFailures.Inc()
+		delete(a.charges, input.IdempotencyKey)
+		return nil, err
+	}
+	return result, nil
+}
+
+// RefundPayment refunds a payment through the payment gateway. A refunded
+// charge is forgotten, so charging again with its key charges afresh.
+func (a *PaymentActivities) RefundPayment(ctx context.Context, transactionID string) error {
+	if err := a.gateway().Refund(ctx, transactionID); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, charge := range a.charges {
+		if charge.result != nil && charge.result.TransactionID == transactionID {
+			delete(a.charges, key)
+		}
+	}
+	return nil
+}
+
+// RefundPartialPayment refunds part of a payment through the payment
+// gateway, e.g. for an item taken off the order. The charge stays recorded
+// under its key, since the rest of it stands.
+func (a *PaymentActivities) RefundPartialPayment(ctx context.Context, transactionID string, amount float64) error {
+	if amount <= 0 {
+		return fmt.Errorf("refund amount must be positive, got %.2f", amount)
+	}
+	return a.gateway().PartialRefund(ctx, transactionID, amount)
+}
+
+// SimulatedGateway simulates calling a payment gateway API with random latency and failures
+type SimulatedGateway struct{}
+
+// Charge simulates charging a card
+func (g *SimulatedGateway) Charge(ctx context.Context, input PaymentInput) (*PaymentResult, error) {
 	// Simulate API call latency
 	time.Sleep(time.Duration(500+rand.Intn(1000)) * time.Millisecond)
 
@@ -44,16 +167,28 @@ func (a *PaymentActivities) ProcessPayment(ctx context.Context, input PaymentInp
 		Timestamp:     time.Now(),
 	}
 
-	fmt.Printf("✓ Payment processed: %s for $%.2f (TxnID: %s)\n",
-		input.CustomerName, result.Amount, result.TransactionID)
+	method := input.Method
+	if method == "" {
+		method = "CARD"
+	}
+	fmt.Printf("✓ Payment processed: %s for $%.2f by %s (TxnID: %s)\n",
+		input.CustomerName, result.Amount, method, result.TransactionID)
 
 	return result, nil
 }
 
-// RefundPayment simulates refunding a payment
-func (a *PaymentActivities) RefundPayment(ctx context.Context, transactionID string) error {
+// Refund simulates refunding a payment
+func (g *SimulatedGateway) Refund(ctx context.Context, transactionID string) error {
 	time.Sleep(time.Duration(300+rand.Intn(700)) * time.Millisecond)
 
 	fmt.Printf("✓ Payment refunded: %s\n", transactionID)
 	return nil
 }
+
+// PartialRefund simulates refunding part of a payment
+func (g *SimulatedGateway) PartialRefund(ctx context.Context, transactionID string, amount float64) error {
+	time.Sleep(time.Duration(300+rand.Intn(700)) * time.Millisecond)
+
+	fmt.Printf("✓ Payment partially refunded: $%.2f of %s\n", amount, transactionID)
+	return nil
+}
@@ -0,0 +1,87 @@
+package activities
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowGateway is a FakeGateway whose charges take a while, so concurrent
+// requests overlap at the gateway
+type slowGateway struct {
+	*FakeGateway
+	delay time.Duration
+}
+
+func (g *slowGateway) Charge(ctx context.Context, input PaymentInput) (*PaymentResult, error) {
+	time.Sleep(g.delay)
+	return g.FakeGateway.Charge(ctx, input)
+}
+
+func TestProcessPaymentIdempotent(t *testing.T) {
+	gateway := &FakeGateway{}
+	payments := &PaymentActivities{Gateway: &slowGateway{FakeGateway: gateway, delay: 20 * time.Millisecond}}
+	input := PaymentInput{OrderID: "order-1", CustomerName: "alice", Amount: 20, IdempotencyKey: "order-1/payment"}
+
+	// Duplicates in flight at the same time, then one after the charge settled
+	const concurrent = 8
+	results := make([]*PaymentResult, concurrent+1)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrent; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := payments.ProcessPayment(context.Background(), input)
+			if err != nil {
+				t.Errorf("charge %d: %v", i, err)
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+	result, err := payments.ProcessPayment(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results[concurrent] = result
+
+	if got := len(gateway.Charges()); got != 1 {
+		t.Fatalf("gateway charged %d times, want 1", got)
+	}
+	for i, result := range results {
+		if result == nil || result.TransactionID != results[0].TransactionID {
+			t.Errorf("charge %d = %+v, want TransactionID %s", i, result, results[0].TransactionID)
+		}
+	}
+
+	// A refunded charge is forgotten, so the key charges afresh
+	if err := payments.RefundPayment(context.Background(), results[0].TransactionID); err != nil {
+		t.Fatal(err)
+	}
+	again, err := payments.ProcessPayment(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.TransactionID == results[0].TransactionID || len(gateway.Charges()) != 2 {
+		t.Errorf("charge after refund = %s with %d gateway charges, want a new one", again.TransactionID, len(gateway.Charges()))
+	}
+}
+
+func TestProcessPaymentFailureNotRemembered(t *testing.T) {
+	gateway := &FakeGateway{DeclineAmounts: []float64{20}}
+	payments := &PaymentActivities{Gateway: gateway}
+	input := PaymentInput{OrderID: "order-1", Amount: 20, IdempotencyKey: "order-1/payment"}
+
+	if _, err := payments.ProcessPayment(context.Background(), input); err == nil {
+		t.Fatal("declined charge succeeded")
+	}
+	gateway.DeclineAmounts = nil
+	result, err := payments.ProcessPayment(context.Background(), input)
+	if err != nil || result == nil {
+		t.Fatalf("retry after a decline = %+v, %v; want a charge", result, err)
+	}
+	if got := len(gateway.Charges()); got != 2 {
+		t.Errorf("gateway charged %d times, want 2", got)
+	}
+}
@@ -0,0 +1,84 @@
+package activities
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// WebhookDedupHeader carries an ID that is the same on every delivery of one
+// event, so receivers can drop the duplicates at-least-once delivery produces
+const WebhookDedupHeader = "Idempotency-Key"
+
+// OrderSummaryEvent is the body of the order summary webhook, sent once when
+// an order reaches a terminal state
+type OrderSummaryEvent struct {
+	EventID       string    `json:"event_id"` // Also sent as WebhookDedupHeader
+	OrderID       string    `json:"order_id"`
+	State         string    `json:"state"`
+	CustomerName  string    `json:"customer_name"`
+	CustomerEmail string    `json:"customer_email,omitempty"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Subtotal      float64   `json:"subtotal"`
+	DeliveryFee   float64   `json:"delivery_fee"`
+	Tax           float64   `json:"tax"`
+	Total         float64   `json:"total"`
+	PaymentAmount float64   `json:"payment_amount"`
+	PaymentTxnID  string    `json:"payment_txn_id,omitempty"`
+	CompReason    string    `json:"comp_reason,omitempty"`
+	LoyaltyPoints int       `json:"loyalty_points"`
+}
+
+// WebhookResult reports how a webhook delivery went
+type WebhookResult struct {
+	Delivered bool  // False when no URL is configured
+	Attempts  int32 // Attempt that got the acknowledgement
+}
+
+// WebhookActivities posts order events to an integration endpoint
+type WebhookActivities struct {
+	URL    string       // Empty disables the webhook
+	Client *http.Client // nil uses a client with a 10s timeout
+}
+
+// SendOrderSummaryWebhook posts the summary and succeeds only once the
+// receiver acknowledges it with a 2xx. Anything else is returned as an error
+// so Temporal retries it with the same WebhookDedupHeader.
+func (a *WebhookActivities) SendOrderSummaryWebhook(ctx context.Context, event OrderSummaryEvent) (*WebhookResult, error) {
+	if a.URL == "" {
+		return &WebhookResult{}, nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDedupHeader, event.EventID)
+
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("order summary webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("order summary webhook: receiver returned %s", resp.Status)
+	}
+
+	attempt := activity.GetInfo(ctx).Attempt
+	fmt.Printf("✓ Order summary webhook delivered: %s (%s, attempt %d)\n", event.OrderID, event.State, attempt)
+	return &WebhookResult{Delivered: true, Attempts: attempt}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"pizza-order-dag-demo/types"
+)
+
+// OrderCache holds recent order query results for a short TTL, so dashboards
+// polling the same orders don't hit Temporal on every request
+type OrderCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedOrder
+}
+
+type cachedOrder struct {
+	order   *types.PizzaOrder
+	expires time.Time
+}
+
+// NewOrderCache creates a cache. A zero TTL disables caching.
+func NewOrderCache(ttl time.Duration) *OrderCache {
+	return &OrderCache{ttl: ttl, entries: make(map[string]cachedOrder)}
+}
+
+// Get returns a copy of the cached order, if present and not expired
+func (c *OrderCache) Get(orderID string) (*types.PizzaOrder, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[orderID]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, orderID)
+		return nil, false
+	}
+	return entry.order.Clone(), true
+}
+
+// Put caches a copy of the order and drops any expired entries
+func (c *OrderCache) Put(orderID string, order *types.PizzaOrder) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[orderID] = cachedOrder{order: order.Clone(), expires: now.Add(c.ttl)}
+}
+
+// Invalidate drops the cached order, so the next read sees a write
+func (c *OrderCache) Invalidate(orderID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, orderID)
+}
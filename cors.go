@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// What a browser on an allowed origin may send, and read back, cross-origin
+const (
+	corsAllowedMethods = "GET, POST, PATCH, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Idempotency-Key, " + requestIDHeader
+	corsExposedHeaders = "Location, " + requestIDHeader
+	corsMaxAge         = "600" // Seconds a browser may cache a preflight answer
+)
+
+// corsOrigins holds the origins allowed to call the API from a browser, from
+// CORS_ALLOWED_ORIGINS (comma-separated, e.g. "https://app.example.com").
+// Empty allows same-origin requests only; "*" allows any origin.
+type corsOrigins map[string]bool
+
+// parseCORSOrigins reads a comma-separated origin list. Trailing slashes are
+// dropped, since browsers never send them in Origin.
+func parseCORSOrigins(value string) corsOrigins {
+	origins := corsOrigins{}
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// allows reports whether a browser on origin may call the API
+func (o corsOrigins) allows(origin string) bool {
+	return origin != "" && (o["*"] || o[origin])
+}
+
+// checkOrigin is the WebSocket upgrader's origin check: same-origin requests,
+// non-browser clients (no Origin header) and allowed origins may connect
+func (o corsOrigins) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || o.allows(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// withCORS adds CORS headers for requests from allowed origins and answers
+// their preflight OPTIONS requests itself. With no origins configured it is a
+// pass-through, leaving the browser's same-origin policy in force.
+func withCORS(origins corsOrigins, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origins.allows(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if origins.allows(origin) {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+)
+
+// demoMode exposes POST /demo/run. Keep it off in production.
+var demoMode = os.Getenv("DEMO_MODE") == "true"
+
+// demoStepDelay paces the demo so each transition is visible
+const demoStepDelay = 1 * time.Second
+
+// demoMaxSteps stops a demo that isn't making progress
+const demoMaxSteps = 20
+
+// demoBodies supplies step data for actions that need (or show off) some
+var demoBodies = map[string]string{
+	"make-dough":   `{"dough_type": "thin"}`,
+	"add-toppings": `{"toppings": ["mushroom", "olive"]}`,
+	"proof":        `{"photo_url": "https://photos.example.com/demo.jpg"}`,
+}
+
+// demoLogEntry is one line of the streamed demo log
+type demoLogEntry struct {
+	Time    time.Time       `json:"time"`
+	Step    string          `json:"step"`
+	Status  int             `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// handleDemoRun creates an order and steps it through to completion, streaming
+// one JSON line per transition. Every call goes through the real HTTP routes,
+// so the demo exercises the same handlers and workflow updates as clients do.
+func handleDemoRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	emit := func(step string, rec *httptest.ResponseRecorder, data json.RawMessage) {
+		entry := demoLogEntry{Time: time.Now(), Step: step, Status: rec.Code, Data: data}
+		if rec.Code >= 400 {
+			entry.Message = strings.TrimSpace(rec.Body.String())
+		}
+		json.NewEncoder(w).Encode(entry)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	rec := demoCall(r.Context(), http.MethodPost, "/orders", `{"customer_name": "Demo Customer"}`)
+	created := demoData(rec)
+	emit("create", rec, created)
+	var order struct {
+		OrderID string `json:"order_id"`
+	}
+	if rec.Code != http.StatusCreated || json.Unmarshal(created, &order) != nil {
+		return
+	}
+	orderPath := "/orders/" + order.OrderID
+
+	for i := 0; i < demoMaxSteps; i++ {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(demoStepDelay):
+		}
+
+		var ready struct {
+			Actions []string `json:"actions"`
+		}
+		rec = demoCall(r.Context(), http.MethodGet, orderPath+"/actions?fresh=true", "")
+		if rec.Code != http.StatusOK || json.Unmarshal(demoData(rec), &ready) != nil {
+			emit("actions", rec, nil)
+			return
+		}
+		if len(ready.Actions) == 0 {
+			break
+		}
+
+		action := ready.Actions[0]
+		rec = demoCall(r.Context(), http.MethodPost, orderPath+"/"+action, demoBodies[action])
+		emit(action, rec, demoData(rec))
+		if rec.Code != http.StatusOK {
+			return
+		}
+	}
+
+	// The workflow completes asynchronously once the last step is done
+	time.Sleep(demoStepDelay)
+	rec = demoCall(r.Context(), http.MethodGet, orderPath+"?fresh=true", "")
+	emit("final", rec, demoData(rec))
+}
+
+// demoCall serves a request through the real routes and records the response
+func demoCall(ctx context.Context, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body)).WithContext(ctx)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(rec, req)
+	return rec
+}
+
+// demoData extracts the data field from an enveloped response
+func demoData(rec *httptest.ResponseRecorder) json.RawMessage {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		return nil
+	}
+	return envelope.Data
+}
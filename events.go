@@ -0,0 +1,62 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"pizza-order-dag-demo/types"
+)
+
+// ComponentEvent is published whenever a step of an order completes
+type ComponentEvent struct {
+	OrderID   string              `json:"order_id"`
+	Component types.ComponentType `json:"component"`
+	Time      time.Time           `json:"time"`
+}
+
+// EventBus is a small in-process pub/sub for step completions. It keeps side
+// effects (metrics, streaming, webhooks) out of the HTTP handlers.
+type EventBus struct {
+	mu          sync.RWMutex
+	nextID      int
+	subscribers map[int]func(ComponentEvent)
+}
+
+// NewEventBus creates an empty event bus
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]func(ComponentEvent))}
+}
+
+// Subscribe registers a handler and returns a function that removes it.
+// Handlers run synchronously on the publishing goroutine, so keep them quick.
+func (b *EventBus) Subscribe(handler func(ComponentEvent)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish notifies every subscriber of the event
+func (b *EventBus) Publish(event ComponentEvent) {
+	b.mu.RLock()
+	handlers := make([]func(ComponentEvent), 0, len(b.subscribers))
+	for _, handler := range b.subscribers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// events is the process-wide bus that step completions are published to
+var events = NewEventBus()
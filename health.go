@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+// readinessTimeout bounds each dependency check, so a probe never hangs on
+// an unreachable Temporal frontend
+const readinessTimeout = 2 * time.Second
+
+// handleHealthz is the liveness probe: the process is up and serving
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"}, nil)
+}
+
+// handleReadyz is the readiness probe: 200 once every dependency answers, or
+// 503 with the failing one's error under checks
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	checks := map[string]string{"temporal": "ok"}
+	status := http.StatusOK
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if _, err := temporalClient.CheckHealth(ctx, &client.CheckHealthRequest{}); err != nil {
+		logErrorf(r, "Readiness check failed: Temporal unreachable: %v", err)
+		checks["temporal"] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	overall := "ok"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	writeJSON(w, status, map[string]interface{}{"status": overall, "checks": checks}, nil)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// loggerKey is the request context key for the request's logger
+type loggerKey struct{}
+
+// setupLogging makes slog's JSON handler the default. Output from the log
+// package goes through it too, so every line is structured.
+func setupLogging() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+// withLogger returns r with logger attached to its context
+func withLogger(r *http.Request, logger *slog.Logger) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), loggerKey{}, logger))
+}
+
+// requestLogger returns the request's logger, which carries its request_id
+// (see withRequestID) and, once known, its order_id
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// withOrderID adds the order ID to the request's logger
+func withOrderID(r *http.Request, orderID string) *http.Request {
+	return withLogger(r, requestLogger(r).With("order_id", orderID))
+}
+
+// logf logs an informational line for the request
+func logf(r *http.Request, format string, args ...interface{}) {
+	requestLogger(r).Info(fmt.Sprintf(format, args...))
+}
+
+// logErrorf logs a failure while serving the request
+func logErrorf(r *http.Request, format string, args ...interface{}) {
+	requestLogger(r).Error(fmt.Sprintf(format, args...))
+}
@@ -1,22 +1,71 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/metrics"
 	"pizza-order-dag-demo/types"
 	"pizza-order-dag-demo/workflow"
 
 	"github.com/google/uuid"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
 )
 
 var temporalClient client.Client
 
+// loyaltyLedger is read directly for point balances; the worker writes it
+var loyaltyLedger = activities.NewLoyaltyLedger(os.Getenv("LOYALTY_LEDGER_PATH"))
+
+// defaultOrderAmount is the price used when an order doesn't specify one
+const defaultOrderAmount = 19.99
+
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// adminToken guards operator-only endpoints (X-Admin-Token header).
+// Those endpoints are disabled when ADMIN_TOKEN isn't set.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// defaultOrderCacheTTL is how long order queries are cached (ORDER_CACHE_TTL overrides it)
+const defaultOrderCacheTTL = 1 * time.Second
+
+// orderCache holds recent QueryOrderState results, see loadOrder
+var orderCache = NewOrderCache(defaultOrderCacheTTL)
+
+// Automatic delivery retries given to new orders (DELIVERY_RETRY_ATTEMPTS and
+// DELIVERY_RETRY_INTERVAL). Zero attempts leaves failed deliveries for a re-POST.
+var (
+	deliveryRetryAttempts int
+	deliveryRetryInterval time.Duration
+)
+
+// inFlightRequests counts requests currently being served
+var inFlightRequests atomic.Int64
+
 func main() {
+	setupLogging()
+
 	// 1. Connect to Temporal
 	var err error
 	temporalClient, err = client.Dial(client.Options{
@@ -27,34 +76,476 @@ func main() {
 	}
 	defer temporalClient.Close()
 
+	if ttl := os.Getenv("ORDER_CACHE_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("Invalid ORDER_CACHE_TTL %q: %v", ttl, err)
+		}
+		orderCache = NewOrderCache(d)
+	}
+
+	if interval := os.Getenv("ORDER_STREAM_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid ORDER_STREAM_INTERVAL %q", interval)
+		}
+		streamPollInterval = d
+	}
+
+	// Browser frontends on other origins; unset keeps the API same-origin only
+	allowedOrigins := parseCORSOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	if len(allowedOrigins) > 0 {
+		streamUpgrader.CheckOrigin = allowedOrigins.checkOrigin
+	}
+
+	if radius := os.Getenv("MAX_DELIVERY_RADIUS_KM"); radius != "" {
+		km, err := strconv.ParseFloat(radius, 64)
+		if err != nil {
+			log.Fatalf("Invalid MAX_DELIVERY_RADIUS_KM %q: %v", radius, err)
+		}
+		activities.MaxDeliveryRadiusKm = km
+	}
+
+	if attempts := os.Getenv("DELIVERY_RETRY_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid DELIVERY_RETRY_ATTEMPTS %q", attempts)
+		}
+		deliveryRetryAttempts = n
+	}
+	if interval := os.Getenv("DELIVERY_RETRY_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid DELIVERY_RETRY_INTERVAL %q", interval)
+		}
+		deliveryRetryInterval = d
+	}
+
+	// Count step completions for Prometheus
+	events.Subscribe(func(event ComponentEvent) {
+		metrics.ComponentsCompleted.WithLabelValues(string(event.Component)).Inc()
+	})
+
 	// 2. Setup HTTP routes
 	http.HandleFunc("/orders", handleOrders)
 	http.HandleFunc("/orders/", handleOrderActions)
+	http.HandleFunc("/templates", handleTemplates)
+	http.HandleFunc("/customers/", handleCustomers)
+	http.HandleFunc("/stats/throughput", handleThroughput)
+	http.Handle("/metrics", metrics.Handler())
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	if demoMode {
+		http.HandleFunc("/demo/run", handleDemoRun)
+	}
 
 	// 3. Start server
 	log.Println("API Server starting on :8080")
 	log.Println("\nEndpoints:")
 	log.Println("  POST   /orders                         - Create new pizza order")
+	log.Println("  GET    /orders?state=IN_PROGRESS       - List orders (limit, next_page_token)")
+	log.Println("  POST   /orders/validate                - Check a custom step graph without ordering")
 	log.Println("  GET    /orders/{orderID}               - Get order status")
+	log.Println("  GET    /templates                      - List DAG templates")
+	log.Println("  GET    /customers/{email}/points       - Loyalty point balance")
+	log.Println("  GET    /stats/throughput?window=1h     - Orders completed and end-to-end durations")
+	log.Println("  GET    /metrics                        - Prometheus metrics")
+	log.Println("  GET    /healthz                        - Liveness probe")
+	log.Println("  GET    /readyz                         - Readiness probe (checks Temporal)")
+	log.Println("  GET    /orders/{orderID}/actions       - List steps that can be completed now")
+	log.Println("  GET    /orders/{orderID}/available-actions - Alias of /actions")
+	log.Println("  GET    /orders/{orderID}/tracking      - Delivery tracking snapshot")
+	log.Println("  GET    /orders/{orderID}/export        - Self-contained JSON bundle of the order")
+	log.Println("  POST   /orders/import                  - Resume an exported bundle as a new order (admin)")
+	log.Println("  GET    /orders/{orderID}/print         - Kitchen ticket (Accept: text/plain for printers)")
+	log.Println("  GET    /orders/{orderID}/graph.dot     - Component graph as Graphviz DOT")
+	log.Println("  GET    /orders/{orderID}/stream        - WebSocket of order updates")
+	log.Println("  GET    /orders/{orderID}/receipt       - Itemized pricing")
+	log.Println("  GET    /orders/{orderID}/events-log    - Chronological business event log")
+	log.Println("  GET    /orders/{orderID}/durations     - Time spent on each completed step")
 	log.Println("  POST   /orders/{orderID}/payment       - Complete payment")
 	log.Println("  POST   /orders/{orderID}/make-dough    - Make dough")
+	log.Println("  POST   /orders/{orderID}/proof-dough   - Proof dough (gourmet only)")
+	log.Println("  POST   /orders/{orderID}/add-sauce     - Add sauce (sauce-and-cheese only)")
+	log.Println("  POST   /orders/{orderID}/add-cheese    - Add cheese (sauce-and-cheese only)")
 	log.Println("  POST   /orders/{orderID}/add-toppings  - Add toppings")
 	log.Println("  POST   /orders/{orderID}/bake          - Bake pizza")
 	log.Println("  POST   /orders/{orderID}/deliver       - Deliver pizza")
+	log.Println("  POST   /orders/{orderID}/pickup-ready  - Mark a pickup order ready for collection")
+	log.Println("  POST   /orders/{orderID}/verify-age    - Verify the customer's age (orders with alcohol)")
+	log.Println("  POST   /orders/{orderID}/proof         - Upload photo proof of delivery (optional)")
+	log.Println("  POST   /orders/{orderID}/reorder       - Reorder a completed order")
+	log.Println("  POST   /orders/{orderID}/remake        - Remake a completed order for free")
+	log.Println("  POST   /orders/{orderID}/assign-driver - Manually assign a delivery driver")
+	log.Println("  PATCH  /orders/{orderID}/notifications - Change notification preferences")
+	log.Println("  GET    /orders/{orderID}/components/{type} - State of a single step")
+	log.Println("  POST   /orders/{orderID}/components/{type}/complete - Complete a custom step")
+	log.Println("  POST   /orders/{orderID}/components/{type}/retry - Retry a stuck step")
+	log.Println("  POST   /orders/{orderID}/components/{type}/reset - Reopen a completed step to redo it")
+	log.Println("  POST   /orders/{orderID}/sla           - Set the order's target completion time")
+	log.Println("  POST   /orders/{orderID}/cancel        - Cancel an order and refund its payment")
+	log.Println("  POST   /orders/{orderID}/terminate     - Terminate a wedged order (admin)")
+	if demoMode {
+		log.Println("  POST   /demo/run                       - Run a demo order through every step (DEMO_MODE)")
+	}
 	log.Println("\nReady to accept requests...")
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: trackInFlight(withRequestID(withCORS(allowedOrigins, http.DefaultServeMux))),
+	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalln("HTTP server failed", err)
+		}
+	}()
+
+	// 4. Wait for SIGINT/SIGTERM, then let in-flight requests (including
+	// synchronous step completions waiting on updates) finish
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	draining := inFlightRequests.Load()
+	log.Printf("Shutting down, draining %d in-flight request(s)...", draining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown timed out with %d request(s) still in flight: %v", inFlightRequests.Load(), err)
+		return
+	}
+	log.Printf("Drained %d request(s), server stopped", draining)
+}
+
+// trackInFlight counts requests while they are being served
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// orderIDPrefix namespaces order workflow IDs in Temporal. Clients only ever
+// see the short ID (the UUID); the prefix is added and stripped at the edges.
+const orderIDPrefix = "pizza-orders/"
+
+// toWorkflowID converts a client-facing short order ID into its workflow ID
+func toWorkflowID(shortID string) string {
+	return orderIDPrefix + shortID
+}
+
+// toShortID converts a workflow ID into the client-facing short order ID
+func toShortID(workflowID string) string {
+	return strings.TrimPrefix(workflowID, orderIDPrefix)
+}
+
+// stepAction maps a URL action onto the DAG component and workflow update it completes
+type stepAction struct {
+	component  types.ComponentType
+	updateName string
+	newInput   func() interface{} // Returns a pointer to the update's typed input
+}
+
+// stepActions lists every action accepted by POST /orders/{orderID}/{action}
+var stepActions = map[string]stepAction{
+	"payment":      {types.ComponentPayment, workflow.UpdateCompletePayment, func() interface{} { return &workflow.CompletePaymentInput{} }},
+	"make-dough":   {types.ComponentMakeDough, workflow.UpdateMakeDough, func() interface{} { return &workflow.MakeDoughInput{} }},
+	"proof-dough":  {types.ComponentProofDough, workflow.UpdateProofDough, func() interface{} { return &workflow.ProofDoughInput{} }},
+	"add-sauce":    {types.ComponentAddSauce, workflow.UpdateAddSauce, func() interface{} { return &workflow.AddSauceInput{} }},
+	"add-cheese":   {types.ComponentAddCheese, workflow.UpdateAddCheese, func() interface{} { return &workflow.AddCheeseInput{} }},
+	"add-toppings": {types.ComponentAddToppings, workflow.UpdateAddToppings, func() interface{} { return &workflow.AddToppingsInput{} }},
+	"bake":         {types.ComponentBakePizza, workflow.UpdateBakePizza, func() interface{} { return &workflow.BakePizzaInput{} }},
+	"deliver":      {types.ComponentDeliver, workflow.UpdateDeliver, func() interface{} { return &workflow.DeliverInput{} }},
+	"pickup-ready": {types.ComponentPickupReady, workflow.UpdatePickupReady, func() interface{} { return &workflow.PickupReadyInput{} }},
+	"proof":        {types.ComponentPhotoProof, workflow.UpdateUploadProof, func() interface{} { return &workflow.UploadProofInput{} }},
+	"verify-age":   {types.ComponentAgeVerification, workflow.UpdateVerifyAge, func() interface{} { return &workflow.VerifyAgeInput{} }},
+}
+
+// actionForComponent finds the URL action that completes a component.
+// Custom steps are completed via components/{type}/complete.
+func actionForComponent(componentType types.ComponentType) (string, bool) {
+	for action, step := range stepActions {
+		if step.component == componentType {
+			return action, true
+		}
+	}
+	if workflow.IsCustomStep(componentType) {
+		return "components/" + strings.ToLower(string(componentType)) + "/complete", true
+	}
+	return "", false
+}
+
+// lookupStepAction resolves a URL action, including custom step actions
+// (components/{type}/complete), to the update that completes it
+func lookupStepAction(action string) (stepAction, bool) {
+	if step, ok := stepActions[action]; ok {
+		return step, true
+	}
+	parts := strings.Split(action, "/")
+	if len(parts) != 3 || parts[0] != "components" || parts[2] != "complete" {
+		return stepAction{}, false
+	}
+	componentType := types.ComponentType(strings.ToUpper(parts[1]))
+	if !workflow.IsCustomStep(componentType) {
+		return stepAction{}, false
+	}
+	return stepAction{componentType, workflow.CustomStepUpdateName(componentType), func() interface{} { return &workflow.CustomStepInput{} }}, true
 }
 
-// handleOrders handles POST /orders (create new order)
+// handleOrders handles POST /orders (create new order) and GET /orders (list orders)
 func handleOrders(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		createOrder(w, r)
+	} else if r.Method == http.MethodGet {
+		listOrders(w, r)
 	} else {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// Page sizes for GET /orders
+const (
+	defaultOrderListLimit = 20
+	maxOrderListLimit     = 100
+
+	// maxOrderListRequests bounds the visibility requests behind one filtered
+	// page, so a rare state can't scan every order. Past it the page comes
+	// back short, with a next_page_token to carry on from.
+	maxOrderListRequests = 10
+)
+
+// orderListEntry is one order in GET /orders, built from its visibility
+// record alone so listing never queries the workflows themselves
+type orderListEntry struct {
+	OrderID      string           `json:"order_id"`
+	CustomerName string           `json:"customer_name"`
+	State        types.OrderState `json:"state"`
+	CreateTime   time.Time        `json:"create_time"`
+}
+
+// orderListStatuses maps the ?state filter onto the workflow execution status
+// to query. COMPLETED, CANCELLED and FAILED orders all close as completed
+// workflows, so those pages are narrowed down further by the order_state memo.
+var orderListStatuses = map[types.OrderState]string{
+	types.OrderStateInProgress: "Running",
+	types.OrderStateCompleted:  "Completed",
+	types.OrderStateCancelled:  "Completed",
+	types.OrderStateFailed:     "Completed",
+}
+
+// listOrders pages through orders using Temporal visibility.
+// ?state filters by order state, ?limit sets the page size and
+// ?next_page_token continues from the previous page.
+func listOrders(w http.ResponseWriter, r *http.Request) {
+	query := fmt.Sprintf("WorkflowType = '%s'", workflow.PizzaOrderWorkflowName)
+	stateFilter := types.OrderState(r.URL.Query().Get("state"))
+	if stateFilter != "" {
+		status, ok := orderListStatuses[stateFilter]
+		if !ok {
+			http.Error(w, "state must be IN_PROGRESS, COMPLETED, CANCELLED or FAILED", http.StatusBadRequest)
+			return
+		}
+		query += fmt.Sprintf(" AND ExecutionStatus = '%s'", status)
+	}
+
+	limit := defaultOrderListLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxOrderListLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxOrderListLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	pageToken, err := base64.URLEncoding.DecodeString(r.URL.Query().Get("next_page_token"))
+	if err != nil {
+		http.Error(w, "Invalid next_page_token", http.StatusBadRequest)
+		return
+	}
+
+	// The order state is only in the memo, which visibility can't query, so a
+	// filtered page drops other states here and fetches more to fill up. Each
+	// request asks only for what's missing, since a token can't point mid-page.
+	orders := []orderListEntry{}
+	for requests := 0; requests < maxOrderListRequests; requests++ {
+		resp, err := temporalClient.ListWorkflow(r.Context(), &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         query,
+			PageSize:      int32(limit - len(orders)),
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			logErrorf(r, "Failed to list orders: %v", err)
+			http.Error(w, "Failed to list orders", http.StatusInternalServerError)
+			return
+		}
+
+		for _, execution := range resp.GetExecutions() {
+			entry := orderListEntry{
+				OrderID:    toShortID(execution.GetExecution().GetWorkflowId()),
+				State:      orderStateOf(execution),
+				CreateTime: execution.GetStartTime().AsTime(),
+			}
+			decodeMemo(execution, workflow.MemoCustomerName, &entry.CustomerName)
+			if stateFilter != "" && entry.State != stateFilter {
+				continue
+			}
+			orders = append(orders, entry)
+		}
+		pageToken = resp.GetNextPageToken()
+		if len(orders) >= limit || len(pageToken) == 0 {
+			break
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"orders":          orders,
+		"next_page_token": base64.URLEncoding.EncodeToString(pageToken),
+	}, nil)
+}
+
+// orderStateOf derives the order state from a visibility record: the
+// order_state memo once the order has finished, otherwise the execution
+// status (e.g. TERMINATED for orders an operator terminated)
+func orderStateOf(execution *workflowpb.WorkflowExecutionInfo) types.OrderState {
+	var state types.OrderState
+	if decodeMemo(execution, workflow.MemoOrderState, &state) {
+		return state
+	}
+	switch execution.GetStatus() {
+	case enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING:
+		return types.OrderStateInProgress
+	case enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED:
+		return types.OrderStateCompleted
+	default:
+		return types.OrderState(strings.TrimPrefix(execution.GetStatus().String(), "WORKFLOW_EXECUTION_STATUS_"))
+	}
+}
+
+// decodeMemo reads one memo field into valuePtr, reporting whether it was set
+func decodeMemo(execution *workflowpb.WorkflowExecutionInfo, key string, valuePtr interface{}) bool {
+	payload, ok := execution.GetMemo().GetFields()[key]
+	if !ok {
+		return false
+	}
+	return converter.GetDefaultDataConverter().FromPayload(payload, valuePtr) == nil
+}
+
+// handleTemplates handles GET /templates (list DAG templates and their steps)
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	templates := []map[string]interface{}{}
+	for _, template := range types.DefaultTemplates.Templates() {
+		templates = append(templates, map[string]interface{}{
+			"name":        template.Name,
+			"description": template.Description,
+			"steps":       template.Build(types.Now()).Definitions(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"templates": templates,
+	}, nil)
+}
+
+// handleCustomers handles GET /customers/{email}/points (loyalty balance)
+func handleCustomers(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/customers/"), "/")
+	if r.Method != http.MethodGet || len(parts) != 2 || parts[1] != "points" || parts[0] == "" {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	email := parts[0]
+
+	points, err := loyaltyLedger.Points(email)
+	if err != nil {
+		logErrorf(r, "Failed to read loyalty points for %s: %v", email, err)
+		http.Error(w, "Failed to get loyalty points", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"customer_email": email,
+		"points":         points,
+	}, nil)
+}
+
+// maxThroughputOrders caps how many completed orders one stats request loads
+const maxThroughputOrders = 1000
+
+// handleThroughput handles GET /stats/throughput?window=1h: how many orders
+// completed in the window and how long they took end to end
+func handleThroughput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window := time.Hour
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			http.Error(w, "window must be a positive duration, e.g. 1h or 30m", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+	orders, err := completedOrdersSince(r.Context(), start)
+	if err != nil {
+		logErrorf(r, "Failed to list completed orders: %v", err)
+		http.Error(w, "Failed to load completed orders", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, types.Throughput(orders, start, end), nil)
+}
+
+// completedOrdersSince loads the final state of orders that completed after
+// since. Closed workflows stay in Temporal's visibility store for the
+// namespace retention period, so windows longer than that undercount.
+func completedOrdersSince(ctx context.Context, since time.Time) ([]*types.PizzaOrder, error) {
+	query := fmt.Sprintf("WorkflowType = '%s' AND ExecutionStatus = 'Completed' AND CloseTime >= '%s'",
+		workflow.PizzaOrderWorkflowName, since.UTC().Format(time.RFC3339))
+
+	var orders []*types.PizzaOrder
+	var pageToken []byte
+	for {
+		resp, err := temporalClient.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         query,
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, execution := range resp.GetExecutions() {
+			var order types.PizzaOrder
+			run := temporalClient.GetWorkflow(ctx, execution.GetExecution().GetWorkflowId(), execution.GetExecution().GetRunId())
+			if err := run.Get(ctx, &order); err != nil {
+				return nil, err
+			}
+			orders = append(orders, &order)
+			if len(orders) >= maxThroughputOrders {
+				return orders, nil
+			}
+		}
+
+		pageToken = resp.GetNextPageToken()
+		if len(pageToken) == 0 {
+			return orders, nil
+		}
+	}
+}
+
 // handleOrderActions handles GET and POST for specific orders
 func handleOrderActions(w http.ResponseWriter, r *http.Request) {
 	// Parse URL: /orders/{orderID}/{action}
@@ -66,7 +557,28 @@ func handleOrderActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	orderID := "pizza-orders/" + parts[0]
+	// POST /orders/validate - dry-run check of a custom step graph
+	if len(parts) == 1 && parts[0] == "validate" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		validateSteps(w, r)
+		return
+	}
+
+	// POST /orders/import - admin only, resume an exported order bundle
+	if len(parts) == 1 && parts[0] == "import" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		importOrder(w, r)
+		return
+	}
+
+	orderID := toWorkflowID(parts[0])
+	r = withOrderID(r, orderID)
 
 	// GET /orders/{orderID} - get status
 	if r.Method == http.MethodGet && len(parts) == 1 {
@@ -74,6 +586,55 @@ func handleOrderActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// GET /orders/{orderID}/actions - list steps that can be completed now
+	// (also served as /available-actions for frontends)
+	if r.Method == http.MethodGet && len(parts) == 2 && (parts[1] == "actions" || parts[1] == "available-actions") {
+		getAvailableActions(w, r, orderID)
+		return
+	}
+
+	// POST /orders/{orderID}/reorder - start a new order like a completed one
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "reorder" {
+		reorder(w, r, orderID)
+		return
+	}
+
+	// POST /orders/{orderID}/remake - redo a completed order for free after a complaint
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "remake" {
+		remake(w, r, orderID)
+		return
+	}
+
+	// POST /orders/{orderID}/assign-driver - dispatcher override of the delivery service
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "assign-driver" {
+		assignDriver(w, r, orderID)
+		return
+	}
+
+	// POST /orders/{orderID}/sla - dispatcher sets the order's target completion time
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "sla" {
+		setOrderSLA(w, r, orderID)
+		return
+	}
+
+	// POST /orders/{orderID}/cancel - customer cancels, refunding any payment
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "cancel" {
+		cancelOrder(w, r, orderID)
+		return
+	}
+
+	// DELETE /orders/{orderID}/items/{itemID} - take an unbaked item off the order
+	if r.Method == http.MethodDelete && len(parts) == 3 && parts[1] == "items" {
+		removeItem(w, r, orderID, parts[2])
+		return
+	}
+
+	// POST /orders/{orderID}/terminate - admin only, forcibly stop a wedged order
+	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "terminate" {
+		terminateOrder(w, r, orderID)
+		return
+	}
+
 	// POST /orders/{orderID}/{action} - complete a step
 	if r.Method == http.MethodPost && len(parts) == 2 {
 		action := parts[1]
@@ -81,183 +642,1278 @@ func handleOrderActions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	http.Error(w, "Invalid request", http.StatusBadRequest)
-}
-
-// createOrder creates a new pizza order workflow
-func createOrder(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		CustomerName    string  `json:"customer_name"`
-		CustomerEmail   string  `json:"customer_email"`
-		CustomerPhone   string  `json:"customer_phone"`
-		DeliveryAddress string  `json:"delivery_address"`
-		Amount          float64 `json:"amount"`
+	// GET /orders/{orderID}/tracking - current delivery tracking snapshot
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "tracking" {
+		getTracking(w, r, orderID)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	// GET /orders/{orderID}/events-log - business-level event log
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "events-log" {
+		getEventLog(w, r, orderID)
 		return
 	}
 
-	if req.CustomerName == "" {
-		http.Error(w, "customer_name is required", http.StatusBadRequest)
+	// GET /orders/{orderID}/export - self-contained bundle for archival or transfer
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "export" {
+		exportOrder(w, r, orderID)
 		return
 	}
 
-	// Set defaults
-	if req.CustomerEmail == "" {
-		req.CustomerEmail = fmt.Sprintf("%s@example.com", req.CustomerName)
-	}
-	if req.CustomerPhone == "" {
-		req.CustomerPhone = "+1-555-0100"
+	// GET /orders/{orderID}/graph.dot - component graph for Graphviz
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "graph.dot" {
+		getOrderGraph(w, r, orderID)
+		return
 	}
-	if req.DeliveryAddress == "" {
-		req.DeliveryAddress = "123 Main St, San Francisco, CA"
+
+	// GET /orders/{orderID}/stream - WebSocket pushing the order as it changes
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "stream" {
+		streamOrder(w, r, orderID)
+		return
 	}
-	if req.Amount == 0 {
-		req.Amount = 19.99 // Default pizza price
+
+	// GET /orders/{orderID}/print - compact kitchen ticket for thermal printers
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "print" {
+		printKitchenTicket(w, r, orderID)
+		return
 	}
 
-	// Generate workflow ID
-	orderID := fmt.Sprintf("pizza-orders/%s", uuid.New().String())
+	// GET /orders/{orderID}/receipt - itemized pricing
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "receipt" {
+		getReceipt(w, r, orderID)
+		return
+	}
 
-	// Start Temporal workflow
-	workflowOptions := client.StartWorkflowOptions{
-		ID:        orderID,
-		TaskQueue: workflow.PizzaOrderTaskQueue,
+	// GET /orders/{orderID}/durations - actual time spent on each step
+	if r.Method == http.MethodGet && len(parts) == 2 && parts[1] == "durations" {
+		getStepDurations(w, r, orderID)
+		return
 	}
 
-	input := &workflow.PizzaOrderInput{
-		OrderID:         orderID,
-		CustomerName:    req.CustomerName,
-		CustomerEmail:   req.CustomerEmail,
-		CustomerPhone:   req.CustomerPhone,
-		DeliveryAddress: req.DeliveryAddress,
-		Amount:          req.Amount,
+	// PATCH /orders/{orderID}/notifications - change notification preferences
+	if r.Method == http.MethodPatch && len(parts) == 2 && parts[1] == "notifications" {
+		updateNotificationPrefs(w, r, orderID)
+		return
 	}
 
-	we, err := temporalClient.ExecuteWorkflow(r.Context(), workflowOptions, workflow.PizzaOrderWorkflow, input)
-	if err != nil {
-		log.Printf("Failed to start workflow: %v", err)
-		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+	// GET /orders/{orderID}/components/{type} - one step's state, for polling
+	if r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "components" {
+		componentType := types.ComponentType(strings.ToUpper(parts[2]))
+		getComponentState(w, r, orderID, componentType)
 		return
 	}
 
-	log.Printf("Started workflow - OrderID: %s, WorkflowID: %s, RunID: %s",
-		orderID, we.GetID(), we.GetRunID())
+	// POST /orders/{orderID}/components/{type}/complete - complete a custom step
+	if r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "components" && parts[3] == "complete" {
+		completeStep(w, r, orderID, strings.Join(parts[1:], "/"))
+		return
+	}
 
-	// Query the workflow to get initial state
-	var state types.PizzaOrder
-	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryOrderState)
-	if err != nil {
-		log.Printf("Failed to query workflow: %v", err)
-		// Return basic response even if query fails
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"order_id":      orderID,
-			"customer_name": req.CustomerName,
-			"state":         "IN_PROGRESS",
-		})
+	// POST /orders/{orderID}/components/{type}/retry - retry a stuck step
+	if r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "components" && parts[3] == "retry" {
+		componentType := types.ComponentType(strings.ToUpper(parts[2]))
+		retryComponent(w, r, orderID, componentType)
 		return
 	}
 
-	if err := value.Get(&state); err != nil {
-		log.Printf("Failed to decode state: %v", err)
-		http.Error(w, "Failed to get order state", http.StatusInternalServerError)
+	// POST /orders/{orderID}/components/{type}/reset - reopen a completed step
+	if r.Method == http.MethodPost && len(parts) == 4 && parts[1] == "components" && parts[3] == "reset" {
+		componentType := types.ComponentType(strings.ToUpper(parts[2]))
+		resetComponent(w, r, orderID, componentType)
 		return
 	}
 
-	// Return the full state including DAG
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"order_id":      state.OrderID,
-		"customer_name": state.CustomerName,
-		"state":         state.State,
-		"components":    state.DAG.GetComponents(),
-		"create_time":   state.CreateTime,
-	})
+	http.Error(w, "Invalid request", http.StatusBadRequest)
 }
 
-// getOrderStatus queries the workflow for current state
-func getOrderStatus(w http.ResponseWriter, r *http.Request, orderID string) {
-	// Query workflow (read-only, doesn't modify state)
-	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryOrderState)
-	if err != nil {
-		log.Printf("Failed to query workflow %s: %v", orderID, err)
-		http.Error(w, "Order not found", http.StatusNotFound)
+// createOrder creates a new pizza order workflow
+func createOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CustomerName    string   `json:"customer_name"`
+		CustomerEmail   string   `json:"customer_email"`
+		CustomerPhone   string   `json:"customer_phone"`
+		DeliveryAddress string   `json:"delivery_address"`
+		Amount          *float64 `json:"amount"` // Omitted means default price, explicit 0 means free
+		CompReason      string   `json:"comp_reason"`
+		TaxRate         float64  `json:"tax_rate"`    // Fraction, e.g. 0.0875
+		Complexity      string   `json:"complexity"`  // "SIMPLE" (default) or "GOURMET"
+		Template        string   `json:"template"`    // DAG template, see GET /templates
+		Fulfillment     string   `json:"fulfillment"` // "DELIVERY" (default) or "PICKUP"
+
+		DeliveryWindowStart *time.Time `json:"delivery_window_start"` // RFC 3339, optional
+		DeliveryWindowEnd   *time.Time `json:"delivery_window_end"`
+
+		PaymentSplits   []types.PaymentSplit `json:"payment_splits"` // Optional; must add up to the total charged
+		ContainsAlcohol bool                 `json:"contains_alcohol"`
+
+		Steps []types.StepDefinition `json:"steps"` // Custom graph instead of a template
+		Items []types.OrderItem      `json:"items"` // Several pizzas in one delivery; amount defaults to their sum
+
+		AutoAdvance bool   `json:"auto_advance"`
+		StepDelay   string `json:"step_delay"` // Duration, e.g. "5s"
+
+		// Overrides of the payment and delivery activity options; omitted keeps the defaults
+		PaymentMaxAttempts  int    `json:"payment_max_attempts"`
+		PaymentTimeout      string `json:"payment_timeout"` // Duration, e.g. "10s"
+		DeliveryMaxAttempts int    `json:"delivery_max_attempts"`
+		DeliveryTimeout     string `json:"delivery_timeout"`
+
+		DeliveryPollInterval string `json:"delivery_poll_interval"` // Duration between delivery status checks
+		OrderTimeout         string `json:"order_timeout"`          // Cancel if not done within this duration
+
+		NotificationPreference string `json:"notification_preference"` // SMS, EMAIL, PUSH or NONE
+	}
+
+	if !decodeJSON(w, r, &req, false) {
 		return
 	}
 
-	var state types.PizzaOrder
-	if err := value.Get(&state); err != nil {
-		log.Printf("Failed to decode state: %v", err)
-		http.Error(w, "Failed to get order state", http.StatusInternalServerError)
+	// Omitted means the default price, or the items' sum; the rest is
+	// defaulted by Normalize
+	if req.Amount == nil && len(req.Items) == 0 {
+		defaultAmount := defaultOrderAmount
+		req.Amount = &defaultAmount
+	}
+	durations := map[string]string{
+		"step_delay":       req.StepDelay,
+		"payment_timeout":  req.PaymentTimeout,
+		"delivery_timeout": req.DeliveryTimeout,
+
+		"delivery_poll_interval": req.DeliveryPollInterval,
+		"order_timeout":          req.OrderTimeout,
+	}
+	parsed := make(map[string]time.Duration, len(durations))
+	for field, value := range durations {
+		if value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, field+" must be a duration, e.g. 5s", http.StatusBadRequest)
+			return
+		}
+		parsed[field] = d
+	}
+	// Normalize checks the window's shape; only the API can check it's upcoming
+	if req.DeliveryWindowStart != nil && !req.DeliveryWindowStart.After(time.Now()) {
+		http.Error(w, "delivery_window_start must be in the future", http.StatusBadRequest)
 		return
 	}
 
-	// Return state including DAG
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"order_id":      state.OrderID,
-		"customer_name": state.CustomerName,
-		"state":         state.State,
-		"components":    state.DAG.GetComponents(),
-		"create_time":   state.CreateTime,
-		"update_time":   state.UpdateTime,
+	var amount float64
+	if req.Amount != nil {
+		amount = *req.Amount
+	}
+	startOrder(w, r, &workflow.PizzaOrderInput{
+		CustomerName:    req.CustomerName,
+		CustomerEmail:   req.CustomerEmail,
+		CustomerPhone:   req.CustomerPhone,
+		DeliveryAddress: req.DeliveryAddress,
+		Amount:          amount,
+		CompReason:      req.CompReason,
+		TaxRate:         req.TaxRate,
+		Complexity:      req.Complexity,
+		DAGTemplate:     req.Template,
+		Fulfillment:     req.Fulfillment,
+
+		DeliveryWindowStart: req.DeliveryWindowStart,
+		DeliveryWindowEnd:   req.DeliveryWindowEnd,
+
+		PaymentSplits:   req.PaymentSplits,
+		ContainsAlcohol: req.ContainsAlcohol,
+
+		Steps: req.Steps,
+		Items: req.Items,
+
+		AutoAdvance: req.AutoAdvance,
+		StepDelay:   parsed["step_delay"],
+
+		PaymentMaxAttempts:  req.PaymentMaxAttempts,
+		PaymentTimeout:      parsed["payment_timeout"],
+		DeliveryMaxAttempts: req.DeliveryMaxAttempts,
+		DeliveryTimeout:     parsed["delivery_timeout"],
+
+		DeliveryPollInterval: parsed["delivery_poll_interval"],
+		OrderTimeout:         parsed["order_timeout"],
+
+		NotificationPreference: req.NotificationPreference,
 	})
 }
 
-// completeStep sends an update to complete a component
-func completeStep(w http.ResponseWriter, r *http.Request, orderID, action string) {
-	// Map action to update name
-	var updateName string
-	switch action {
-	case "payment":
-		updateName = workflow.UpdateCompletePayment
-	case "make-dough":
-		updateName = workflow.UpdateMakeDough
-	case "add-toppings":
-		updateName = workflow.UpdateAddToppings
-	case "bake":
-		updateName = workflow.UpdateBakePizza
-	case "deliver":
-		updateName = workflow.UpdateDeliver
-	default:
-		http.Error(w, "Unknown action", http.StatusBadRequest)
+// validateSteps checks a custom step graph exactly as POST /orders would,
+// without starting a workflow, and returns the steps in dependency order
+func validateSteps(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Steps []types.StepDefinition `json:"steps"`
+	}
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	if len(req.Steps) == 0 {
+		http.Error(w, "steps is required", http.StatusBadRequest)
 		return
 	}
 
-	// Send update to workflow (this modifies state!)
-	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
-		WorkflowID:   orderID,
-		UpdateName:   updateName,
-		WaitForStage: client.WorkflowUpdateStageCompleted, // Wait for result
-	})
+	dag, err := workflow.ValidateSteps(req.Steps)
 	if err != nil {
-		log.Printf("Failed to update workflow %s: %v", orderID, err)
-		http.Error(w, fmt.Sprintf("Failed to complete step: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Invalid steps: %v", err), http.StatusBadRequest)
 		return
 	}
-
+	ordered, err := dag.TopologicalSort()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid steps: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	order := make([]types.ComponentType, 0, len(ordered))
+	for _, c := range ordered {
+		order = append(order, c.Type)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"valid":         true,
+		"ordered_steps": order,
+	}, nil)
+}
+
+// reorder starts a new order with the customer details, address, amount and
+// toppings of a completed one. Only orders still within Temporal's retention
+// period can be reordered.
+func reorder(w http.ResponseWriter, r *http.Request, sourceID string) {
+	var req struct {
+		CustomerName string `json:"customer_name"` // Must match the source order
+	}
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	if req.CustomerName == "" {
+		http.Error(w, "customer_name is required", http.StatusBadRequest)
+		return
+	}
+
+	source, ok := loadFullOrder(w, r, sourceID)
+	if !ok {
+		return
+	}
+
+	if source.CustomerName != req.CustomerName {
+		http.Error(w, "Order belongs to a different customer", http.StatusForbidden)
+		return
+	}
+	if source.State != types.OrderStateCompleted {
+		http.Error(w, "Only completed orders can be reordered", http.StatusConflict)
+		return
+	}
+
+	// A comped order is reordered at the regular price. Items bring their
+	// own prices and steps.
+	amount := source.Subtotal
+	if amount == 0 {
+		amount = defaultOrderAmount
+	}
+	steps := customSteps(source)
+	if len(source.Items) > 0 {
+		steps = nil
+	}
+
+	logf(r, "Reordering %s for %s", sourceID, source.CustomerName)
+	startOrder(w, r, &workflow.PizzaOrderInput{
+		CustomerName:    source.CustomerName,
+		CustomerEmail:   source.CustomerEmail,
+		CustomerPhone:   source.CustomerPhone,
+		DeliveryAddress: source.DeliveryAddress,
+		Amount:          amount,
+		TaxRate:         source.TaxRate,
+		Complexity:      source.Complexity,
+		DAGTemplate:     source.Template,
+		Steps:           steps,
+		Items:           source.Items,
+		Fulfillment:     source.Fulfillment,
+		Toppings:        source.Toppings,
+		ContainsAlcohol: source.ContainsAlcohol,
+
+		NotificationPreference: source.NotificationPrefs.Channel,
+	})
+}
+
+// customSteps returns the graph of an order built from custom steps, so a
+// new order can be started with the same steps; nil for template orders.
+// AGE_VERIFICATION is left out since ContainsAlcohol adds it back.
+func customSteps(source *types.PizzaOrder) []types.StepDefinition {
+	if source.Template != types.TemplateCustom {
+		return nil
+	}
+	dag := source.DAG.Clone()
+	if _, err := dag.GetComponent(types.ComponentAgeVerification); err == nil {
+		if err := dag.RemoveComponent(types.ComponentAgeVerification, types.Now()); err != nil {
+			return nil
+		}
+	}
+	return dag.Definitions()
+}
+
+// remake redoes a completed order at no charge after a customer complaint.
+// A completed workflow can't be reopened, so the remake is a new free order
+// linked to the original; the original payment is left untouched.
+func remake(w http.ResponseWriter, r *http.Request, sourceID string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	source, ok := loadFullOrder(w, r, sourceID)
+	if !ok {
+		return
+	}
+	if source.State != types.OrderStateCompleted {
+		http.Error(w, "Only completed orders can be remade", http.StatusConflict)
+		return
+	}
+	if time.Since(source.UpdateTime) > workflow.RemakeWindow {
+		http.Error(w, fmt.Sprintf("Orders can only be remade within %s of completion", workflow.RemakeWindow), http.StatusConflict)
+		return
+	}
+
+	logf(r, "Remaking %s for %s: %s", sourceID, source.CustomerName, req.Reason)
+	startOrder(w, r, &workflow.PizzaOrderInput{
+		CustomerName:    source.CustomerName,
+		CustomerEmail:   source.CustomerEmail,
+		CustomerPhone:   source.CustomerPhone,
+		DeliveryAddress: source.DeliveryAddress,
+		Amount:          0, // Free - the original payment covers it
+		CompReason:      fmt.Sprintf("Remake of %s", toShortID(sourceID)),
+		Complexity:      source.Complexity,
+		DAGTemplate:     source.Template,
+		Steps:           customSteps(source),
+		Fulfillment:     source.Fulfillment,
+		Toppings:        source.Toppings,
+		ContainsAlcohol: source.ContainsAlcohol,
+		RemakeOf:        source.OrderID,
+		RemakeReason:    req.Reason,
+
+		NotificationPreference: source.NotificationPrefs.Channel,
+	})
+}
+
+// startOrder normalizes the input, starts an order workflow and writes the
+// 201 response. The order ID is generated here.
+func startOrder(w http.ResponseWriter, r *http.Request, input *workflow.PizzaOrderInput) {
+	input.DeliveryRetryAttempts = deliveryRetryAttempts
+	input.DeliveryRetryInterval = deliveryRetryInterval
+	if err := input.Normalize(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reject undeliverable addresses now rather than failing the workflow later
+	if input.Fulfillment == types.FulfillmentDelivery {
+		if km := activities.DistanceForAddress(input.DeliveryAddress); km > activities.MaxDeliveryRadiusKm {
+			http.Error(w, fmt.Sprintf("Address is outside delivery area (%.1f km, max %.1f km)", km, activities.MaxDeliveryRadiusKm),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Generate workflow ID
+	orderID := toWorkflowID(uuid.New().String())
+	r = withOrderID(r, orderID)
+
+	// Start Temporal workflow
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        orderID,
+		TaskQueue: workflow.PizzaOrderTaskQueue,
+		Memo: map[string]interface{}{
+			workflow.MemoCustomerName: input.CustomerName,
+			workflow.MemoAmount:       input.Amount,
+
+			// Lets worker logs be correlated with the API request
+			workflow.MemoRequestID: w.Header().Get(requestIDHeader),
+		},
+	}
+
+	input.OrderID = orderID
+
+	we, err := temporalClient.ExecuteWorkflow(r.Context(), workflowOptions, workflow.PizzaOrderWorkflow, input)
+	if err != nil {
+		logErrorf(r, "Failed to start workflow: %v", err)
+		http.Error(w, "Failed to create order", http.StatusInternalServerError)
+		return
+	}
+
+	logf(r, "Started workflow - OrderID: %s, WorkflowID: %s, RunID: %s",
+		orderID, we.GetID(), we.GetRunID())
+	metrics.OrdersCreated.Inc()
+
+	// Point clients at the new resource
+	w.Header().Set("Location", "/orders/"+toShortID(orderID))
+
+	// Query the workflow to get initial state
+	var state types.PizzaOrder
+	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryOrderState)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow: %v", err)
+		// Return basic response even if query fails
+		writeJSON(w, http.StatusCreated, map[string]interface{}{
+			"order_id":      toShortID(orderID),
+			"run_id":        we.GetRunID(),
+			"customer_name": input.CustomerName,
+			"state":         "IN_PROGRESS",
+		}, nil)
+		return
+	}
+
+	if err := value.Get(&state); err != nil {
+		logErrorf(r, "Failed to decode state: %v", err)
+		http.Error(w, "Failed to get order state", http.StatusInternalServerError)
+		return
+	}
+
+	// Return the full state including DAG
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"run_id":        we.GetRunID(),
+		"customer_name": state.CustomerName,
+		"state":         state.State,
+		"components":    state.DAG.GetComponents(),
+		"create_time":   state.CreateTime,
+	}, nil)
+}
+
+// loadOrder queries the order's current state, writing an error response if
+// that fails. Results are cached briefly; ?fresh=true bypasses the cache,
+// ?redact=true masks customer contact details and ?run_id= reads a specific
+// workflow run instead of the latest.
+func loadOrder(w http.ResponseWriter, r *http.Request, orderID string) (*types.PizzaOrder, bool) {
+	state, ok := loadFullOrder(w, r, orderID)
+	if ok && r.URL.Query().Get("redact") == "true" {
+		return state.Redacted(), true
+	}
+	return state, ok
+}
+
+// loadFullOrder fetches the order, unredacted, from the cache or the workflow
+func loadFullOrder(w http.ResponseWriter, r *http.Request, orderID string) (*types.PizzaOrder, bool) {
+	// The cache only holds the latest run, so a specific run is always queried
+	runID := r.URL.Query().Get("run_id")
+	if runID != "" {
+		if _, err := uuid.Parse(runID); err != nil {
+			http.Error(w, "run_id must be a workflow run ID", http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	fresh := r.URL.Query().Get("fresh") == "true" || runID != ""
+	if !fresh {
+		if state, ok := orderCache.Get(orderID); ok {
+			return state, true
+		}
+	}
+
+	state, err := queryOrderRun(r.Context(), orderID, runID)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow %s (run %q): %v", orderID, runID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return nil, false
+	}
+
+	if runID == "" {
+		orderCache.Put(orderID, state)
+	}
+	return state, true
+}
+
+// queryOrder fetches the order's current state from the workflow, bypassing the cache
+func queryOrder(ctx context.Context, orderID string) (*types.PizzaOrder, error) {
+	return queryOrderRun(ctx, orderID, "")
+}
+
+// queryOrderRun queries one run of the order's workflow; an empty runID
+// targets the latest run
+func queryOrderRun(ctx context.Context, orderID, runID string) (*types.PizzaOrder, error) {
+	value, err := temporalClient.QueryWorkflow(ctx, orderID, runID, workflow.QueryOrderState)
+	if err != nil {
+		return nil, err
+	}
+
+	var state types.PizzaOrder
+	if err := value.Get(&state); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %w", err)
+	}
+	return &state, nil
+}
+
+// getOrderStatus queries the workflow for current state
+func getOrderStatus(w http.ResponseWriter, r *http.Request, orderID string) {
+	// Query workflow (read-only, doesn't modify state)
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	// Return state including DAG
+	response := map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"run_id":        state.RunID,
+		"customer_name": state.CustomerName,
+		"state":         state.State,
+		"components":    state.DAG.ComponentsByDisplayOrder(),
+		"create_time":   state.CreateTime,
+		"update_time":   state.UpdateTime,
+		// Delivery has its own lifecycle - see PizzaOrder.OverallStatus
+		"delivery_status": state.DeliveryStatus,
+		"overall_status":  state.OverallStatus(),
+	}
+	// Dependency order, for drawing the graph left to right
+	if ordered, err := state.DAG.TopologicalSort(); err == nil {
+		response["ordered_components"] = ordered
+	}
+	// ETA from the step estimates: the slowest chain through the graph
+	if path, total := state.DAG.CriticalPath(); len(path) > 0 {
+		response["critical_path"] = path
+		response["estimated_total_time"] = total.String()
+	}
+	if state.HasDeliveryWindow() {
+		response["delivery_window"] = map[string]interface{}{
+			"start": state.DeliveryWindowStart,
+			"end":   state.DeliveryWindowEnd,
+		}
+		response["estimated_arrival"] = state.EstimatedArrival
+	}
+	if state.RemakeOf != "" {
+		response["remake_of"] = toShortID(state.RemakeOf)
+		response["remake_reason"] = state.RemakeReason
+	}
+	if state.SLADeadline != nil {
+		remaining := time.Until(*state.SLADeadline)
+		if remaining < 0 || state.State != types.OrderStateInProgress {
+			remaining = 0
+		}
+		response["sla"] = map[string]interface{}{
+			"target":            state.SLADeadline.Sub(state.CreateTime).String(),
+			"deadline":          state.SLADeadline,
+			"remaining_seconds": int(remaining.Seconds()),
+			"breached":          state.SLABreached,
+		}
+	}
+	if state.ExpiresAt != nil && state.State == types.OrderStateInProgress {
+		remaining := time.Until(*state.ExpiresAt)
+		if remaining < 0 {
+			remaining = 0
+		}
+		response["expires_at"] = state.ExpiresAt
+		response["expires_in"] = remaining.Round(time.Second).String()
+	}
+	if state.SummaryWebhook != nil {
+		response["summary_webhook"] = state.SummaryWebhook
+	}
+	// Audit log for the UI timeline, oldest first
+	response["history"] = state.History
+
+	writeJSON(w, http.StatusOK, response, nil)
+}
+
+// getAvailableActions lists the actions whose components are currently ready
+func getAvailableActions(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	actions := []string{}
+	for _, c := range state.DAG.GetReadyComponents() {
+		if action, ok := actionForComponent(c.Type); ok {
+			actions = append(actions, action)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id": toShortID(state.OrderID),
+		"actions":  actions,
+	}, nil)
+}
+
+// exportOrder returns the order as a self-contained JSON bundle: state, graph
+// definition, pricing and event log
+func exportOrder(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, types.NewOrderBundle(state, time.Now()), nil)
+}
+
+// importOrder resumes an exported bundle (the data of GET /orders/{id}/export)
+// as a new order. The bundle's payment is trusted as-is, so a paid order isn't
+// charged again and a cancel refunds it; that's why only admins may import.
+func importOrder(w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		http.Error(w, "Admin token required", http.StatusForbidden)
+		return
+	}
+
+	var bundle types.OrderBundle
+	if !decodeJSON(w, r, &bundle, false) {
+		return
+	}
+	input, err := workflow.ImportInput(&bundle)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid bundle: %v", err), http.StatusBadRequest)
+		return
+	}
+	logf(r, "Importing order %s", bundle.Order.OrderID)
+	startOrder(w, r, input)
+}
+
+// printKitchenTicket returns the kitchen ticket as JSON, or as printer-ready
+// text when the client sends Accept: text/plain
+func printKitchenTicket(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=us-ascii")
+		io.WriteString(w, types.FormatKitchenTicket(state))
+		return
+	}
+	writeJSON(w, http.StatusOK, types.NewKitchenTicket(state), nil)
+}
+
+// getOrderGraph renders the order's component graph as Graphviz DOT, colored
+// by each step's state
+func getOrderGraph(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	io.WriteString(w, state.DAG.ToDOT())
+}
+
+// getReceipt itemizes the order's subtotal, tax, tip, discount and total
+func getReceipt(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":       toShortID(state.OrderID),
+		"customer_name":  state.CustomerName,
+		"pricing":        state.PriceBreakdown(),
+		"payment_txn_id": state.PaymentTxnID,
+		"payment_splits": state.PaymentSplits,
+		"comp_reason":    state.CompReason,
+	}, nil)
+}
+
+// getTracking returns a tracking snapshot from the workflow: the delivery
+// status it polls for, plus the driver and ETA it recorded
+func getTracking(w http.ResponseWriter, r *http.Request, orderID string) {
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+
+	// The status is queried even when the order came from the cache, since
+	// the delivery poller changes it without other updates to the order
+	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, r.URL.Query().Get("run_id"), workflow.QueryDeliveryStatus)
+	if err != nil {
+		logErrorf(r, "Failed to query delivery status for %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+	var status string
+	if err := value.Get(&status); err != nil {
+		logErrorf(r, "Failed to decode delivery status: %v", err)
+		http.Error(w, "Failed to decode delivery status", http.StatusInternalServerError)
+		return
+	}
+	if status == "" {
+		http.Error(w, "Delivery not scheduled yet", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":     toShortID(state.OrderID),
+		"status":       status,
+		"driver":       state.DriverName,
+		"eta":          state.EstimatedArrival,
+		"last_updated": state.UpdateTime,
+	}, nil)
+}
+
+// getComponentState queries the workflow for a single component, which is
+// much smaller than the whole order for UIs that poll one step
+func getComponentState(w http.ResponseWriter, r *http.Request, orderID string, componentType types.ComponentType) {
+	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryComponentState, componentType)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	var component types.Component
+	if err := value.Get(&component); err != nil {
+		logErrorf(r, "Failed to decode component: %v", err)
+		http.Error(w, "Failed to get component state", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, component, nil)
+}
+
+// getStepDurations queries the workflow for per-step service times
+func getStepDurations(w http.ResponseWriter, r *http.Request, orderID string) {
+	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryStepDurations)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	var durations []types.StepDuration
+	if err := value.Get(&durations); err != nil {
+		logErrorf(r, "Failed to decode durations: %v", err)
+		http.Error(w, "Failed to get step durations", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":  toShortID(orderID),
+		"durations": durations,
+	}, nil)
+}
+
+// getEventLog queries the workflow for its chronological business event log
+func getEventLog(w http.ResponseWriter, r *http.Request, orderID string) {
+	value, err := temporalClient.QueryWorkflow(r.Context(), orderID, "", workflow.QueryEventLog)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	var entries []types.Event
+	if err := value.Get(&entries); err != nil {
+		logErrorf(r, "Failed to decode event log: %v", err)
+		http.Error(w, "Failed to get event log", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id": toShortID(orderID),
+		"events":   entries,
+	}, nil)
+}
+
+// updateNotificationPrefs signals the workflow with new notification preferences
+func updateNotificationPrefs(w http.ResponseWriter, r *http.Request, orderID string) {
+	var prefs types.NotificationPrefs
+	if !decodeJSON(w, r, &prefs, false) {
+		return
+	}
+	prefs.Channel = strings.ToUpper(prefs.Channel)
+	if !types.IsValidNotificationChannel(prefs.Channel) {
+		http.Error(w, "channel must be one of SMS, EMAIL, PUSH, NONE", http.StatusBadRequest)
+		return
+	}
+
+	// Signals are fire-and-forget: the workflow applies them asynchronously
+	defer orderCache.Invalidate(orderID)
+	err := temporalClient.SignalWorkflow(r.Context(), orderID, "", workflow.SignalUpdateNotificationPrefs, prefs)
+	if err != nil {
+		logErrorf(r, "Failed to signal workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	logf(r, "Updated notification preferences for order %s: %+v", orderID, prefs)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"order_id":           toShortID(orderID),
+		"notification_prefs": prefs,
+	}, nil)
+}
+
+// assignDriver signals the workflow with a dispatcher's manual driver choice.
+// The order must be ready for delivery: DELIVER ready but not yet done.
+func assignDriver(w http.ResponseWriter, r *http.Request, orderID string) {
+	var assignment workflow.DriverAssignment
+	if !decodeJSON(w, r, &assignment, false) {
+		return
+	}
+	if assignment.DriverName == "" {
+		http.Error(w, "driver_name is required", http.StatusBadRequest)
+		return
+	}
+
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	deliver, err := state.DAG.GetComponent(types.ComponentDeliver)
+	if err != nil {
+		http.Error(w, "Order is not a delivery order", http.StatusConflict)
+		return
+	}
+	if deliver.State != types.StateIncomplete {
+		http.Error(w, fmt.Sprintf("Order is not ready for delivery (DELIVER is %s)", deliver.State), http.StatusConflict)
+		return
+	}
+
+	// Signals are fire-and-forget: the workflow applies them asynchronously
+	defer orderCache.Invalidate(orderID)
+	err = temporalClient.SignalWorkflow(r.Context(), orderID, "", workflow.SignalAssignDriver, assignment)
+	if err != nil {
+		logErrorf(r, "Failed to signal workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	logf(r, "Manually assigned driver %s to order %s", assignment.DriverName, orderID)
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"order_id":    toShortID(orderID),
+		"driver_name": assignment.DriverName,
+		"manual":      true,
+	}, nil)
+}
+
+// setOrderSLA signals a new SLA: the order should complete within target of
+// its creation. Body: {"target": "45m"}
+func setOrderSLA(w http.ResponseWriter, r *http.Request, orderID string) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	target, err := time.ParseDuration(req.Target)
+	if err != nil || target <= 0 {
+		http.Error(w, "target must be a positive duration, e.g. 45m", http.StatusBadRequest)
+		return
+	}
+
+	state, ok := loadOrder(w, r, orderID)
+	if !ok {
+		return
+	}
+	if state.State != types.OrderStateInProgress {
+		http.Error(w, fmt.Sprintf("Order is %s", state.State), http.StatusConflict)
+		return
+	}
+
+	// Signals are fire-and-forget: the workflow applies them asynchronously
+	defer orderCache.Invalidate(orderID)
+	err = temporalClient.SignalWorkflow(r.Context(), orderID, "", workflow.SignalSetOrderSLA, workflow.OrderSLA{Target: target})
+	if err != nil {
+		logErrorf(r, "Failed to signal workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	deadline := state.CreateTime.Add(target)
+	logf(r, "Set SLA of order %s to %s (deadline %s)", orderID, target, deadline.Format(time.RFC3339))
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"order_id": toShortID(orderID),
+		"target":   target.String(),
+		"deadline": deadline,
+	}, nil)
+}
+
+// completeStep sends an update to complete a component
+func completeStep(w http.ResponseWriter, r *http.Request, orderID, action string) {
+	step, ok := lookupStepAction(action)
+	if !ok {
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	updateName := step.updateName
+	stepInput := step.newInput()
+
+	// Step data is optional - an empty body leaves the input at its zero value
+	if !decodeJSON(w, r, stepInput, true) {
+		return
+	}
+	// Idempotency-Key makes client retries safe: the workflow returns the prior
+	// result instead of running the step (and its activities) again
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		if keyed, ok := stepInput.(interface{ SetIdempotencyKey(string) }); ok {
+			keyed.SetIdempotencyKey(key)
+		}
+	}
+	if proof, ok := stepInput.(*workflow.UploadProofInput); ok && proof.PhotoURL == "" {
+		http.Error(w, "photo_url is required", http.StatusBadRequest)
+		return
+	}
+	if age, ok := stepInput.(*workflow.VerifyAgeInput); ok && age.VerificationToken == "" {
+		http.Error(w, "verification_token is required", http.StatusBadRequest)
+		return
+	}
+	// Parameterized steps check their own fields; the workflow checks them again
+	if v, ok := stepInput.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Send update to workflow (this modifies state!)
+	defer orderCache.Invalidate(orderID)
+	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   orderID,
+		UpdateName:   updateName,
+		Args:         []interface{}{stepInput},
+		WaitForStage: client.WorkflowUpdateStageCompleted, // Wait for result
+	})
+	if err != nil {
+		logErrorf(r, "Failed to update workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to complete step: %s", message), status)
+		return
+	}
+
 	// Get the updated state
 	var state types.PizzaOrder
 	err = updateHandle.Get(r.Context(), &state)
 	if err != nil {
-		log.Printf("Failed to get update result: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get result: %v", err), http.StatusInternalServerError)
+		logErrorf(r, "Failed to get update result: %v", err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to complete step: %s", message), status)
 		return
 	}
 
-	log.Printf("Completed step %s for order %s", action, orderID)
+	logf(r, "Completed step %s for order %s", action, orderID)
+	publishCompletion(orderID, step.component, &state)
 
 	// Return updated state
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"order_id":      state.OrderID,
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
 		"customer_name": state.CustomerName,
 		"state":         state.State,
 		"components":    state.DAG.GetComponents(),
 		"update_time":   state.UpdateTime,
+	}, nil)
+}
+
+// publishCompletion publishes the component's completion if this update made
+// it: not when the step had already completed, or when the update finished
+// without completing it (e.g. a delivery still waiting on a driver)
+func publishCompletion(orderID string, componentType types.ComponentType, state *types.PizzaOrder) {
+	if state.Replayed || state.DAG == nil {
+		return
+	}
+	component, err := state.DAG.GetComponent(componentType)
+	if err != nil || component.State != types.StateCompleted {
+		return
+	}
+	events.Publish(ComponentEvent{OrderID: orderID, Component: componentType, Time: time.Now()})
+}
+
+// retryComponent re-runs the update handler for a component that is ready but
+// not yet completed, e.g. after its payment or delivery activity failed
+func retryComponent(w http.ResponseWriter, r *http.Request, orderID string, componentType types.ComponentType) {
+	defer orderCache.Invalidate(orderID)
+	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   orderID,
+		UpdateName:   workflow.UpdateRetryComponent,
+		Args:         []interface{}{componentType},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
 	})
+	if err != nil {
+		logErrorf(r, "Failed to update workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to retry step: %s", message), status)
+		return
+	}
+
+	var state types.PizzaOrder
+	err = updateHandle.Get(r.Context(), &state)
+	if err != nil {
+		logErrorf(r, "Failed to get update result: %v", err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to get result: %s", message), status)
+		return
+	}
+
+	logf(r, "Retried component %s for order %s", componentType, orderID)
+	publishCompletion(orderID, componentType, &state)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"customer_name": state.CustomerName,
+		"state":         state.State,
+		"components":    state.DAG.GetComponents(),
+		"update_time":   state.UpdateTime,
+	}, nil)
+}
+
+// resetComponent reopens a completed step so it can be redone. Ready
+// dependents go back to waiting until the step completes again.
+func resetComponent(w http.ResponseWriter, r *http.Request, orderID string, componentType types.ComponentType) {
+	defer orderCache.Invalidate(orderID)
+	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   orderID,
+		UpdateName:   workflow.UpdateResetComponent,
+		Args:         []interface{}{componentType},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		logErrorf(r, "Failed to update workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to reset step: %s", message), status)
+		return
+	}
+
+	var state types.PizzaOrder
+	err = updateHandle.Get(r.Context(), &state)
+	if err != nil {
+		logErrorf(r, "Failed to get update result: %v", err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to get result: %s", message), status)
+		return
+	}
+
+	// Not published: the bus carries completions, and a reset undoes one
+	logf(r, "Reset component %s for order %s", componentType, orderID)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"customer_name": state.CustomerName,
+		"state":         state.State,
+		"components":    state.DAG.GetComponents(),
+		"update_time":   state.UpdateTime,
+	}, nil)
+}
+
+// cancelOrder cancels an in-progress order through the CancelOrder update,
+// which refunds the payment before the order is marked CANCELLED
+func cancelOrder(w http.ResponseWriter, r *http.Request, orderID string) {
+	var input workflow.CancelOrderInput
+	if !decodeJSON(w, r, &input, true) {
+		return
+	}
+
+	defer orderCache.Invalidate(orderID)
+	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   orderID,
+		UpdateName:   workflow.UpdateCancelOrder,
+		Args:         []interface{}{input},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		logErrorf(r, "Failed to update workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to cancel order: %s", message), status)
+		return
+	}
+
+	var state types.PizzaOrder
+	err = updateHandle.Get(r.Context(), &state)
+	if err != nil {
+		logErrorf(r, "Failed to get update result: %v", err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to cancel order: %s", message), status)
+		return
+	}
+
+	logf(r, "Cancelled order %s - refund: $%.2f, reason: %s", orderID, state.RefundAmount, state.CancelReason)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"state":         state.State,
+		"cancel_reason": state.CancelReason,
+		"refund_amount": state.RefundAmount,
+		"update_time":   state.UpdateTime,
+	}, nil)
+}
+
+// removeItem takes an item off a multi-item order through the RemoveItem
+// update, which refunds its share of a paid order. Baked items and the last
+// item can't be removed (409).
+func removeItem(w http.ResponseWriter, r *http.Request, orderID, itemID string) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSON(w, r, &req, true) {
+		return
+	}
+
+	defer orderCache.Invalidate(orderID)
+	updateHandle, err := temporalClient.UpdateWorkflow(r.Context(), client.UpdateWorkflowOptions{
+		WorkflowID:   orderID,
+		UpdateName:   workflow.UpdateRemoveItem,
+		Args:         []interface{}{workflow.RemoveItemInput{ItemID: itemID, Reason: req.Reason}},
+		WaitForStage: client.WorkflowUpdateStageCompleted,
+	})
+	if err != nil {
+		logErrorf(r, "Failed to update workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to remove item: %s", message), status)
+		return
+	}
+
+	var state types.PizzaOrder
+	err = updateHandle.Get(r.Context(), &state)
+	if err != nil {
+		logErrorf(r, "Failed to get update result: %v", err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, fmt.Sprintf("Failed to remove item: %s", message), status)
+		return
+	}
+
+	logf(r, "Removed item %s from order %s - total: $%.2f, refunded: $%.2f", itemID, orderID, state.Total, state.RefundAmount)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":      toShortID(state.OrderID),
+		"items":         state.Items,
+		"subtotal":      state.Subtotal,
+		"total":         state.Total,
+		"refund_amount": state.RefundAmount,
+		"components":    state.DAG.ComponentsByDisplayOrder(),
+		"update_time":   state.UpdateTime,
+	}, nil)
+}
+
+// isAdmin checks the request's admin token against ADMIN_TOKEN
+func isAdmin(r *http.Request) bool {
+	token := r.Header.Get("X-Admin-Token")
+	return adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// terminateOrder forcibly terminates an order workflow. Unlike a cancel, the
+// workflow gets no chance to clean up: no refund or other compensation runs.
+func terminateOrder(w http.ResponseWriter, r *http.Request, orderID string) {
+	if !isAdmin(r) {
+		http.Error(w, "Admin token required", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Reason   string `json:"reason"`
+		Operator string `json:"operator"`
+	}
+	if !decodeJSON(w, r, &req, false) {
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.Operator == "" {
+		http.Error(w, "operator is required", http.StatusBadRequest)
+		return
+	}
+
+	defer orderCache.Invalidate(orderID)
+	err := temporalClient.TerminateWorkflow(r.Context(), orderID, "",
+		fmt.Sprintf("%s (by %s)", req.Reason, req.Operator))
+	if err != nil {
+		logErrorf(r, "Failed to terminate workflow %s: %v", orderID, err)
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			http.Error(w, "Order not found or already closed", http.StatusNotFound)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Failed to terminate order: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	logf(r, "Terminated order %s - operator: %s, reason: %s", orderID, req.Operator, req.Reason)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"order_id":   toShortID(orderID),
+		"terminated": true,
+		"operator":   req.Operator,
+		"reason":     req.Reason,
+		"note":       "Terminated without compensation: no refund or notifications were sent",
+	}, nil)
+}
+
+// mapTemporalError maps an error from a Temporal client call on orderID to an
+// HTTP status code and a message for the caller:
+//   - 404 when the order doesn't exist (or has aged out of retention)
+//   - 409 when the order has finished and can't take the request
+//   - 503 when the Temporal service can't be reached
+//
+// Anything else, including errors raised by the workflow's update and query
+// handlers, goes through updateErrorStatus with the error's own message.
+func mapTemporalError(ctx context.Context, orderID string, err error) (int, string) {
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		// Updates to a closed workflow fail with NotFound too
+		if orderClosed(ctx, orderID) {
+			return http.StatusConflict, "Order is already finished"
+		}
+		return http.StatusNotFound, "Order not found"
+	}
+	var precondition *serviceerror.FailedPrecondition
+	if errors.As(err, &precondition) {
+		return http.StatusConflict, precondition.Error()
+	}
+	var unavailable *serviceerror.Unavailable
+	var deadline *serviceerror.DeadlineExceeded
+	if errors.As(err, &unavailable) || errors.As(err, &deadline) {
+		return http.StatusServiceUnavailable, "Temporal service is unavailable, try again later"
+	}
+	// A query handler's error comes back as QueryFailed, with the
+	// ApplicationError it returned in the failure
+	var queryFailed *serviceerror.QueryFailed
+	if errors.As(err, &queryFailed) && queryFailed.Failure != nil {
+		err = temporal.GetDefaultFailureConverter().FailureToError(queryFailed.Failure)
+	}
+	return updateErrorStatus(err), err.Error()
+}
+
+// orderClosed reports whether the order's workflow exists and has finished
+func orderClosed(ctx context.Context, orderID string) bool {
+	resp, err := temporalClient.DescribeWorkflowExecution(ctx, orderID, "")
+	if err != nil {
+		return false
+	}
+	return resp.GetWorkflowExecutionInfo().GetStatus() != enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING
+}
+
+// updateErrorStatus maps an error returned by a workflow update to an HTTP status code
+func updateErrorStatus(err error) int {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		switch appErr.Type() {
+		case workflow.ErrOrderNotActive, workflow.ErrComponentNotReady, workflow.ErrDeliveryWindowNotOpen,
+			workflow.ErrStepNotResettable, workflow.ErrItemNotRemovable:
+			return http.StatusConflict
+		case workflow.ErrComponentNotFound, workflow.ErrItemNotFound:
+			return http.StatusNotFound
+		case workflow.ErrComponentNotRetryable, workflow.ErrInvalidInput:
+			return http.StatusBadRequest
+		case workflow.ErrActivityTimeout:
+			return http.StatusGatewayTimeout
+		case workflow.ErrPaymentDeclined:
+			return http.StatusPaymentRequired
+		case workflow.ErrStepActivityFailed:
+			return http.StatusBadGateway
+		case workflow.ErrDeliveryUnavailable:
+			return http.StatusServiceUnavailable
+		case workflow.ErrTooManyAttempts:
+			return http.StatusTooManyRequests
+		}
+	}
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,761 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pizza-order-dag-demo/types"
+	"pizza-order-dag-demo/workflow"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+)
+
+// fakeTemporalClient answers the client calls the API makes from canned
+// results. Calls it doesn't implement panic through the nil embedded Client.
+type fakeTemporalClient struct {
+	client.Client
+
+	// query answers QueryWorkflow; nil results are returned as no value
+	query func(queryType string, args ...interface{}) (interface{}, error)
+
+	queried []string // Workflow ID of every QueryWorkflow call
+
+	// updateErr fails UpdateWorkflow itself; updateResult and updateResultErr
+	// are what the update handle's Get returns
+	updateErr       error
+	updateResult    interface{}
+	updateResultErr error
+
+	signalErr error
+
+	started []*workflow.PizzaOrderInput // Input of every ExecuteWorkflow call
+
+	// executions is the visibility store ListWorkflow pages through, in
+	// order; listed records the page size of every ListWorkflow call
+	executions []*workflowpb.WorkflowExecutionInfo
+	listed     []int32
+
+	// status is the workflow's status for DescribeWorkflowExecution;
+	// unspecified means there is no such workflow
+	status enumspb.WorkflowExecutionStatus
+}
+
+func (c *fakeTemporalClient) QueryWorkflow(ctx context.Context, workflowID, runID, queryType string, args ...interface{}) (converter.EncodedValue, error) {
+	c.queried = append(c.queried, workflowID)
+	if c.query == nil {
+		return nil, serviceerror.NewNotFound("workflow not found")
+	}
+	value, err := c.query(queryType, args...)
+	if err != nil {
+		return nil, err
+	}
+	return fakeValue{value}, nil
+}
+
+func (c *fakeTemporalClient) UpdateWorkflow(ctx context.Context, options client.UpdateWorkflowOptions) (client.WorkflowUpdateHandle, error) {
+	if c.updateErr != nil {
+		return nil, c.updateErr
+	}
+	return fakeUpdateHandle{value: fakeValue{c.updateResult}, err: c.updateResultErr}, nil
+}
+
+func (c *fakeTemporalClient) ExecuteWorkflow(ctx context.Context, options client.StartWorkflowOptions, workflowFunc interface{}, args ...interface{}) (client.WorkflowRun, error) {
+	input := args[0].(*workflow.PizzaOrderInput)
+	c.started = append(c.started, input)
+	return fakeRun{id: options.ID}, nil
+}
+
+// fakeRun is a started workflow; only its IDs are used
+type fakeRun struct {
+	client.WorkflowRun
+	id string
+}
+
+func (r fakeRun) GetID() string    { return r.id }
+func (r fakeRun) GetRunID() string { return "run-1" }
+
+func (c *fakeTemporalClient) ListWorkflow(ctx context.Context, request *workflowservice.ListWorkflowExecutionsRequest) (*workflowservice.ListWorkflowExecutionsResponse, error) {
+	c.listed = append(c.listed, request.GetPageSize())
+	start := 0
+	if token := request.GetNextPageToken(); len(token) > 0 {
+		start, _ = strconv.Atoi(string(token))
+	}
+	end := min(start+int(request.GetPageSize()), len(c.executions))
+	resp := &workflowservice.ListWorkflowExecutionsResponse{Executions: c.executions[start:end]}
+	if end < len(c.executions) {
+		resp.NextPageToken = []byte(strconv.Itoa(end))
+	}
+	return resp, nil
+}
+
+func (c *fakeTemporalClient) SignalWorkflow(ctx context.Context, workflowID, runID, signalName string, arg interface{}) error {
+	return c.signalErr
+}
+
+func (c *fakeTemporalClient) DescribeWorkflowExecution(ctx context.Context, workflowID, runID string) (*workflowservice.DescribeWorkflowExecutionResponse, error) {
+	if c.status == enumspb.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED {
+		return nil, serviceerror.NewNotFound("workflow not found")
+	}
+	return &workflowservice.DescribeWorkflowExecutionResponse{
+		WorkflowExecutionInfo: &workflowpb.WorkflowExecutionInfo{Status: c.status},
+	}, nil
+}
+
+// fakeValue is a query or update result, decoded the way the SDK would: as JSON
+type fakeValue struct {
+	value interface{}
+}
+
+func (v fakeValue) HasValue() bool { return v.value != nil }
+
+func (v fakeValue) Get(valuePtr interface{}) error {
+	data, err := json.Marshal(v.value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, valuePtr)
+}
+
+type fakeUpdateHandle struct {
+	value fakeValue
+	err   error
+}
+
+func (h fakeUpdateHandle) WorkflowID() string { return "" }
+func (h fakeUpdateHandle) RunID() string      { return "" }
+func (h fakeUpdateHandle) UpdateID() string   { return "" }
+
+func (h fakeUpdateHandle) Get(ctx context.Context, valuePtr interface{}) error {
+	if h.err != nil {
+		return h.err
+	}
+	return h.value.Get(valuePtr)
+}
+
+// useFakeClient points the API at c, with an empty order cache, for the test
+func useFakeClient(t *testing.T, c *fakeTemporalClient) {
+	t.Helper()
+	savedClient, savedCache := temporalClient, orderCache
+	temporalClient, orderCache = c, NewOrderCache(defaultOrderCacheTTL)
+	t.Cleanup(func() { temporalClient, orderCache = savedClient, savedCache })
+}
+
+// queryFailed is the error QueryWorkflow returns when the query handler
+// returned err
+func queryFailed(err error) error {
+	failure := temporal.GetDefaultFailureConverter().ErrorToFailure(err)
+	return serviceerror.NewQueryFailedWithFailure(err.Error(), failure)
+}
+
+func TestTemporalErrorStatus(t *testing.T) {
+	const orderID = "pizza-orders/test-order"
+	notFound := serviceerror.NewNotFound("workflow execution already completed")
+	unavailable := serviceerror.NewUnavailable("connection refused")
+	queryErr := func(err error) func(string, ...interface{}) (interface{}, error) {
+		return func(string, ...interface{}) (interface{}, error) { return nil, err }
+	}
+
+	retry := func(w http.ResponseWriter, r *http.Request) {
+		retryComponent(w, r, orderID, types.ComponentDeliver)
+	}
+	reset := func(w http.ResponseWriter, r *http.Request) {
+		resetComponent(w, r, orderID, types.ComponentBakePizza)
+	}
+	cancel := func(w http.ResponseWriter, r *http.Request) { cancelOrder(w, r, orderID) }
+	dough := func(w http.ResponseWriter, r *http.Request) { completeStep(w, r, orderID, "make-dough") }
+	payment := func(w http.ResponseWriter, r *http.Request) { completeStep(w, r, orderID, "payment") }
+	durations := func(w http.ResponseWriter, r *http.Request) { getStepDurations(w, r, orderID) }
+	eventLog := func(w http.ResponseWriter, r *http.Request) { getEventLog(w, r, orderID) }
+	component := func(w http.ResponseWriter, r *http.Request) {
+		getComponentState(w, r, orderID, types.ComponentBakePizza)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		client  *fakeTemporalClient
+		want    int
+	}{
+		{name: "retry unknown order", handler: retry,
+			client: &fakeTemporalClient{updateErr: notFound}, want: http.StatusNotFound},
+		{name: "retry finished order", handler: retry,
+			client: &fakeTemporalClient{updateErr: notFound, status: enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED},
+			want:   http.StatusConflict},
+		{name: "retry rejected by the workflow", handler: retry,
+			client: &fakeTemporalClient{updateErr: temporal.NewApplicationError("no activity", workflow.ErrComponentNotRetryable)},
+			want:   http.StatusBadRequest},
+		{name: "reset unknown order", handler: reset,
+			client: &fakeTemporalClient{updateErr: notFound}, want: http.StatusNotFound},
+		{name: "reset with Temporal down", handler: reset,
+			client: &fakeTemporalClient{updateErr: unavailable}, want: http.StatusServiceUnavailable},
+		{name: "cancel unknown order", handler: cancel,
+			client: &fakeTemporalClient{updateErr: notFound}, want: http.StatusNotFound},
+		{name: "cancel inactive order", handler: cancel,
+			client: &fakeTemporalClient{updateResultErr: temporal.NewApplicationError("not active", workflow.ErrOrderNotActive)},
+			want:   http.StatusConflict},
+		{name: "step on a cancelled order", handler: dough,
+			client: &fakeTemporalClient{updateErr: temporal.NewApplicationError("not active", workflow.ErrOrderNotActive)},
+			want:   http.StatusConflict},
+		{name: "step admitted before the cancel finished", handler: dough,
+			client: &fakeTemporalClient{updateResultErr: temporal.NewApplicationError("not active", workflow.ErrOrderNotActive)},
+			want:   http.StatusConflict},
+		{name: "payment timed out", handler: payment,
+			client: &fakeTemporalClient{updateResultErr: temporal.NewApplicationError("payment failed: activity timed out", workflow.ErrActivityTimeout)},
+			want:   http.StatusGatewayTimeout},
+		{name: "payment declined", handler: payment,
+			client: &fakeTemporalClient{updateResultErr: temporal.NewApplicationError("payment failed: card declined", workflow.ErrPaymentDeclined)},
+			want:   http.StatusPaymentRequired},
+		{name: "durations of unknown order", handler: durations,
+			client: &fakeTemporalClient{}, want: http.StatusNotFound},
+		{name: "durations with Temporal down", handler: durations,
+			client: &fakeTemporalClient{query: queryErr(unavailable)}, want: http.StatusServiceUnavailable},
+		{name: "event log with Temporal down", handler: eventLog,
+			client: &fakeTemporalClient{query: queryErr(serviceerror.NewDeadlineExceeded("timed out"))},
+			want:   http.StatusServiceUnavailable},
+		{name: "event log query failed", handler: eventLog,
+			client: &fakeTemporalClient{query: queryErr(queryFailed(errors.New("handler panicked")))},
+			want:   http.StatusInternalServerError},
+		{name: "component not in the order", handler: component,
+			client: &fakeTemporalClient{query: queryErr(queryFailed(
+				temporal.NewApplicationError("not part of order", workflow.ErrComponentNotFound)))},
+			want: http.StatusNotFound},
+		{name: "component query failed otherwise", handler: component,
+			client: &fakeTemporalClient{query: queryErr(queryFailed(errors.New("unknown queryType")))},
+			want:   http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeClient(t, tt.client)
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+			w := httptest.NewRecorder()
+			tt.handler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (body %q)", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRemoveItemRoute(t *testing.T) {
+	items := []types.OrderItem{{ID: "A", Amount: 10}, {ID: "B", Amount: 12}}
+	dag, err := types.NewDAGFromDefinitions(types.ItemSteps(items[:1]), types.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		client *fakeTemporalClient
+		want   int
+	}{
+		{name: "removed", client: &fakeTemporalClient{updateResult: &types.PizzaOrder{
+			OrderID: "pizza-orders/test-order", Items: items[:1], Subtotal: 10, RefundAmount: 12, DAG: dag,
+		}}, want: http.StatusOK},
+		{name: "already baked", client: &fakeTemporalClient{
+			updateErr: temporal.NewApplicationError("item B is already baked", workflow.ErrItemNotRemovable)},
+			want: http.StatusConflict},
+		{name: "unknown item", client: &fakeTemporalClient{
+			updateErr: temporal.NewApplicationError("item B is not part of order", workflow.ErrItemNotFound)},
+			want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeClient(t, tt.client)
+			r := httptest.NewRequest(http.MethodDelete, "/orders/test-order/items/b", nil)
+			w := httptest.NewRecorder()
+			handleOrderActions(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data struct {
+					Items        []types.OrderItem `json:"items"`
+					RefundAmount float64           `json:"refund_amount"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Data.Items) != 1 || body.Data.RefundAmount != 12 {
+				t.Errorf("data = %+v, want one item and a 12.00 refund", body.Data)
+			}
+		})
+	}
+}
+
+func TestGetTracking(t *testing.T) {
+	eta := time.Date(2026, 1, 2, 18, 30, 0, 0, time.UTC)
+	order := &types.PizzaOrder{
+		OrderID: "pizza-orders/test-order", DeliveryID: "DEL-1", DeliveryStatus: "DRIVER_ASSIGNED",
+		DriverName: "Sam", EstimatedArrival: &eta,
+	}
+	answer := func(deliveryStatus string) func(string, ...interface{}) (interface{}, error) {
+		return func(queryType string, args ...interface{}) (interface{}, error) {
+			if queryType == workflow.QueryDeliveryStatus {
+				return deliveryStatus, nil
+			}
+			return order, nil
+		}
+	}
+
+	tests := []struct {
+		name       string
+		client     *fakeTemporalClient
+		want       int
+		wantStatus string
+	}{
+		{name: "en route", client: &fakeTemporalClient{query: answer("EN_ROUTE")},
+			want: http.StatusOK, wantStatus: "EN_ROUTE"},
+		{name: "not scheduled", client: &fakeTemporalClient{query: answer("")}, want: http.StatusNotFound},
+		{name: "no such order", client: &fakeTemporalClient{}, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeClient(t, tt.client)
+			r := httptest.NewRequest(http.MethodGet, "/orders/test-order/tracking", nil)
+			w := httptest.NewRecorder()
+			handleOrderActions(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var body struct {
+				Data struct {
+					Status string    `json:"status"`
+					Driver string    `json:"driver"`
+					ETA    time.Time `json:"eta"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Data.Status != tt.wantStatus || body.Data.Driver != "Sam" || !body.Data.ETA.Equal(eta) {
+				t.Errorf("data = %+v, want %s with driver Sam due %v", body.Data, tt.wantStatus, eta)
+			}
+		})
+	}
+}
+
+func TestOrderIDRoundTrip(t *testing.T) {
+	for _, short := range []string{"abc-123", "0d5c1a2e-8f0b-4c7e-9a53-6a1f0f7b2c44"} {
+		workflowID := toWorkflowID(short)
+		if workflowID != orderIDPrefix+short {
+			t.Errorf("toWorkflowID(%q) = %q", short, workflowID)
+		}
+		if got := toShortID(workflowID); got != short {
+			t.Errorf("toShortID(toWorkflowID(%q)) = %q", short, got)
+		}
+		if got := toWorkflowID(toShortID(workflowID)); got != workflowID {
+			t.Errorf("toWorkflowID(toShortID(%q)) = %q", workflowID, got)
+		}
+	}
+	// IDs without the prefix pass through
+	if got := toShortID("abc-123"); got != "abc-123" {
+		t.Errorf("toShortID of a short ID = %q", got)
+	}
+
+	// Clients use and see the short form; the workflow is queried by the full one
+	c := &fakeTemporalClient{query: func(string, ...interface{}) (interface{}, error) {
+		return &types.PizzaOrder{OrderID: toWorkflowID("abc-123"), DAG: types.NewPizzaOrderDAG(types.Now())}, nil
+	}}
+	useFakeClient(t, c)
+	r := httptest.NewRequest(http.MethodGet, "/orders/abc-123", nil)
+	w := httptest.NewRecorder()
+	handleOrderActions(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (body %q)", w.Code, w.Body.String())
+	}
+	var body struct {
+		Data struct {
+			OrderID string `json:"order_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Data.OrderID != "abc-123" {
+		t.Errorf("order_id = %q, want the short ID", body.Data.OrderID)
+	}
+	if len(c.queried) == 0 || c.queried[0] != toWorkflowID("abc-123") {
+		t.Errorf("queried %v, want %s", c.queried, toWorkflowID("abc-123"))
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	oversized := `{"reason": "` + strings.Repeat("a", maxBodyBytes) + `"}`
+	tests := []struct {
+		name     string
+		body     string
+		optional bool
+		want     int // 0 means decoded
+		wantBody string
+	}{
+		{name: "valid", body: `{"reason": "late"}`},
+		{name: "unknown field", body: `{"reason": "late", "reasn": "typo"}`, want: http.StatusBadRequest, wantBody: `Unknown field "reasn"`},
+		{name: "oversized", body: oversized, want: http.StatusRequestEntityTooLarge, wantBody: "must not exceed 1048576 bytes"},
+		{name: "malformed", body: `{"reason": `, want: http.StatusBadRequest, wantBody: "Invalid JSON"},
+		{name: "empty", want: http.StatusBadRequest},
+		{name: "empty but optional", optional: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			var v struct {
+				Reason string `json:"reason"`
+			}
+			ok := decodeJSON(w, r, &v, tt.optional)
+			if ok != (tt.want == 0) {
+				t.Fatalf("decoded = %v, want %v (status %d, body %q)", ok, tt.want == 0, w.Code, w.Body.String())
+			}
+			if tt.want != 0 && w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to mention %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	// Order creation rejects a misspelled field before starting anything;
+	// the fake client has no workflows to start
+	useFakeClient(t, &fakeTemporalClient{})
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"customer_nmae": "alice"}`))
+	w := httptest.NewRecorder()
+	handleOrders(w, r)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "customer_nmae") {
+		t.Errorf("create with a typo = %d %q, want 400 naming the field", w.Code, w.Body.String())
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	var reached bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+	handler := withCORS(parseCORSOrigins("https://app.example.com/"), next)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, "/orders", nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type, "+requestIDHeader)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("https://app.example.com")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if reached {
+		t.Error("preflight reached the wrapped handler")
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": corsAllowedMethods,
+		"Access-Control-Max-Age":       corsMaxAge,
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	allowed := w.Header().Get("Access-Control-Allow-Headers")
+	for _, header := range []string{"Content-Type", "Idempotency-Key", requestIDHeader} {
+		if !strings.Contains(allowed, header) {
+			t.Errorf("Access-Control-Allow-Headers = %q, want it to include %s", allowed, header)
+		}
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Expose-Headers"), requestIDHeader) {
+		t.Errorf("Access-Control-Expose-Headers = %q, want it to include %s", w.Header().Get("Access-Control-Expose-Headers"), requestIDHeader)
+	}
+
+	// Other origins get an answer the browser will refuse
+	w = preflight("https://evil.example.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("disallowed origin got Access-Control-Allow-Origin %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("disallowed origin got Access-Control-Allow-Methods %q", got)
+	}
+	if reached {
+		t.Error("preflight reached the wrapped handler")
+	}
+}
+
+// recordEvents collects what is published to the event bus during the test
+func recordEvents(t *testing.T) *[]ComponentEvent {
+	t.Helper()
+	var published []ComponentEvent
+	unsubscribe := events.Subscribe(func(event ComponentEvent) {
+		published = append(published, event)
+	})
+	t.Cleanup(unsubscribe)
+	return &published
+}
+
+func TestResetPublishesNoCompletion(t *testing.T) {
+	dag := types.NewPizzaOrderDAG(types.Now())
+	useFakeClient(t, &fakeTemporalClient{updateResult: &types.PizzaOrder{
+		OrderID: "pizza-orders/test-order", State: types.OrderStateInProgress, DAG: dag,
+	}})
+	published := recordEvents(t)
+
+	r := httptest.NewRequest(http.MethodPost, "/orders/test-order/components/ADD_TOPPINGS/reset", nil)
+	w := httptest.NewRecorder()
+	handleOrderActions(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", w.Code, http.StatusOK, w.Body.String())
+	}
+	if len(*published) != 0 {
+		t.Errorf("reset published %v, want no completion events", *published)
+	}
+}
+
+func TestStepCompletionEvents(t *testing.T) {
+	completedDAG := func(componentType types.ComponentType) *types.DAG {
+		dag := types.NewPizzaOrderDAG(types.Now())
+		if err := dag.CompleteComponent(componentType); err != nil {
+			t.Fatal(err)
+		}
+		return dag
+	}
+
+	tests := []struct {
+		name  string
+		path  string
+		order *types.PizzaOrder
+		want  int // Completion events published
+	}{
+		{
+			name:  "step completed",
+			path:  "/orders/test-order/payment",
+			order: &types.PizzaOrder{DAG: completedDAG(types.ComponentPayment)},
+			want:  1,
+		},
+		{
+			name:  "step already completed",
+			path:  "/orders/test-order/payment",
+			order: &types.PizzaOrder{DAG: completedDAG(types.ComponentPayment), Replayed: true},
+		},
+		{
+			name:  "retry completed the step",
+			path:  "/orders/test-order/components/PAYMENT/retry",
+			order: &types.PizzaOrder{DAG: completedDAG(types.ComponentPayment)},
+			want:  1,
+		},
+		{
+			name:  "retry raced a completion",
+			path:  "/orders/test-order/components/PAYMENT/retry",
+			order: &types.PizzaOrder{DAG: completedDAG(types.ComponentPayment), Replayed: true},
+		},
+		{
+			name:  "retry left the step incomplete",
+			path:  "/orders/test-order/components/PAYMENT/retry",
+			order: &types.PizzaOrder{DAG: types.NewPizzaOrderDAG(types.Now())},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.order.OrderID = "pizza-orders/test-order"
+			tt.order.State = types.OrderStateInProgress
+			useFakeClient(t, &fakeTemporalClient{updateResult: tt.order})
+			published := recordEvents(t)
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			w := httptest.NewRecorder()
+			handleOrderActions(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, http.StatusOK, w.Body.String())
+			}
+			if len(*published) != tt.want {
+				t.Errorf("published %d completion events, want %d", len(*published), tt.want)
+			}
+		})
+	}
+}
+
+func TestImportOrder(t *testing.T) {
+	savedToken := adminToken
+	adminToken = "secret"
+	t.Cleanup(func() { adminToken = savedToken })
+
+	bundle := func(state types.OrderState) string {
+		dag := types.NewPizzaOrderDAG(types.Now())
+		if err := dag.CompleteComponent(types.ComponentPayment); err != nil {
+			t.Fatal(err)
+		}
+		order := &types.PizzaOrder{
+			OrderID: "pizza-orders/exported", CustomerName: "alice", State: state, DAG: dag,
+			Complexity: types.ComplexitySimple, Fulfillment: types.FulfillmentDelivery,
+			Subtotal: 20, Total: 25, PaymentTxnID: "TXN-EXPORTED", PaymentAmount: 25,
+		}
+		data, err := json.Marshal(types.NewOrderBundle(order, types.Now()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	// PAYMENT made to wait on DELIVER, which waits on it
+	var edited map[string]interface{}
+	if err := json.Unmarshal([]byte(bundle(types.OrderStateInProgress)), &edited); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range edited["order"].(map[string]interface{})["components"].([]interface{}) {
+		if component := c.(map[string]interface{}); component["type"] == string(types.ComponentPayment) {
+			component["dependsOn"] = []string{string(types.ComponentDeliver)}
+		}
+	}
+	cyclic, err := json.Marshal(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{name: "imported", token: "secret", body: bundle(types.OrderStateInProgress), want: http.StatusCreated},
+		{name: "no admin token", body: bundle(types.OrderStateInProgress), want: http.StatusForbidden},
+		{name: "wrong admin token", token: "guess", body: bundle(types.OrderStateInProgress), want: http.StatusForbidden},
+		{name: "finished order", token: "secret", body: bundle(types.OrderStateCompleted), want: http.StatusBadRequest},
+		{name: "cyclic graph", token: "secret", body: string(cyclic), want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &fakeTemporalClient{}
+			useFakeClient(t, c)
+			r := httptest.NewRequest(http.MethodPost, "/orders/import", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("X-Admin-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			handleOrderActions(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (body %q)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusCreated {
+				if len(c.started) != 0 {
+					t.Error("a workflow was started")
+				}
+				return
+			}
+			if len(c.started) != 1 {
+				t.Fatalf("started %d workflows, want 1", len(c.started))
+			}
+			input := c.started[0]
+			if input.Imported == nil || input.Imported.PaymentTxnID != "TXN-EXPORTED" {
+				t.Errorf("imported = %+v, want the exported order", input.Imported)
+			}
+			if input.OrderID == "pizza-orders/exported" {
+				t.Error("the import reused the exported order ID")
+			}
+		})
+	}
+}
+
+// closedOrder is the visibility record of a finished order
+func closedOrder(t *testing.T, id string, state types.OrderState) *workflowpb.WorkflowExecutionInfo {
+	t.Helper()
+	payload, err := converter.GetDefaultDataConverter().ToPayload(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &workflowpb.WorkflowExecutionInfo{
+		Execution: &commonpb.WorkflowExecution{WorkflowId: "pizza-orders/" + id},
+		Status:    enumspb.WORKFLOW_EXECUTION_STATUS_COMPLETED,
+		Memo:      &commonpb.Memo{Fields: map[string]*commonpb.Payload{workflow.MemoOrderState: payload}},
+	}
+}
+
+func TestListOrdersFillsFilteredPages(t *testing.T) {
+	// Every third closed order was cancelled, the rest completed
+	var executions []*workflowpb.WorkflowExecutionInfo
+	var cancelled []string
+	for i := 0; i < 30; i++ {
+		id := fmt.Sprintf("order-%d", i)
+		state := types.OrderStateCompleted
+		if i%3 == 2 {
+			state = types.OrderStateCancelled
+			cancelled = append(cancelled, id)
+		}
+		executions = append(executions, closedOrder(t, id, state))
+	}
+	c := &fakeTemporalClient{executions: executions}
+	useFakeClient(t, c)
+
+	type page struct {
+		Data struct {
+			Orders        []orderListEntry `json:"orders"`
+			NextPageToken string           `json:"next_page_token"`
+		} `json:"data"`
+	}
+	list := func(query url.Values) page {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/orders?"+query.Encode(), nil)
+		w := httptest.NewRecorder()
+		handleOrders(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d (body %q)", w.Code, http.StatusOK, w.Body.String())
+		}
+		var p page
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// Two full pages cover every cancelled order once, in order
+	var got []string
+	query := url.Values{"state": {"CANCELLED"}, "limit": {"5"}}
+	for i := 0; i < 2; i++ {
+		p := list(query)
+		if len(p.Data.Orders) != 5 {
+			t.Fatalf("page %d has %d orders, want 5", i, len(p.Data.Orders))
+		}
+		for _, order := range p.Data.Orders {
+			got = append(got, order.OrderID)
+		}
+		query.Set("next_page_token", p.Data.NextPageToken)
+	}
+	if strings.Join(got, ",") != strings.Join(cancelled, ",") {
+		t.Errorf("listed %v, want %v", got, cancelled)
+	}
+	for _, size := range c.listed {
+		if size > 5 {
+			t.Errorf("requested a page of %d, more than the 5 still missing", size)
+		}
+	}
+
+	// A state nobody is in stops after maxOrderListRequests, leaving a token
+	c.listed = nil
+	p := list(url.Values{"state": {"FAILED"}, "limit": {"2"}})
+	if len(p.Data.Orders) != 0 || p.Data.NextPageToken == "" {
+		t.Errorf("got %d orders and token %q, want none and a token to carry on", len(p.Data.Orders), p.Data.NextPageToken)
+	}
+	if len(c.listed) != maxOrderListRequests {
+		t.Errorf("made %d visibility requests, want %d", len(c.listed), maxOrderListRequests)
+	}
+}
@@ -0,0 +1,60 @@
+// Package metrics defines the Prometheus metrics for the order lifecycle.
+// The API server and the worker each register them in their own process and
+// serve them on /metrics: order and step counts come from the API server,
+// activity failures and order durations from the worker.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// OrdersCreated counts orders started through the API
+	OrdersCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pizza_orders_created_total",
+		Help: "Pizza orders created.",
+	})
+
+	// ComponentsCompleted counts completed steps by component type
+	ComponentsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pizza_component_completed_total",
+		Help: "Order steps completed, by component.",
+	}, []string{"component"})
+
+	// PaymentFailures counts failed charge attempts, retries included
+	PaymentFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pizza_payment_failures_total",
+		Help: "Payment gateway charge attempts that failed.",
+	})
+
+	// DeliveryFailures counts failed delivery scheduling attempts, retries included
+	DeliveryFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pizza_delivery_failures_total",
+		Help: "Delivery scheduling attempts that failed.",
+	})
+
+	// OrderDuration observes creation to completion (or cancellation) by final state
+	OrderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pizza_order_duration_seconds",
+		Help:    "Time from order creation until it finished, by final state.",
+		Buckets: []float64{60, 300, 600, 900, 1800, 2700, 3600, 7200},
+	}, []string{"state"})
+)
+
+// Handler serves the registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveOrderFinished records a finished order's duration. The workflow runs
+// it as a local activity so a replay doesn't observe the order twice.
+func ObserveOrderFinished(ctx context.Context, state string, duration time.Duration) error {
+	OrderDuration.WithLabelValues(state).Observe(duration.Seconds())
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries the request ID, echoed back in every response
+const requestIDHeader = "X-Request-ID"
+
+// Envelope wraps every successful JSON response: the payload under data,
+// request details (request ID, pagination) under meta
+type Envelope struct {
+	Data interface{}            `json:"data"`
+	Meta map[string]interface{} `json:"meta"`
+}
+
+// writeJSON writes data in the standard envelope. The request ID set by
+// withRequestID is always added to meta.
+func writeJSON(w http.ResponseWriter, status int, data interface{}, meta map[string]interface{}) {
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		meta["request_id"] = id
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{Data: data, Meta: meta})
+}
+
+// maxBodyBytes caps request bodies so a huge upload can't exhaust memory
+const maxBodyBytes = 1 << 20
+
+// decodeJSON decodes the request body into v. Bodies over maxBodyBytes are
+// rejected with 413, and malformed JSON or a field v doesn't have (usually a
+// typo) with 400 naming it. An empty body is an error unless optional, which
+// leaves v at its zero value. Returns false once the error is written.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, optional bool) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil || (optional && err == io.EOF) {
+		return true
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Request body must not exceed %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		http.Error(w, "Unknown field "+field, http.StatusBadRequest)
+		return false
+	}
+	http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	return false
+}
+
+// withRequestID gives every request an ID, reusing the caller's X-Request-ID
+// if it sent one, and echoes it in the response header. The request's logger
+// tags every line with it.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, withLogger(r, slog.Default().With("request_id", id)))
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"pizza-order-dag-demo/types"
+
+	"github.com/gorilla/websocket"
+)
+
+// streamPollInterval is how often a stream queries its order
+// (ORDER_STREAM_INTERVAL overrides it)
+var streamPollInterval = 1 * time.Second
+
+// streamWriteTimeout bounds each frame write, so a stuck client can't hold the stream
+const streamWriteTimeout = 10 * time.Second
+
+// maxCloseReason keeps close frame reasons within the 125 byte control frame limit
+const maxCloseReason = 120
+
+var streamUpgrader = websocket.Upgrader{}
+
+// streamOrder upgrades to a WebSocket and sends the order, components
+// included, each time its UpdateTime advances. The socket is closed once the
+// order is COMPLETED, CANCELLED or FAILED, or when the client goes away.
+// ?redact=true masks customer contact details as for other reads.
+func streamOrder(w http.ResponseWriter, r *http.Request, orderID string) {
+	// Fail before upgrading so an unknown order is a plain 404
+	state, err := queryOrder(r.Context(), orderID)
+	if err != nil {
+		logErrorf(r, "Failed to query workflow %s: %v", orderID, err)
+		status, message := mapTemporalError(r.Context(), orderID, err)
+		http.Error(w, message, status)
+		return
+	}
+
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		logErrorf(r, "Failed to upgrade stream for %s: %v", orderID, err)
+		return
+	}
+	defer conn.Close()
+
+	// The client sends nothing; reading only notices when it disconnects
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	redact := r.URL.Query().Get("redact") == "true"
+	var sent time.Time
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	for {
+		if state.UpdateTime.After(sent) || sent.IsZero() {
+			frame := state
+			if redact {
+				frame = state.Redacted()
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(frame); err != nil {
+				logf(r, "Stream for %s ended: %v", orderID, err)
+				return
+			}
+			sent = state.UpdateTime
+		}
+
+		if state.State != types.OrderStateInProgress {
+			message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, string(state.State))
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(streamWriteTimeout))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err = queryOrder(ctx, orderID)
+		if err != nil {
+			if ctx.Err() == nil {
+				logErrorf(r, "Failed to query workflow %s: %v", orderID, err)
+				_, message := mapTemporalError(ctx, orderID, err)
+				if len(message) > maxCloseReason {
+					message = message[:maxCloseReason]
+				}
+				closeMessage := websocket.FormatCloseMessage(websocket.CloseInternalServerErr, message)
+				conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(streamWriteTimeout))
+			}
+			return
+		}
+	}
+}
@@ -2,61 +2,167 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// DAG (Directed Acyclic Graph) manages components and their dependencies
+// ErrEmptyDAG is returned when a DAG is built without any components.
+// An empty graph would otherwise be vacuously "completed" the moment it starts.
+var ErrEmptyDAG = errors.New("DAG must contain at least one component")
+
+// ErrSelfDependency is returned when a component lists itself as a dependency.
+// It is checked before the cycle check, which would only say "cycle detected".
+var ErrSelfDependency = errors.New("component depends on itself")
+
+// ErrEmptyAnyOfGroup is returned when an AnyOf group has no alternatives; a
+// component waiting on one could never become ready.
+var ErrEmptyAnyOfGroup = errors.New("anyOf group has no alternatives")
+
+// ErrDuplicateComponent is returned when two components share a type; lookups
+// by type would only ever find the first.
+var ErrDuplicateComponent = errors.New("duplicate component type")
+
+// Errors returned, wrapped with the component's type, by DAG lookups and state
+// transitions. Check them with errors.Is.
+var (
+	// ErrComponentNotFound means the component isn't part of the DAG
+	ErrComponentNotFound = errors.New("component not found")
+
+	// ErrDependenciesNotMet means the component is still NEEDS_INIT
+	ErrDependenciesNotMet = errors.New("component dependencies are not complete")
+
+	// ErrComponentNotReady means the component isn't in the state the
+	// transition starts from, e.g. completing one that is already COMPLETED
+	ErrComponentNotReady = errors.New("component is not in the required state")
+)
+
+// Now is the clock used for component timestamps. Tests can replace it to get
+// predictable times. It is process-wide, so workflow code must not point it
+// at workflow.Now; DAG operations run inside a workflow should be handed
+// workflow.Now(ctx) explicitly instead.
+var Now = time.Now
+
+// DAG (Directed Acyclic Graph) manages components and their dependencies.
+//
+// Exported methods take mu, so a query reading the DAG can't observe a
+// completion half done. Unexported helpers assume the caller holds it. The
+// *Component values handed out are shared with the DAG: change their state
+// only through DAG methods.
 type DAG struct {
+	mu         sync.Mutex
 	components []*Component `json:"-"` // Not exported in JSON, we export via MarshalJSON
 }
 
 // NewDAG creates a new DAG with the given components
 func NewDAG(components []*Component) (*DAG, error) {
-	dag := &DAG{components: components}
+	if len(components) == 0 {
+		return nil, ErrEmptyDAG
+	}
 
-	// Validate no cycles
-	if err := dag.validateNoCycles(); err != nil {
-		return nil, err
+	for _, c := range components {
+		if err := normalizeDependencies(c); err != nil {
+			return nil, err
+		}
+		applyDefaultEstimate(c)
 	}
 
+	dag := &DAG{components: components}
+	if err := dag.Validate(); err != nil {
+		return nil, err
+	}
 	return dag, nil
 }
 
-// NewPizzaOrderDAG creates the default pizza order component graph
-func NewPizzaOrderDAG() *DAG {
-	now := time.Now()
+// Validate checks the graph's structure: there is at least one component,
+// component types are unique, every dependency exists and there are no cycles. NewDAG runs it, so it only
+// reports something for a DAG built by other means.
+func (d *DAG) Validate() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.components) == 0 {
+		return ErrEmptyDAG
+	}
+
+	seen := make(map[ComponentType]bool, len(d.components))
+	for _, c := range d.components {
+		if seen[c.Type] {
+			return fmt.Errorf("%w: %s", ErrDuplicateComponent, c.Type)
+		}
+		seen[c.Type] = true
+	}
+
+	// Validate every dependency exists - a missing one could never complete,
+	// leaving its dependents stuck in NEEDS_INIT forever
+	if err := d.validateDependencies(); err != nil {
+		return err
+	}
 
+	// Validate no cycles
+	return d.validateNoCycles()
+}
+
+// NewPizzaOrderDAG creates the default pizza order component graph, with
+// every timestamp set to now. Workflow code passes workflow.Now.
+func NewPizzaOrderDAG(now time.Time) *DAG {
 	components := []*Component{
 		{
 			Type:       ComponentPayment,
 			State:      StateIncomplete, // First step - ready to start immediately
 			DependsOn:  []ComponentType{},
 			UpdateTime: now,
+			ReadyTime:  &now,
+
+			OnCompleteActivity: ActivityProcessPayment,
+			ActivityTimeout:    10 * time.Second, // Fail fast so the customer can retry
+			DisplayOrder:       10,
 		},
 		{
 			Type:       ComponentMakeDough,
 			State:      StateNeedsInit, // Waiting for payment
 			DependsOn:  []ComponentType{ComponentPayment},
 			UpdateTime: now,
+
+			DisplayOrder: 20,
 		},
 		{
 			Type:       ComponentAddToppings,
 			State:      StateNeedsInit, // Waiting for dough
 			DependsOn:  []ComponentType{ComponentMakeDough},
 			UpdateTime: now,
+
+			DisplayOrder: 50,
 		},
 		{
 			Type:       ComponentBakePizza,
 			State:      StateNeedsInit, // Waiting for toppings
 			DependsOn:  []ComponentType{ComponentAddToppings},
 			UpdateTime: now,
+
+			DisplayOrder: 60,
 		},
 		{
 			Type:       ComponentDeliver,
 			State:      StateNeedsInit, // Waiting for baking
 			DependsOn:  []ComponentType{ComponentBakePizza},
 			UpdateTime: now,
+
+			OnCompleteActivity: ActivityScheduleDelivery,
+			ActivityTimeout:    30 * time.Second,
+			DisplayOrder:       70,
+		},
+		{
+			Type:       ComponentPhotoProof,
+			State:      StateNeedsInit, // Waiting for delivery
+			DependsOn:  []ComponentType{ComponentDeliver},
+			UpdateTime: now,
+			Optional:   true, // Orders complete without proof of delivery
+
+			DisplayOrder: 80,
 		},
 	}
 
@@ -64,113 +170,856 @@ func NewPizzaOrderDAG() *DAG {
 	return dag
 }
 
-// GetComponent finds a component by type
+// NewGourmetPizzaOrderDAG creates the gourmet graph: after making the dough
+// it is proofed and then rested before the toppings go on
+func NewGourmetPizzaOrderDAG(now time.Time) *DAG {
+	dag := NewPizzaOrderDAG(now)
+
+	prep := []*Component{
+		{
+			Type:       ComponentProofDough,
+			State:      StateNeedsInit, // Waiting for dough
+			DependsOn:  []ComponentType{ComponentMakeDough},
+			UpdateTime: now,
+
+			DisplayOrder: 30,
+		},
+		{
+			Type:       ComponentRestDough,
+			State:      StateNeedsInit, // Waiting for proofing
+			DependsOn:  []ComponentType{ComponentProofDough},
+			UpdateTime: now,
+
+			DisplayOrder: 40,
+		},
+	}
+
+	// Toppings now wait for the rested dough; keep the slice in prep order
+	components := make([]*Component, 0, len(dag.components)+len(prep))
+	for _, c := range dag.components {
+		if c.Type == ComponentAddToppings {
+			c.DependsOn = []ComponentType{ComponentRestDough}
+			components = append(components, prep...)
+		}
+		components = append(components, c)
+	}
+
+	gourmet, _ := NewDAG(components) // We know this won't error
+	return gourmet
+}
+
+// NewSauceAndCheeseDAG creates the standard graph with sauce and cheese as
+// parallel branches: both are ready once the dough is made, and toppings wait
+// for both of them
+func NewSauceAndCheeseDAG(now time.Time) *DAG {
+	dag := NewPizzaOrderDAG(now)
+
+	prep := []*Component{
+		{
+			Type:       ComponentAddSauce,
+			State:      StateNeedsInit, // Waiting for dough
+			DependsOn:  []ComponentType{ComponentMakeDough},
+			UpdateTime: now,
+
+			DisplayOrder: 44,
+		},
+		{
+			Type:       ComponentAddCheese,
+			State:      StateNeedsInit, // Waiting for dough, not for the sauce
+			DependsOn:  []ComponentType{ComponentMakeDough},
+			UpdateTime: now,
+
+			DisplayOrder: 46,
+		},
+	}
+
+	components := make([]*Component, 0, len(dag.components)+len(prep))
+	for _, c := range dag.components {
+		if c.Type == ComponentAddToppings {
+			c.DependsOn = []ComponentType{ComponentAddSauce, ComponentAddCheese}
+			components = append(components, prep...)
+		}
+		components = append(components, c)
+	}
+
+	parallel, _ := NewDAG(components) // We know this won't error
+	return parallel
+}
+
+// GetComponent finds a component by type. It returns a copy: changing it
+// doesn't change the DAG, and the DAG changing doesn't race with reading it.
+// Use the DAG's methods, such as SetParameter, to change a component.
 func (d *DAG) GetComponent(componentType ComponentType) (*Component, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	component, err := d.component(componentType)
+	if err != nil {
+		return nil, err
+	}
+	return component.clone(), nil
+}
+
+// component is GetComponent for callers already holding mu
+func (d *DAG) component(componentType ComponentType) (*Component, error) {
 	for _, c := range d.components {
 		if c.Type == componentType {
 			return c, nil
 		}
 	}
-	return nil, fmt.Errorf("component %s not found", componentType)
+	return nil, fmt.Errorf("%w: %s", ErrComponentNotFound, componentType)
 }
 
-// GetComponents returns all components (for JSON marshaling)
+// GetComponents returns copies of all components, in DAG order, so later
+// changes to the graph don't show up in them (see GetComponent)
 func (d *DAG) GetComponents() []*Component {
-	return d.components
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return cloneComponents(d.components)
+}
+
+// ComponentsByDisplayOrder returns the components sorted for display rather
+// than by dependencies. Ties keep DAG order, and components without a
+// DisplayOrder come last.
+func (d *DAG) ComponentsByDisplayOrder() []*Component {
+	components := d.GetComponents()
+	sort.SliceStable(components, func(i, j int) bool {
+		a, b := components[i].DisplayOrder, components[j].DisplayOrder
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+	return components
+}
+
+// TopologicalSort returns the components in dependency order (Kahn's
+// algorithm): every component comes after all of its dependencies, AnyOf
+// alternatives included. Components that become available together keep DAG
+// order, so the result is stable. Returns an error if the graph has a cycle.
+func (d *DAG) TopologicalSort() ([]*Component, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sorted, err := d.topologicalSort()
+	if err != nil {
+		return nil, err
+	}
+	return cloneComponents(sorted), nil
+}
+
+// topologicalSort is TopologicalSort for callers already holding mu
+func (d *DAG) topologicalSort() ([]*Component, error) {
+	inDegree := make(map[ComponentType]int, len(d.components))
+	dependents := make(map[ComponentType][]ComponentType)
+	for _, c := range d.components {
+		deps := appendUnique(nil, c.dependencies()...)
+		inDegree[c.Type] = len(deps)
+		for _, depType := range deps {
+			dependents[depType] = append(dependents[depType], c.Type)
+		}
+	}
+
+	sorted := make([]*Component, 0, len(d.components))
+	placed := make(map[ComponentType]bool, len(d.components))
+	for len(sorted) < len(d.components) {
+		progressed := false
+		for _, c := range d.components {
+			if placed[c.Type] || inDegree[c.Type] > 0 {
+				continue
+			}
+			placed[c.Type] = true
+			sorted = append(sorted, c)
+			for _, dependent := range dependents[c.Type] {
+				inDegree[dependent]--
+			}
+			progressed = true
+		}
+		if !progressed {
+			return nil, fmt.Errorf("cycle detected in DAG")
+		}
+	}
+	return sorted, nil
+}
+
+// dotColors fills each node in ToDOT by component state
+var dotColors = map[ComponentState]string{
+	StateCompleted:  "palegreen",
+	StateIncomplete: "khaki1",
+	StateNeedsInit:  "lightgray",
+}
+
+// ToDOT renders the DAG as a Graphviz DOT digraph, e.g. for `dot -Tsvg`: one
+// node per component, labeled with its type and state and filled by state
+// (green completed, yellow ready, gray blocked), and an edge from each
+// dependency to its dependent. AnyOf edges are dashed and optional steps
+// have a dashed outline.
+func (d *DAG) ToDOT() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("digraph order {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+
+	for _, c := range d.components {
+		style := ""
+		if c.Optional {
+			style = `, style="rounded,filled,dashed"`
+		}
+		label := string(c.Type) + "\n" + string(c.State)
+		fmt.Fprintf(&b, "\t%q [label=%q, fillcolor=%s%s];\n",
+			string(c.Type), label, dotColors[c.State], style)
+	}
+	for _, c := range d.components {
+		for _, dep := range c.DependsOn {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", string(dep), string(c.Type))
+		}
+		for _, group := range c.AnyOf {
+			for _, dep := range group {
+				fmt.Fprintf(&b, "\t%q -> %q [style=dashed];\n", string(dep), string(c.Type))
+			}
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
 }
 
-// CompleteComponent marks a component as completed
+// CompleteComponent marks a component as completed, timestamped with Now.
+// Workflow code must use CompleteComponentAt with workflow.Now instead.
 func (d *DAG) CompleteComponent(componentType ComponentType) error {
-	component, err := d.GetComponent(componentType)
+	return d.CompleteComponentAt(componentType, Now())
+}
+
+// CompleteComponentAt marks a component as completed at the given time, which
+// also becomes the ReadyTime of any dependents it unblocks. Passing the
+// workflow clock keeps the timestamps identical when the workflow is replayed.
+func (d *DAG) CompleteComponentAt(componentType ComponentType, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	component, err := d.component(componentType)
 	if err != nil {
 		return err
 	}
 
-	if component.State != StateIncomplete {
-		return fmt.Errorf("component %s is not in INCOMPLETE state (current: %s)", componentType, component.State)
+	switch component.State {
+	case StateIncomplete:
+		// Ready to complete
+	case StateNeedsInit:
+		return fmt.Errorf("%w: %s", ErrDependenciesNotMet, componentType)
+	default:
+		return fmt.Errorf("%w: %s is %s, not INCOMPLETE", ErrComponentNotReady, componentType, component.State)
 	}
 
 	// Mark as completed
-	now := time.Now()
 	component.State = StateCompleted
 	component.CompleteTime = &now
 	component.UpdateTime = now
 
 	// Check if any dependent components can now be started
-	d.updateDependentComponents()
+	d.updateDependentComponents(now)
 
 	return nil
 }
 
-// updateDependentComponents checks all components and moves them to INCOMPLETE if dependencies are met
-func (d *DAG) updateDependentComponents() {
-	for _, component := range d.components {
-		if component.State != StateNeedsInit {
+// SetParameter records a value the step was completed with, e.g. the bake
+// temperature, in the component's Parameters
+func (d *DAG) SetParameter(componentType ComponentType, name, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	component, err := d.component(componentType)
+	if err != nil {
+		return err
+	}
+	if component.Parameters == nil {
+		component.Parameters = make(map[string]string)
+	}
+	component.Parameters[name] = value
+	return nil
+}
+
+// SetActivityTimeout sets how long each attempt of the component's
+// OnCompleteActivity may take
+func (d *DAG) SetActivityTimeout(componentType ComponentType, timeout time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	component, err := d.component(componentType)
+	if err != nil {
+		return err
+	}
+	component.ActivityTimeout = timeout
+	return nil
+}
+
+// IncrementRetryCount counts an operator's retry of the component and
+// returns the new RetryCount
+func (d *DAG) IncrementRetryCount(componentType ComponentType) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	component, err := d.component(componentType)
+	if err != nil {
+		return 0, err
+	}
+	component.RetryCount++
+	return component.RetryCount, nil
+}
+
+// ResetComponent reopens a completed component, timestamped with Now.
+// Workflow code must use ResetComponentAt with workflow.Now instead.
+func (d *DAG) ResetComponent(componentType ComponentType) error {
+	return d.ResetComponentAt(componentType, Now())
+}
+
+// ResetComponentAt moves a completed component back to INCOMPLETE so the step
+// can be redone, e.g. after wrong toppings. Its CompleteTime is cleared and
+// now becomes its ReadyTime. Dependents that were ready go back to NEEDS_INIT
+// until it completes again. Resetting is rejected while a dependent is already
+// completed, since that work was built on the step being redone.
+func (d *DAG) ResetComponentAt(componentType ComponentType, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	component, err := d.component(componentType)
+	if err != nil {
+		return err
+	}
+	if component.State != StateCompleted {
+		return fmt.Errorf("%w: %s is %s, not COMPLETED", ErrComponentNotReady, componentType, component.State)
+	}
+	for _, c := range d.components {
+		if c.State != StateCompleted {
+			continue
+		}
+		for _, depType := range c.dependencies() {
+			if depType == componentType {
+				return fmt.Errorf("cannot reset %s: dependent %s is already completed", componentType, c.Type)
+			}
+		}
+	}
+
+	component.CompleteTime = nil
+	markReady(component, now)
+
+	// Dependents whose dependencies are no longer all complete wait again
+	for _, c := range d.components {
+		if c.State == StateIncomplete && !d.dependenciesMet(c) {
+			c.State = StateNeedsInit
+			c.UpdateTime = now
+			c.ReadyTime = nil
+		}
+	}
+	return nil
+}
+
+// RemoveComponent removes a component and splices it out of the graph:
+// components that depended on it inherit its own DependsOn and AnyOf groups,
+// so A → B → C becomes A → C when B is removed, and C still waits for one of
+// B's alternatives. It is dropped from AnyOf groups; removal is rejected if
+// that would leave a group with no alternatives, or if it is the last
+// component. Ready states are recomputed afterwards, as of now.
+func (d *DAG) RemoveComponent(componentType ComponentType, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.removeComponent(componentType); err != nil {
+		return err
+	}
+	d.repair(now)
+	return nil
+}
+
+// removeComponent splices a component out of the graph, as RemoveComponent
+// describes, without recomputing ready states. Callers hold d.mu.
+func (d *DAG) removeComponent(componentType ComponentType) error {
+	removed, err := d.component(componentType)
+	if err != nil {
+		return err
+	}
+	if len(d.components) == 1 {
+		return ErrEmptyDAG
+	}
+
+	// Check AnyOf groups first so a rejected removal leaves the DAG untouched
+	for _, c := range d.components {
+		for _, group := range c.AnyOf {
+			if len(group) == 1 && group[0] == componentType {
+				return fmt.Errorf("cannot remove %s: it is the only alternative in an anyOf group of %s", componentType, c.Type)
+			}
+		}
+	}
+
+	remaining := make([]*Component, 0, len(d.components)-1)
+	for _, c := range d.components {
+		if c.Type == componentType {
 			continue
 		}
 
-		// Check if all dependencies are complete
-		allDepsComplete := true
-		for _, depType := range component.DependsOn {
-			dep, err := d.GetComponent(depType)
-			if err != nil || dep.State != StateCompleted {
-				allDepsComplete = false
+		var deps []ComponentType
+		inherits := false
+		for _, depType := range c.DependsOn {
+			if depType == componentType {
+				deps = appendUnique(deps, removed.DependsOn...)
+				inherits = true
+			} else {
+				deps = appendUnique(deps, depType)
+			}
+		}
+		if deps == nil && c.DependsOn != nil {
+			deps = []ComponentType{}
+		}
+		c.DependsOn = deps
+
+		for i, group := range c.AnyOf {
+			var kept []ComponentType
+			for _, depType := range group {
+				if depType != componentType {
+					kept = append(kept, depType)
+				}
+			}
+			c.AnyOf[i] = kept
+		}
+		if inherits {
+			for _, group := range removed.AnyOf {
+				c.AnyOf = appendGroup(c.AnyOf, group)
+			}
+		}
+
+		remaining = append(remaining, c)
+	}
+
+	d.components = remaining
+	return d.validateNoCycles()
+}
+
+// AddComponent inserts a component into the graph and makes each of the
+// dependents wait for it as well, so A → C becomes A → B → C when B depends on
+// A and C is a dependent. The component's own dependencies and the dependents
+// must exist, and the graph must stay acyclic; on error the DAG is untouched.
+// Ready states are recomputed afterwards, and now timestamps the changes.
+func (d *DAG) AddComponent(component *Component, now time.Time, dependents ...ComponentType) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.component(component.Type); err == nil {
+		return fmt.Errorf("component %s already exists", component.Type)
+	}
+	for _, dependent := range dependents {
+		if _, err := d.component(dependent); err != nil {
+			return err
+		}
+	}
+
+	// Build the new graph on copies so a rejected insert leaves d as it was
+	candidate := d.clone()
+	added := component.clone()
+	if err := normalizeDependencies(added); err != nil {
+		return err
+	}
+	added.State = StateNeedsInit
+	added.UpdateTime = now
+	applyDefaultEstimate(added)
+	candidate.components = append(candidate.components, added)
+	for _, c := range candidate.components {
+		for _, dependent := range dependents {
+			if c.Type == dependent {
+				c.DependsOn = appendUnique(c.DependsOn, component.Type)
+			}
+		}
+	}
+	if err := candidate.validateDependencies(); err != nil {
+		return err
+	}
+	if err := candidate.validateNoCycles(); err != nil {
+		return err
+	}
+
+	d.components = candidate.components
+	d.repair(now)
+	return nil
+}
+
+// AddAgeVerification gates the order's handoff (DELIVER or PICKUP_READY) on an
+// AGE_VERIFICATION step, which can be done any time after payment
+func (d *DAG) AddAgeVerification(now time.Time) error {
+	handoff := ComponentDeliver
+	if d.SupportsPickup() {
+		handoff = ComponentPickupReady
+	}
+	return d.AddComponent(&Component{
+		Type:      ComponentAgeVerification,
+		DependsOn: []ComponentType{ComponentPayment},
+
+		DisplayOrder: 65,
+	}, now, handoff)
+}
+
+// normalizeDependencies cleans up a component's DependsOn and AnyOf groups as
+// written by hand in a template or bundle: entries are trimmed, blank ones
+// dropped and duplicates removed. A group left with no alternatives is
+// rejected with ErrEmptyAnyOfGroup, and a component naming itself, in
+// DependsOn or an AnyOf group, with ErrSelfDependency.
+func normalizeDependencies(c *Component) error {
+	c.DependsOn = trimDependencies(c.DependsOn)
+	for i, group := range c.AnyOf {
+		group = trimDependencies(group)
+		if len(group) == 0 {
+			return fmt.Errorf("%w: %s", ErrEmptyAnyOfGroup, c.Type)
+		}
+		c.AnyOf[i] = group
+	}
+
+	for _, depType := range c.dependencies() {
+		if depType == c.Type {
+			return fmt.Errorf("%w: %s", ErrSelfDependency, c.Type)
+		}
+	}
+	return nil
+}
+
+// trimDependencies trims each entry, dropping blank ones and duplicates. An
+// empty but non-nil list stays non-nil.
+func trimDependencies(deps []ComponentType) []ComponentType {
+	var trimmed []ComponentType
+	for _, depType := range deps {
+		depType = ComponentType(strings.TrimSpace(string(depType)))
+		if depType == "" {
+			continue
+		}
+		trimmed = appendUnique(trimmed, depType)
+	}
+	if trimmed == nil && deps != nil {
+		trimmed = []ComponentType{}
+	}
+	return trimmed
+}
+
+// appendUnique appends component types that aren't already in the slice
+func appendUnique(types []ComponentType, more ...ComponentType) []ComponentType {
+	for _, t := range more {
+		found := false
+		for _, existing := range types {
+			if existing == t {
+				found = true
 				break
 			}
 		}
+		if !found {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// appendGroup appends a copy of group to groups, unless groups already has
+// one with the same alternatives
+func appendGroup(groups [][]ComponentType, group []ComponentType) [][]ComponentType {
+	for _, existing := range groups {
+		if sameAlternatives(existing, group) {
+			return groups
+		}
+	}
+	return append(groups, append([]ComponentType{}, group...))
+}
+
+// sameAlternatives reports whether two AnyOf groups hold the same types, in
+// any order
+func sameAlternatives(a, b []ComponentType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, componentType := range a {
+		found := false
+		for _, other := range b {
+			if other == componentType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// updateDependentComponents checks all components and moves them to INCOMPLETE
+// if dependencies are met, recording now as their ReadyTime
+func (d *DAG) updateDependentComponents(now time.Time) {
+	for _, component := range d.components {
+		if component.State != StateNeedsInit {
+			continue
+		}
 
 		// If all dependencies met, move to INCOMPLETE (ready to work on)
-		if allDepsComplete {
-			component.State = StateIncomplete
-			component.UpdateTime = time.Now()
+		if d.dependenciesMet(component) {
+			markReady(component, now)
+		}
+	}
+}
+
+// Recompute re-derives every unfinished component's state from its
+// dependencies: INCOMPLETE when they are met, NEEDS_INIT otherwise.
+// Used when a DAG is loaded from JSON rather than built up step by step.
+func (d *DAG) Recompute() {
+	d.Repair()
+}
+
+// Repair fixes component states that contradict their dependencies and
+// returns a description of each fix. It is safe to call on a consistent DAG
+// (nothing changes), e.g. after loading persisted state or bulk edits:
+//   - NEEDS_INIT with every dependency met becomes INCOMPLETE
+//   - INCOMPLETE with an unmet dependency goes back to NEEDS_INIT
+//   - missing ReadyTime/CompleteTime timestamps are backfilled
+//
+// Completed components are never reverted. Changed states are timestamped
+// with Now.
+func (d *DAG) Repair() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.repair(Now())
+}
+
+// repair is Repair for callers already holding mu, timestamping changes with now
+func (d *DAG) repair(now time.Time) []string {
+	repairs := []string{}
+	for _, component := range d.components {
+		if component.State == StateCompleted {
+			if component.CompleteTime == nil {
+				t := component.UpdateTime
+				component.CompleteTime = &t
+				repairs = append(repairs, fmt.Sprintf("%s: COMPLETED without a completion time, backfilled", component.Type))
+			}
+			continue
+		}
+
+		ready := d.dependenciesMet(component)
+		switch {
+		case ready && component.State != StateIncomplete:
+			repairs = append(repairs, fmt.Sprintf("%s: %s with all dependencies met, now INCOMPLETE", component.Type, component.State))
+			markReady(component, now)
+		case !ready && component.State != StateNeedsInit:
+			repairs = append(repairs, fmt.Sprintf("%s: %s with unmet dependencies, now NEEDS_INIT", component.Type, component.State))
+			component.State = StateNeedsInit
+			component.UpdateTime = now
+			component.ReadyTime = nil // Not ready anymore
+		case ready && component.ReadyTime == nil:
+			// Loaded as INCOMPLETE without a recorded ready time
+			t := component.UpdateTime
+			component.ReadyTime = &t
+			repairs = append(repairs, fmt.Sprintf("%s: INCOMPLETE without a ready time, backfilled", component.Type))
+		}
+	}
+	return repairs
+}
+
+// markReady moves a component to INCOMPLETE, recording when it became ready
+func markReady(component *Component, now time.Time) {
+	component.State = StateIncomplete
+	component.UpdateTime = now
+	component.ReadyTime = &now
+}
+
+// dependenciesMet reports whether every DependsOn entry is complete and
+// each AnyOf group has at least one completed member
+func (d *DAG) dependenciesMet(component *Component) bool {
+	for _, depType := range component.DependsOn {
+		if !d.isCompleted(depType) {
+			return false
 		}
 	}
+
+	for _, group := range component.AnyOf {
+		groupMet := false
+		for _, depType := range group {
+			if d.isCompleted(depType) {
+				groupMet = true
+				break
+			}
+		}
+		if !groupMet {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isCompleted reports whether the given component exists and is completed
+func (d *DAG) isCompleted(componentType ComponentType) bool {
+	c, err := d.component(componentType)
+	return err == nil && c.State == StateCompleted
 }
 
-// AllComponentsCompleted checks if all components are done
+// AllComponentsCompleted checks if all required components are done.
+// Optional components are ignored.
 func (d *DAG) AllComponentsCompleted() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	for _, c := range d.components {
-		if c.State != StateCompleted {
+		if !c.Optional && c.State != StateCompleted {
 			return false
 		}
 	}
 	return true
 }
 
-// GetNextComponent returns the next component that can be worked on
+// HasReadyOptionalComponents checks if any optional component is ready but not done
+func (d *DAG) HasReadyOptionalComponents() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.components {
+		if c.Optional && c.State == StateIncomplete {
+			return true
+		}
+	}
+	return false
+}
+
+// GetReadyComponents returns every component that can be worked on now, in
+// DAG order. Parallel branches can make several ready at once.
+func (d *DAG) GetReadyComponents() []*Component {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var ready []*Component
+	for _, c := range d.components {
+		if c.State == StateIncomplete {
+			ready = append(ready, c.clone())
+		}
+	}
+	return ready
+}
+
+// GetNextComponent returns the next component that can be worked on: the
+// first of GetReadyComponents
 func (d *DAG) GetNextComponent() *Component {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	for _, c := range d.components {
 		if c.State == StateIncomplete {
-			return c
+			return c.clone()
 		}
 	}
 	return nil
 }
 
+// StepDurations returns the service time of every completed component
+// that has a recorded ReadyTime, in DAG order
+func (d *DAG) StepDurations() []StepDuration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	durations := []StepDuration{}
+	for _, c := range d.components {
+		if c.State != StateCompleted || c.ReadyTime == nil || c.CompleteTime == nil {
+			continue
+		}
+		durations = append(durations, StepDuration{
+			Component:       c.Type,
+			ReadyTime:       *c.ReadyTime,
+			CompleteTime:    *c.CompleteTime,
+			DurationSeconds: c.CompleteTime.Sub(*c.ReadyTime).Seconds(),
+		})
+	}
+	return durations
+}
+
 // Clone creates a deep copy of the DAG
 func (d *DAG) Clone() *DAG {
-	clonedComponents := make([]*Component, len(d.components))
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.clone()
+}
 
+// clone is Clone for callers already holding mu
+func (d *DAG) clone() *DAG {
+	clonedComponents := make([]*Component, len(d.components))
 	for i, c := range d.components {
-		clonedDeps := make([]ComponentType, len(c.DependsOn))
+		clonedComponents[i] = c.clone()
+	}
+
+	return &DAG{components: clonedComponents}
+}
+
+// clone returns a deep copy of the component, sharing nothing with it
+func (c *Component) clone() *Component {
+	var clonedDeps []ComponentType
+	if c.DependsOn != nil {
+		clonedDeps = make([]ComponentType, len(c.DependsOn))
 		copy(clonedDeps, c.DependsOn)
+	}
 
-		var clonedCompleteTime *time.Time
-		if c.CompleteTime != nil {
-			t := *c.CompleteTime
-			clonedCompleteTime = &t
-		}
+	var clonedAnyOf [][]ComponentType
+	for _, group := range c.AnyOf {
+		clonedGroup := make([]ComponentType, len(group))
+		copy(clonedGroup, group)
+		clonedAnyOf = append(clonedAnyOf, clonedGroup)
+	}
 
-		clonedComponents[i] = &Component{
-			Type:         c.Type,
-			State:        c.State,
-			DependsOn:    clonedDeps,
-			UpdateTime:   c.UpdateTime,
-			CompleteTime: clonedCompleteTime,
+	var clonedCompleteTime *time.Time
+	if c.CompleteTime != nil {
+		t := *c.CompleteTime
+		clonedCompleteTime = &t
+	}
+
+	var clonedReadyTime *time.Time
+	if c.ReadyTime != nil {
+		t := *c.ReadyTime
+		clonedReadyTime = &t
+	}
+
+	cloned := &Component{
+		Type:         c.Type,
+		State:        c.State,
+		DependsOn:    clonedDeps,
+		AnyOf:        clonedAnyOf,
+		UpdateTime:   c.UpdateTime,
+		ReadyTime:    clonedReadyTime,
+		CompleteTime: clonedCompleteTime,
+		RetryCount:   c.RetryCount,
+		Optional:     c.Optional,
+
+		OnCompleteActivity: c.OnCompleteActivity,
+		ActivityTimeout:    c.ActivityTimeout,
+		DisplayOrder:       c.DisplayOrder,
+		EstimatedDuration:  c.EstimatedDuration,
+	}
+	if c.Parameters != nil {
+		cloned.Parameters = make(map[string]string, len(c.Parameters))
+		for name, value := range c.Parameters {
+			cloned.Parameters[name] = value
 		}
 	}
+	return cloned
+}
 
-	return &DAG{components: clonedComponents}
+// cloneComponents deep-copies each component, for getters that hand
+// components out of the lock
+func cloneComponents(components []*Component) []*Component {
+	cloned := make([]*Component, len(components))
+	for i, c := range components {
+		cloned[i] = c.clone()
+	}
+	return cloned
+}
+
+// validateDependencies checks that every dependency refers to a component in the DAG
+func (d *DAG) validateDependencies() error {
+	for _, component := range d.components {
+		for _, depType := range component.dependencies() {
+			if _, err := d.component(depType); err != nil {
+				return fmt.Errorf("component %s depends on unknown component %s", component.Type, depType)
+			}
+		}
+	}
+	return nil
 }
 
 // validateNoCycles checks for circular dependencies
@@ -194,12 +1043,12 @@ func (d *DAG) hasCycle(componentType ComponentType, visited, recStack map[Compon
 	visited[componentType] = true
 	recStack[componentType] = true
 
-	component, err := d.GetComponent(componentType)
+	component, err := d.component(componentType)
 	if err != nil {
 		return false
 	}
 
-	for _, depType := range component.DependsOn {
+	for _, depType := range component.dependencies() {
 		if !visited[depType] {
 			if d.hasCycle(depType, visited, recStack) {
 				return true
@@ -213,10 +1062,36 @@ func (d *DAG) hasCycle(componentType ComponentType, visited, recStack map[Compon
 	return false
 }
 
-// MarshalJSON custom JSON serialization (export components array)
+// MarshalJSON custom JSON serialization (export components array). It
+// encodes a snapshot taken under the lock, so a concurrent completion can't
+// leave the output half updated.
 func (d *DAG) MarshalJSON() ([]byte, error) {
 	// We just return the components array
-	type Alias DAG
-	//return []byte(fmt.Sprintf("%v", d.components)), nil
-	return json.Marshal(d.components)
+	return json.Marshal(d.Clone().components)
+}
+
+// UnmarshalJSON custom JSON deserialization (the inverse of MarshalJSON).
+// The graph is validated exactly as NewDAG does, and d is left untouched if
+// that fails. Ready states are recomputed since they are derived from dependencies.
+func (d *DAG) UnmarshalJSON(data []byte) error {
+	var components []*Component
+	if err := json.Unmarshal(data, &components); err != nil {
+		return err
+	}
+	for i, c := range components {
+		if c == nil {
+			return fmt.Errorf("component %d is null", i)
+		}
+	}
+
+	dag, err := NewDAG(components)
+	if err != nil {
+		return err
+	}
+	dag.Recompute()
+	d.mu.Lock()
+	d.components = dag.components
+	d.mu.Unlock()
+
+	return nil
 }
@@ -0,0 +1,1038 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testTime is the base of the timestamps the tests complete components at
+var testTime = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// mustDAG builds a DAG from step definitions, failing the test on error
+func mustDAG(t *testing.T, steps ...StepDefinition) *DAG {
+	t.Helper()
+	dag, err := NewDAGFromDefinitions(steps, testTime)
+	if err != nil {
+		t.Fatalf("NewDAGFromDefinitions: %v", err)
+	}
+	return dag
+}
+
+// step is a StepDefinition depending on every component in deps
+func step(componentType ComponentType, deps ...ComponentType) StepDefinition {
+	return StepDefinition{Type: componentType, DependsOn: deps}
+}
+
+// states returns each component's state by type
+func states(d *DAG) map[ComponentType]ComponentState {
+	states := make(map[ComponentType]ComponentState)
+	for _, c := range d.GetComponents() {
+		states[c.Type] = c.State
+	}
+	return states
+}
+
+// readyTypes returns the types of the ready components, in DAG order
+func readyTypes(d *DAG) []ComponentType {
+	var ready []ComponentType
+	for _, c := range d.GetReadyComponents() {
+		ready = append(ready, c.Type)
+	}
+	return ready
+}
+
+// mustComponent returns a copy of the component, failing the test if it's missing
+func mustComponent(t *testing.T, d *DAG, componentType ComponentType) *Component {
+	t.Helper()
+	c, err := d.GetComponent(componentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// completeAll completes the components in order, a minute apart
+func completeAll(t testing.TB, d *DAG, componentTypes ...ComponentType) {
+	t.Helper()
+	for i, componentType := range componentTypes {
+		if err := d.CompleteComponentAt(componentType, testTime.Add(time.Duration(i+1)*time.Minute)); err != nil {
+			t.Fatalf("complete %s: %v", componentType, err)
+		}
+	}
+}
+
+func TestNewPizzaOrderDAG(t *testing.T) {
+	dag := NewPizzaOrderDAG(testTime)
+
+	want := map[ComponentType]ComponentState{
+		ComponentPayment:     StateIncomplete,
+		ComponentMakeDough:   StateNeedsInit,
+		ComponentAddToppings: StateNeedsInit,
+		ComponentBakePizza:   StateNeedsInit,
+		ComponentDeliver:     StateNeedsInit,
+		ComponentPhotoProof:  StateNeedsInit,
+	}
+	if got := states(dag); !reflect.DeepEqual(got, want) {
+		t.Errorf("initial states = %v, want %v", got, want)
+	}
+
+	for _, c := range dag.GetComponents() {
+		if (c.ReadyTime != nil) != (c.State == StateIncomplete) {
+			t.Errorf("%s: ReadyTime = %v with state %s", c.Type, c.ReadyTime, c.State)
+		}
+		if c.CompleteTime != nil {
+			t.Errorf("%s: CompleteTime set on a new DAG", c.Type)
+		}
+	}
+	photo, err := dag.GetComponent(ComponentPhotoProof)
+	if err != nil || !photo.Optional {
+		t.Errorf("PHOTO_PROOF should be optional, got %+v, %v", photo, err)
+	}
+}
+
+func TestDependencyReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		completed []ComponentType
+		wantReady []ComponentType
+	}{
+		{
+			name:      "new order",
+			wantReady: []ComponentType{ComponentPayment},
+		},
+		{
+			name:      "payment unlocks dough",
+			completed: []ComponentType{ComponentPayment},
+			wantReady: []ComponentType{ComponentMakeDough},
+		},
+		{
+			name:      "dough unlocks toppings",
+			completed: []ComponentType{ComponentPayment, ComponentMakeDough},
+			wantReady: []ComponentType{ComponentAddToppings},
+		},
+		{
+			name:      "delivery unlocks the optional photo proof",
+			completed: []ComponentType{ComponentPayment, ComponentMakeDough, ComponentAddToppings, ComponentBakePizza, ComponentDeliver},
+			wantReady: []ComponentType{ComponentPhotoProof},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := NewPizzaOrderDAG(testTime)
+			completeAll(t, dag, tt.completed...)
+			if got := readyTypes(dag); !reflect.DeepEqual(got, tt.wantReady) {
+				t.Errorf("ready = %v, want %v", got, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestReadyTime(t *testing.T) {
+	dag := mustDAG(t, step("A"), step("B", "A"), step("C", "A", "B"))
+	readyTime := func(componentType ComponentType) *time.Time {
+		c, _ := dag.GetComponent(componentType)
+		return c.ReadyTime
+	}
+	if got := readyTime("A"); got == nil || !got.Equal(testTime) {
+		t.Errorf("root ReadyTime = %v, want %v", got, testTime)
+	}
+	if readyTime("B") != nil || readyTime("C") != nil {
+		t.Fatal("ReadyTime set before the dependencies completed")
+	}
+
+	// completeAll completes A at +1m and B at +2m
+	completeAll(t, dag, "A")
+	if got := readyTime("B"); got == nil || !got.Equal(testTime.Add(time.Minute)) {
+		t.Errorf("B ReadyTime = %v, want when A completed", got)
+	}
+	if readyTime("C") != nil {
+		t.Error("C has a ReadyTime with B still to do")
+	}
+
+	if err := dag.CompleteComponentAt("B", testTime.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readyTime("C"); got == nil || !got.Equal(testTime.Add(2*time.Minute)) {
+		t.Errorf("C ReadyTime = %v, want when B completed", got)
+	}
+	if got := readyTime("B"); got == nil || !got.Equal(testTime.Add(time.Minute)) {
+		t.Errorf("completing B moved its ReadyTime to %v", got)
+	}
+
+	// ReadyTime survives a JSON round trip
+	data, err := json.Marshal(dag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded DAG
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := decoded.GetComponent("C"); c.ReadyTime == nil || !c.ReadyTime.Equal(testTime.Add(2*time.Minute)) {
+		t.Errorf("decoded C ReadyTime = %v", c.ReadyTime)
+	}
+}
+
+func TestCompleteComponentErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		completed []ComponentType
+		complete  ComponentType
+		wantErr   error
+	}{
+		{
+			name:     "dependencies not complete",
+			complete: ComponentBakePizza,
+			wantErr:  ErrDependenciesNotMet,
+		},
+		{
+			name:      "skipping a step",
+			completed: []ComponentType{ComponentPayment},
+			complete:  ComponentAddToppings,
+			wantErr:   ErrDependenciesNotMet,
+		},
+		{
+			name:      "already completed",
+			completed: []ComponentType{ComponentPayment},
+			complete:  ComponentPayment,
+			wantErr:   ErrComponentNotReady,
+		},
+		{
+			name:     "not in the DAG",
+			complete: ComponentAddSauce,
+			wantErr:  ErrComponentNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := NewPizzaOrderDAG(testTime)
+			completeAll(t, dag, tt.completed...)
+			before := states(dag)
+
+			err := dag.CompleteComponentAt(tt.complete, testTime)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("complete %s: err = %v, want %v", tt.complete, err, tt.wantErr)
+			}
+			if after := states(dag); !reflect.DeepEqual(after, before) {
+				t.Errorf("failed completion changed states from %v to %v", before, after)
+			}
+		})
+	}
+}
+
+func TestDAGMethodErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    func(d *DAG) error
+		wantErr error
+	}{
+		{name: "get missing", wantErr: ErrComponentNotFound, call: func(d *DAG) error {
+			_, err := d.GetComponent(ComponentAddSauce)
+			return err
+		}},
+		{name: "reset missing", wantErr: ErrComponentNotFound, call: func(d *DAG) error {
+			return d.ResetComponentAt(ComponentAddSauce, testTime)
+		}},
+		{name: "reset incomplete", wantErr: ErrComponentNotReady, call: func(d *DAG) error {
+			return d.ResetComponentAt(ComponentMakeDough, testTime)
+		}},
+		{name: "remove missing", wantErr: ErrComponentNotFound, call: func(d *DAG) error {
+			return d.RemoveComponent(ComponentAddSauce, testTime)
+		}},
+		{name: "timeout of missing", wantErr: ErrComponentNotFound, call: func(d *DAG) error {
+			return d.SetActivityTimeout(ComponentAddSauce, time.Second)
+		}},
+		{name: "retry count of missing", wantErr: ErrComponentNotFound, call: func(d *DAG) error {
+			_, err := d.IncrementRetryCount(ComponentAddSauce)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := NewPizzaOrderDAG(testTime)
+			completeAll(t, dag, ComponentPayment)
+			if err := tt.call(dag); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllComponentsCompleted(t *testing.T) {
+	dag := NewPizzaOrderDAG(testTime)
+	required := []ComponentType{ComponentPayment, ComponentMakeDough, ComponentAddToppings, ComponentBakePizza, ComponentDeliver}
+	for i, componentType := range required {
+		if dag.AllComponentsCompleted() {
+			t.Fatalf("AllComponentsCompleted before %s", componentType)
+		}
+		if err := dag.CompleteComponentAt(componentType, testTime.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("complete %s: %v", componentType, err)
+		}
+	}
+
+	// PHOTO_PROOF is optional: it is ready, but doesn't hold the order open
+	if !dag.AllComponentsCompleted() {
+		t.Error("AllComponentsCompleted = false once every required step is done")
+	}
+	if !dag.HasReadyOptionalComponents() {
+		t.Error("HasReadyOptionalComponents = false with PHOTO_PROOF ready")
+	}
+	completeAll(t, dag, ComponentPhotoProof)
+	if dag.HasReadyOptionalComponents() {
+		t.Error("HasReadyOptionalComponents = true after PHOTO_PROOF completed")
+	}
+}
+
+func TestNewDAGValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []StepDefinition
+		wantErr error // nil only checks that there is an error
+	}{
+		{
+			name:  "cycle",
+			steps: []StepDefinition{step("A", "C"), step("B", "A"), step("C", "B")},
+		},
+		{
+			name:  "cycle through anyOf",
+			steps: []StepDefinition{step("A"), {Type: "B", AnyOf: [][]ComponentType{{"A", "C"}}}, step("C", "B")},
+		},
+		{
+			name:  "unknown dependency",
+			steps: []StepDefinition{step("A"), step("B", "MISSING")},
+		},
+		{
+			name:    "self dependency",
+			steps:   []StepDefinition{step("A", "A")},
+			wantErr: ErrSelfDependency,
+		},
+		{
+			name:    "duplicate type",
+			steps:   []StepDefinition{step("A"), step("A")},
+			wantErr: ErrDuplicateComponent,
+		},
+		{
+			name:    "empty anyOf group",
+			steps:   []StepDefinition{step("A"), {Type: "B", AnyOf: [][]ComponentType{{"A"}, {}}}},
+			wantErr: ErrEmptyAnyOfGroup,
+		},
+		{
+			name:    "blank anyOf group",
+			steps:   []StepDefinition{step("A"), {Type: "B", AnyOf: [][]ComponentType{{" ", ""}}}},
+			wantErr: ErrEmptyAnyOfGroup,
+		},
+		{
+			name:    "empty",
+			wantErr: ErrEmptyDAG,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag, err := NewDAGFromDefinitions(tt.steps, testTime)
+			if err == nil {
+				t.Fatalf("got a DAG %v, want an error", dag.Definitions())
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// An empty order would complete the moment it started, so every way of
+// ending up with no components is rejected
+func TestEmptyDAG(t *testing.T) {
+	if _, err := NewDAG(nil); !errors.Is(err, ErrEmptyDAG) {
+		t.Errorf("NewDAG(nil) err = %v, want ErrEmptyDAG", err)
+	}
+	if err := (&DAG{}).Validate(); !errors.Is(err, ErrEmptyDAG) {
+		t.Errorf("Validate on a zero DAG err = %v, want ErrEmptyDAG", err)
+	}
+	var decoded DAG
+	if err := json.Unmarshal([]byte("[]"), &decoded); !errors.Is(err, ErrEmptyDAG) {
+		t.Errorf("unmarshal [] err = %v, want ErrEmptyDAG", err)
+	}
+
+	dag := mustDAG(t, step("ONLY"))
+	if err := dag.RemoveComponent("ONLY", testTime); !errors.Is(err, ErrEmptyDAG) {
+		t.Errorf("removing the last component err = %v, want ErrEmptyDAG", err)
+	}
+	if len(dag.GetComponents()) != 1 {
+		t.Error("rejected removal changed the DAG")
+	}
+}
+
+// A DAG persisted half way through, with stale ready states, loads with the
+// states its completed components imply
+func TestUnmarshalRecomputesHalfCompleteDAG(t *testing.T) {
+	data := []byte(`[
+		{"type": "PAYMENT", "state": "COMPLETED", "dependsOn": [], "updateTime": "2024-06-01T12:01:00Z", "completeTime": "2024-06-01T12:01:00Z"},
+		{"type": "MAKE_DOUGH", "state": "COMPLETED", "dependsOn": ["PAYMENT"], "updateTime": "2024-06-01T12:02:00Z", "completeTime": "2024-06-01T12:02:00Z"},
+		{"type": "ADD_TOPPINGS", "state": "NEEDS_INIT", "dependsOn": ["MAKE_DOUGH"], "updateTime": "2024-06-01T12:00:00Z"},
+		{"type": "BAKE_PIZZA", "state": "INCOMPLETE", "dependsOn": ["ADD_TOPPINGS"], "updateTime": "2024-06-01T12:00:00Z"},
+		{"type": "DELIVER", "state": "NEEDS_INIT", "dependsOn": ["BAKE_PIZZA"], "updateTime": "2024-06-01T12:00:00Z"}
+	]`)
+	var dag DAG
+	if err := json.Unmarshal(data, &dag); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[ComponentType]ComponentState{
+		ComponentPayment:     StateCompleted,
+		ComponentMakeDough:   StateCompleted,
+		ComponentAddToppings: StateIncomplete,
+		ComponentBakePizza:   StateNeedsInit,
+		ComponentDeliver:     StateNeedsInit,
+	}
+	if got := states(&dag); !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+	if next := dag.GetNextComponent(); next == nil || next.Type != ComponentAddToppings {
+		t.Errorf("next = %v, want ADD_TOPPINGS", next)
+	}
+	if toppings, _ := dag.GetComponent(ComponentAddToppings); toppings.ReadyTime == nil {
+		t.Error("ADD_TOPPINGS became ready without a ReadyTime")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	done := testTime.Add(time.Minute)
+	repairedAt := testTime.Add(time.Hour)
+	saved := Now
+	Now = func() time.Time { return repairedAt }
+	t.Cleanup(func() { Now = saved })
+
+	tests := []struct {
+		name       string
+		components []*Component // A then B, with B depending on A
+		wantState  ComponentState
+		wantReady  *time.Time // B's ReadyTime after the repair
+		wantRepair string
+	}{
+		{
+			name: "waiting with its dependencies met",
+			components: []*Component{
+				{Type: "A", State: StateCompleted, UpdateTime: done, CompleteTime: &done},
+				{Type: "B", State: StateNeedsInit, DependsOn: []ComponentType{"A"}, UpdateTime: testTime},
+			},
+			wantState: StateIncomplete, wantReady: &repairedAt, wantRepair: "B: NEEDS_INIT with all dependencies met, now INCOMPLETE",
+		},
+		{
+			name: "ready with a dependency incomplete",
+			components: []*Component{
+				{Type: "A", State: StateIncomplete, UpdateTime: testTime, ReadyTime: &testTime},
+				{Type: "B", State: StateIncomplete, DependsOn: []ComponentType{"A"}, UpdateTime: testTime, ReadyTime: &testTime},
+			},
+			wantState: StateNeedsInit, wantRepair: "B: INCOMPLETE with unmet dependencies, now NEEDS_INIT",
+		},
+		{
+			name: "ready without a ready time",
+			components: []*Component{
+				{Type: "A", State: StateCompleted, UpdateTime: done, CompleteTime: &done},
+				{Type: "B", State: StateIncomplete, DependsOn: []ComponentType{"A"}, UpdateTime: done},
+			},
+			wantState: StateIncomplete, wantReady: &done, wantRepair: "B: INCOMPLETE without a ready time, backfilled",
+		},
+		{
+			name: "completed without a completion time",
+			components: []*Component{
+				{Type: "A", State: StateCompleted, UpdateTime: done, CompleteTime: &done},
+				{Type: "B", State: StateCompleted, DependsOn: []ComponentType{"A"}, UpdateTime: done, ReadyTime: &done},
+			},
+			wantState: StateCompleted, wantReady: &done, wantRepair: "B: COMPLETED without a completion time, backfilled",
+		},
+		{
+			name: "completed with a dependency incomplete",
+			components: []*Component{
+				{Type: "A", State: StateIncomplete, UpdateTime: testTime, ReadyTime: &testTime},
+				{Type: "B", State: StateCompleted, DependsOn: []ComponentType{"A"}, UpdateTime: done, ReadyTime: &done, CompleteTime: &done},
+			},
+			wantState: StateCompleted, wantReady: &done, // Completed work is never reverted
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := &DAG{components: tt.components}
+			repairs := dag.Repair()
+			if tt.wantRepair == "" {
+				if len(repairs) != 0 {
+					t.Errorf("repairs = %q, want none", repairs)
+				}
+			} else if len(repairs) != 1 || repairs[0] != tt.wantRepair {
+				t.Errorf("repairs = %q, want [%q]", repairs, tt.wantRepair)
+			}
+
+			b, _ := dag.GetComponent("B")
+			if b.State != tt.wantState {
+				t.Errorf("B = %s, want %s", b.State, tt.wantState)
+			}
+			if (b.ReadyTime == nil) != (tt.wantReady == nil) || (b.ReadyTime != nil && !b.ReadyTime.Equal(*tt.wantReady)) {
+				t.Errorf("B ReadyTime = %v, want %v", b.ReadyTime, tt.wantReady)
+			}
+			if b.State == StateCompleted && b.CompleteTime == nil {
+				t.Error("B is COMPLETED without a CompleteTime")
+			}
+
+			// The repaired DAG is consistent
+			if again := dag.Repair(); len(again) != 0 {
+				t.Errorf("second Repair = %q, want none", again)
+			}
+		})
+	}
+}
+
+func TestNormalizeDependencies(t *testing.T) {
+	tests := []struct {
+		name      string
+		component StepDefinition
+		wantDeps  []ComponentType
+		wantAnyOf [][]ComponentType
+		wantErr   error
+	}{
+		{
+			name:      "duplicates",
+			component: StepDefinition{Type: "C", DependsOn: []ComponentType{"A", "B", "A"}, AnyOf: [][]ComponentType{{"A", "B", "B"}}},
+			wantDeps:  []ComponentType{"A", "B"},
+			wantAnyOf: [][]ComponentType{{"A", "B"}},
+		},
+		{
+			name:      "blank and padded entries",
+			component: StepDefinition{Type: "C", DependsOn: []ComponentType{"", " A ", "  "}, AnyOf: [][]ComponentType{{"B", " "}}},
+			wantDeps:  []ComponentType{"A"},
+			wantAnyOf: [][]ComponentType{{"B"}},
+		},
+		{
+			name:      "padded duplicate",
+			component: StepDefinition{Type: "C", DependsOn: []ComponentType{"A", "A "}},
+			wantDeps:  []ComponentType{"A"},
+		},
+		{
+			name:      "self in DependsOn",
+			component: StepDefinition{Type: "C", DependsOn: []ComponentType{"A", "C"}},
+			wantErr:   ErrSelfDependency,
+		},
+		{
+			name:      "padded self",
+			component: StepDefinition{Type: "C", DependsOn: []ComponentType{" C"}},
+			wantErr:   ErrSelfDependency,
+		},
+		{
+			name:      "self in an anyOf group",
+			component: StepDefinition{Type: "C", AnyOf: [][]ComponentType{{"A", "C"}}},
+			wantErr:   ErrSelfDependency,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Through NewDAG, and through AddComponent on a DAG that exists
+			dag, err := NewDAGFromDefinitions([]StepDefinition{step("A"), step("B"), tt.component}, testTime)
+			added := mustDAG(t, step("A"), step("B"))
+			input := &Component{Type: tt.component.Type, DependsOn: tt.component.DependsOn, AnyOf: tt.component.AnyOf}
+			givenAnyOf := input.clone().AnyOf
+			addErr := added.AddComponent(input, testTime)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(addErr, tt.wantErr) {
+					t.Errorf("NewDAG err = %v, AddComponent err = %v, want %v", err, addErr, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || addErr != nil {
+				t.Fatalf("NewDAG err = %v, AddComponent err = %v", err, addErr)
+			}
+			for _, d := range []*DAG{dag, added} {
+				c, _ := d.GetComponent("C")
+				if !slices.Equal(c.DependsOn, tt.wantDeps) {
+					t.Errorf("DependsOn = %q, want %q", c.DependsOn, tt.wantDeps)
+				}
+				if !reflect.DeepEqual(c.AnyOf, tt.wantAnyOf) {
+					t.Errorf("AnyOf = %q, want %q", c.AnyOf, tt.wantAnyOf)
+				}
+			}
+			// AddComponent normalizes a copy, leaving the caller's component alone
+			if !reflect.DeepEqual(input.AnyOf, givenAnyOf) {
+				t.Errorf("AddComponent changed its input's AnyOf to %q", input.AnyOf)
+			}
+		})
+	}
+}
+
+func TestResetCascade(t *testing.T) {
+	// B and C both need A, D needs B and C, and E needs A or F
+	steps := []StepDefinition{
+		step("A"), step("B", "A"), step("C", "A"), step("D", "B", "C"), step("F"),
+		{Type: "E", AnyOf: [][]ComponentType{{"A", "F"}}},
+	}
+	resetAt := testTime.Add(time.Hour)
+
+	dag := mustDAG(t, steps...)
+	completeAll(t, dag, "A", "F") // E is ready through either alternative
+	eReady := *mustComponent(t, dag, "E").ReadyTime
+	if err := dag.ResetComponentAt("A", resetAt); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[ComponentType]ComponentState{
+		"A": StateIncomplete,
+		"B": StateNeedsInit,
+		"C": StateNeedsInit,
+		"D": StateNeedsInit,
+		"E": StateIncomplete, // F still completes its group
+		"F": StateCompleted,
+	}
+	if got := states(dag); !reflect.DeepEqual(got, want) {
+		t.Errorf("states after reset = %v, want %v", got, want)
+	}
+	a := mustComponent(t, dag, "A")
+	if a.CompleteTime != nil || a.ReadyTime == nil || !a.ReadyTime.Equal(resetAt) {
+		t.Errorf("A complete=%v ready=%v, want no CompleteTime and ready at the reset", a.CompleteTime, a.ReadyTime)
+	}
+	for _, componentType := range []ComponentType{"B", "C"} {
+		if c := mustComponent(t, dag, componentType); c.ReadyTime != nil {
+			t.Errorf("%s kept ReadyTime %v after going back to NEEDS_INIT", componentType, c.ReadyTime)
+		}
+	}
+	if e := mustComponent(t, dag, "E"); !e.ReadyTime.Equal(eReady) {
+		t.Errorf("E ReadyTime moved to %v, want %v", e.ReadyTime, eReady)
+	}
+
+	// Redoing A unlocks both dependents again
+	if err := dag.CompleteComponentAt("A", resetAt.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := readyTypes(dag); !slices.Equal(got, []ComponentType{"B", "C", "E"}) {
+		t.Errorf("ready after redoing A = %v, want [B C E]", got)
+	}
+
+	// Without F, E loses its only completed alternative too
+	dag = mustDAG(t, steps...)
+	completeAll(t, dag, "A")
+	if err := dag.ResetComponentAt("A", resetAt); err != nil {
+		t.Fatal(err)
+	}
+	if got := states(dag)["E"]; got != StateNeedsInit {
+		t.Errorf("E = %s with neither alternative complete, want NEEDS_INIT", got)
+	}
+
+	// A completed dependent blocks the reset, leaving the DAG as it was
+	dag = mustDAG(t, steps...)
+	completeAll(t, dag, "A", "B")
+	before := states(dag)
+	if err := dag.ResetComponentAt("A", resetAt); err == nil {
+		t.Error("reset A with B already completed")
+	}
+	if got := states(dag); !reflect.DeepEqual(got, before) {
+		t.Errorf("rejected reset changed states to %v, want %v", got, before)
+	}
+}
+
+func TestAnyOfGroups(t *testing.T) {
+	// PACK needs BOX and either of the two ovens
+	steps := []StepDefinition{
+		step("PREP"),
+		step("OVEN_A", "PREP"),
+		step("OVEN_B", "PREP"),
+		step("BOX"),
+		{Type: "PACK", DependsOn: []ComponentType{"BOX"}, AnyOf: [][]ComponentType{{"OVEN_A", "OVEN_B"}}},
+	}
+	tests := []struct {
+		name      string
+		completed []ComponentType
+		wantReady bool
+	}{
+		{name: "nothing done", wantReady: false},
+		{name: "only the DependsOn", completed: []ComponentType{"BOX"}, wantReady: false},
+		{name: "only an alternative", completed: []ComponentType{"PREP", "OVEN_A"}, wantReady: false},
+		{name: "first alternative", completed: []ComponentType{"BOX", "PREP", "OVEN_A"}, wantReady: true},
+		{name: "second alternative", completed: []ComponentType{"BOX", "PREP", "OVEN_B"}, wantReady: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := mustDAG(t, steps...)
+			completeAll(t, dag, tt.completed...)
+			if got := states(dag)["PACK"] == StateIncomplete; got != tt.wantReady {
+				t.Errorf("PACK ready = %v, want %v", got, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestMultipleAnyOfGroups(t *testing.T) {
+	// SERVE needs a crust (THIN or THICK) and a sauce (RED or WHITE)
+	steps := []StepDefinition{
+		step("THIN"), step("THICK"), step("RED"), step("WHITE"),
+		{Type: "SERVE", AnyOf: [][]ComponentType{{"THIN", "THICK"}, {"RED", "WHITE"}}},
+	}
+	tests := []struct {
+		name      string
+		completed []ComponentType
+		wantReady bool
+	}{
+		{name: "one group met", completed: []ComponentType{"THIN", "THICK"}, wantReady: false},
+		{name: "other group met", completed: []ComponentType{"WHITE"}, wantReady: false},
+		{name: "both groups met", completed: []ComponentType{"THICK", "RED"}, wantReady: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := mustDAG(t, steps...)
+			completeAll(t, dag, tt.completed...)
+			if got := states(dag)["SERVE"] == StateIncomplete; got != tt.wantReady {
+				t.Errorf("SERVE ready = %v, want %v", got, tt.wantReady)
+			}
+		})
+	}
+
+	// The second alternative finishing later doesn't unlock SERVE again
+	dag := mustDAG(t, steps...)
+	completeAll(t, dag, "THIN", "RED")
+	serve, _ := dag.GetComponent("SERVE")
+	if serve.ReadyTime == nil {
+		t.Fatal("SERVE has no ReadyTime once both groups are met")
+	}
+	readyAt := *serve.ReadyTime
+	if err := dag.CompleteComponentAt("THICK", testTime.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if serve, _ = dag.GetComponent("SERVE"); serve.State != StateIncomplete || !serve.ReadyTime.Equal(readyAt) {
+		t.Errorf("SERVE = %s ready at %v after THICK, want INCOMPLETE ready at %v", serve.State, serve.ReadyTime, readyAt)
+	}
+}
+
+func TestOptionalSteps(t *testing.T) {
+	dag := mustDAG(t,
+		step("COOK"),
+		StepDefinition{Type: "GARNISH", DependsOn: []ComponentType{"COOK"}, Optional: true},
+		step("SERVE", "COOK"),
+	)
+	completeAll(t, dag, "COOK")
+	if dag.AllComponentsCompleted() {
+		t.Fatal("AllComponentsCompleted with SERVE still to do")
+	}
+	completeAll(t, dag, "SERVE")
+	if !dag.AllComponentsCompleted() {
+		t.Error("an incomplete optional step held the DAG open")
+	}
+	if got := states(dag)["GARNISH"]; got != StateIncomplete {
+		t.Errorf("GARNISH = %s, want INCOMPLETE", got)
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	dag := mustDAG(t,
+		step("A"),
+		StepDefinition{Type: "B", DependsOn: []ComponentType{"A"}, AnyOf: [][]ComponentType{{"A"}}},
+	)
+	if err := dag.SetParameter("A", "temperature", "500"); err != nil {
+		t.Fatal(err)
+	}
+	clone := dag.Clone()
+
+	completeAll(t, dag, "A")
+	original := dag.components[1]
+	original.DependsOn[0] = "CHANGED"
+	original.AnyOf[0][0] = "CHANGED"
+	dag.components[0].Parameters["temperature"] = "900"
+
+	wantStates := map[ComponentType]ComponentState{"A": StateIncomplete, "B": StateNeedsInit}
+	if got := states(clone); !reflect.DeepEqual(got, wantStates) {
+		t.Errorf("clone states = %v, want %v", got, wantStates)
+	}
+	cloned, _ := clone.GetComponent("B")
+	if cloned.DependsOn[0] != "A" || cloned.AnyOf[0][0] != "A" {
+		t.Errorf("clone edges changed with the original: %v %v", cloned.DependsOn, cloned.AnyOf)
+	}
+	clonedA, _ := clone.GetComponent("A")
+	if clonedA.Parameters["temperature"] != "500" || clonedA.CompleteTime != nil {
+		t.Errorf("clone A changed with the original: %+v", clonedA)
+	}
+}
+
+// diamondDAG is a start step fanning out to two branches that join again
+func diamondDAG(t testing.TB) *DAG {
+	dag, err := NewDAGFromDefinitions([]StepDefinition{
+		step("START"),
+		step("LEFT", "START"),
+		step("RIGHT", "START"),
+		step("JOIN", "LEFT", "RIGHT"),
+	}, testTime)
+	if err != nil {
+		t.Fatalf("diamond: %v", err)
+	}
+	return dag
+}
+
+// FuzzDAGRoundTrip feeds arbitrary JSON to UnmarshalJSON. Anything it accepts
+// must pass Validate and survive marshal -> unmarshal unchanged; anything it
+// rejects, NewDAG must reject too.
+func FuzzDAGRoundTrip(f *testing.F) {
+	for _, template := range DefaultTemplates.Templates() {
+		dag := template.Build(testTime)
+		completeAll(f, dag, ComponentPayment)
+		for _, d := range []*DAG{template.Build(testTime), dag} {
+			data, err := json.Marshal(d)
+			if err != nil {
+				f.Fatalf("marshal %s: %v", template.Name, err)
+			}
+			f.Add(data)
+		}
+	}
+	diamond, err := json.Marshal(diamondDAG(f))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(diamond)
+	// Graphs validation must reject
+	f.Add([]byte(`[{"type":"A","dependsOn":["B"]},{"type":"B","dependsOn":["A"]}]`))
+	f.Add([]byte(`[{"type":"A","dependsOn":["MISSING"]}]`))
+	f.Add([]byte(`[{"type":"A","anyOf":[["A"]]}]`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var dag DAG
+		unmarshalErr := json.Unmarshal(data, &dag)
+
+		// Whatever UnmarshalJSON decides, NewDAG decides the same for the
+		// same components
+		var components []*Component
+		if json.Unmarshal(data, &components) == nil && !slices.Contains(components, nil) {
+			_, newErr := NewDAG(components)
+			if (newErr == nil) != (unmarshalErr == nil) {
+				t.Fatalf("UnmarshalJSON err = %v but NewDAG err = %v", unmarshalErr, newErr)
+			}
+		}
+		if unmarshalErr != nil {
+			return
+		}
+
+		if err := dag.Validate(); err != nil {
+			t.Fatalf("UnmarshalJSON accepted a DAG that fails Validate: %v", err)
+		}
+		encoded, err := json.Marshal(&dag)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var decoded DAG
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("unmarshal of our own output: %v\n%s", err, encoded)
+		}
+		reencoded, err := json.Marshal(&decoded)
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("round trip changed the DAG:\n%s\n%s", encoded, reencoded)
+		}
+		if !reflect.DeepEqual(states(&dag), states(&decoded)) {
+			t.Fatalf("round trip changed states: %v, %v", states(&dag), states(&decoded))
+		}
+	})
+}
+
+func TestRemoveItem(t *testing.T) {
+	items := []OrderItem{{ID: "A", Amount: 10}, {ID: "B", Amount: 12}, {ID: "C", Amount: 8}}
+	bakeA, bakeB, bakeC := ItemBakeComponent("A"), ItemBakeComponent("B"), ItemBakeComponent("C")
+	prepA, prepB, prepC := ItemPrepComponent("A"), ItemPrepComponent("B"), ItemPrepComponent("C")
+
+	t.Run("DELIVER waits on the other items", func(t *testing.T) {
+		dag := mustDAG(t, ItemSteps(items)...)
+		completeAll(t, dag, ComponentPayment, prepA, bakeA)
+		if err := dag.RemoveItem("B", testTime.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		for _, removed := range []ComponentType{prepB, bakeB} {
+			if _, err := dag.GetComponent(removed); !errors.Is(err, ErrComponentNotFound) {
+				t.Errorf("%s still in the DAG: %v", removed, err)
+			}
+		}
+		deliver, _ := dag.GetComponent(ComponentDeliver)
+		if deliver.State != StateNeedsInit {
+			t.Errorf("DELIVER = %s with C unbaked, want NEEDS_INIT", deliver.State)
+		}
+		completeAll(t, dag, prepC, bakeC)
+		if got := states(dag)[ComponentDeliver]; got != StateIncomplete {
+			t.Errorf("DELIVER = %s after the other items baked, want INCOMPLETE", got)
+		}
+	})
+
+	t.Run("removing the only unbaked item readies DELIVER", func(t *testing.T) {
+		dag := mustDAG(t, ItemSteps(items)...)
+		completeAll(t, dag, ComponentPayment, prepA, bakeA, prepB, bakeB, prepC)
+		removedAt := testTime.Add(time.Hour)
+		if err := dag.RemoveItem("C", removedAt); err != nil {
+			t.Fatal(err)
+		}
+		deliver, _ := dag.GetComponent(ComponentDeliver)
+		if deliver.State != StateIncomplete || deliver.ReadyTime == nil || !deliver.ReadyTime.Equal(removedAt) {
+			t.Errorf("DELIVER = %s ready at %v, want INCOMPLETE at %v", deliver.State, deliver.ReadyTime, removedAt)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		items     []OrderItem
+		completed []ComponentType
+		remove    string
+		wantErr   error
+	}{
+		{name: "baked item", items: items, completed: []ComponentType{ComponentPayment, prepA, bakeA}, remove: "A", wantErr: ErrItemAlreadyBaked},
+		{name: "last item", items: items[:1], remove: "A", wantErr: ErrLastItem},
+		{name: "unknown item", items: items, remove: "Z", wantErr: ErrComponentNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dag := mustDAG(t, ItemSteps(tt.items)...)
+			completeAll(t, dag, tt.completed...)
+			before := states(dag)
+			if err := dag.RemoveItem(tt.remove, testTime.Add(time.Hour)); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RemoveItem = %v, want %v", err, tt.wantErr)
+			}
+			if got := states(dag); !reflect.DeepEqual(got, before) {
+				t.Errorf("rejected removal changed the DAG: %v, was %v", got, before)
+			}
+		})
+	}
+}
+
+// TestConcurrentReadAndComplete reads components while another goroutine
+// completes them. Run with -race: the getters hand out copies, so reading
+// one never races with the DAG changing it.
+func TestConcurrentReadAndComplete(t *testing.T) {
+	dag := NewPizzaOrderDAG(testTime)
+	order, err := dag.TopologicalSort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i, c := range order {
+			if err := dag.CompleteComponentAt(c.Type, testTime.Add(time.Duration(i+1)*time.Minute)); err != nil {
+				t.Errorf("complete %s: %v", c.Type, err)
+				return
+			}
+			if _, err := dag.IncrementRetryCount(c.Type); err != nil {
+				t.Errorf("retry %s: %v", c.Type, err)
+			}
+		}
+	}()
+
+	// The last pass starts after the writer is done, so it sees every step
+	// completed and retried
+	var completed, retried int
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		completed, retried = 0, 0
+		for _, c := range dag.ComponentsByDisplayOrder() {
+			if c.State == StateCompleted && c.CompleteTime != nil {
+				completed++
+			}
+			retried += c.RetryCount
+		}
+		if deliver, err := dag.GetComponent(ComponentDeliver); err == nil && deliver.ReadyTime != nil {
+			deliver.Parameters = map[string]string{"driver": "changed on the copy"}
+		}
+	}
+	if completed != len(order) || retried != len(order) {
+		t.Errorf("last read saw %d completed and %d retries, want %d of each", completed, retried, len(order))
+	}
+	if deliver, _ := dag.GetComponent(ComponentDeliver); deliver.Parameters != nil {
+		t.Errorf("changing a returned component changed the DAG: %v", deliver.Parameters)
+	}
+}
+
+func TestRemoveComponent(t *testing.T) {
+	// BOX waits for PREP and either oven; PACK waits for BOX
+	steps := []StepDefinition{
+		step("PREP"),
+		step("OVEN_A", "PREP"),
+		step("OVEN_B", "PREP"),
+		{Type: "BOX", DependsOn: []ComponentType{"PREP"}, AnyOf: [][]ComponentType{{"OVEN_A", "OVEN_B"}}},
+		step("PACK", "BOX"),
+	}
+
+	t.Run("dependents inherit the middle node's edges", func(t *testing.T) {
+		dag := mustDAG(t, steps...)
+		if err := dag.RemoveComponent("BOX", testTime); err != nil {
+			t.Fatal(err)
+		}
+		pack, _ := dag.GetComponent("PACK")
+		if want := []ComponentType{"PREP"}; !reflect.DeepEqual(pack.DependsOn, want) {
+			t.Errorf("PACK DependsOn = %v, want %v", pack.DependsOn, want)
+		}
+		if want := [][]ComponentType{{"OVEN_A", "OVEN_B"}}; !reflect.DeepEqual(pack.AnyOf, want) {
+			t.Errorf("PACK AnyOf = %v, want %v", pack.AnyOf, want)
+		}
+
+		// PACK still waits for an oven, as it did through BOX
+		completeAll(t, dag, "PREP")
+		if got := states(dag)["PACK"]; got != StateNeedsInit {
+			t.Errorf("PACK = %s with no oven done, want NEEDS_INIT", got)
+		}
+		completeAll(t, dag, "OVEN_B")
+		if got := states(dag)["PACK"]; got != StateIncomplete {
+			t.Errorf("PACK = %s after OVEN_B, want INCOMPLETE", got)
+		}
+	})
+
+	t.Run("an inherited group isn't duplicated", func(t *testing.T) {
+		dag := mustDAG(t, append(steps[:4:4],
+			StepDefinition{Type: "PACK", DependsOn: []ComponentType{"BOX"}, AnyOf: [][]ComponentType{{"OVEN_B", "OVEN_A"}}})...)
+		if err := dag.RemoveComponent("BOX", testTime); err != nil {
+			t.Fatal(err)
+		}
+		pack, _ := dag.GetComponent("PACK")
+		if len(pack.AnyOf) != 1 {
+			t.Errorf("PACK AnyOf = %v, want one group", pack.AnyOf)
+		}
+	})
+
+	t.Run("an alternative is dropped from its group", func(t *testing.T) {
+		dag := mustDAG(t, steps...)
+		if err := dag.RemoveComponent("OVEN_A", testTime); err != nil {
+			t.Fatal(err)
+		}
+		box, _ := dag.GetComponent("BOX")
+		if want := [][]ComponentType{{"OVEN_B"}}; !reflect.DeepEqual(box.AnyOf, want) {
+			t.Errorf("BOX AnyOf = %v, want %v", box.AnyOf, want)
+		}
+		if err := dag.RemoveComponent("OVEN_B", testTime); err == nil {
+			t.Error("removed the last alternative of BOX's group")
+		}
+	})
+
+	t.Run("removed middle node's dependents become ready", func(t *testing.T) {
+		dag := mustDAG(t, steps...)
+		completeAll(t, dag, "PREP", "OVEN_A")
+		removedAt := testTime.Add(time.Hour)
+		if err := dag.RemoveComponent("BOX", removedAt); err != nil {
+			t.Fatal(err)
+		}
+		pack, _ := dag.GetComponent("PACK")
+		if pack.State != StateIncomplete || pack.ReadyTime == nil || !pack.ReadyTime.Equal(removedAt) {
+			t.Errorf("PACK = %s ready at %v, want INCOMPLETE at %v", pack.State, pack.ReadyTime, removedAt)
+		}
+	})
+}
+
+// TestCriticalPathEmptyAnyOfGroup builds the DAG by hand, as loading a
+// malformed bundle might, since NewDAG rejects empty groups
+func TestCriticalPathEmptyAnyOfGroup(t *testing.T) {
+	dag := &DAG{components: []*Component{
+		{Type: "A", EstimatedDuration: time.Minute},
+		{Type: "B", DependsOn: []ComponentType{"A"}, AnyOf: [][]ComponentType{{}}, EstimatedDuration: 2 * time.Minute},
+	}}
+	path, total := dag.CriticalPath()
+	if want := []ComponentType{"A", "B"}; !reflect.DeepEqual(path, want) || total != 3*time.Minute {
+		t.Errorf("CriticalPath = %v, %s; want %v, 3m0s", path, total, want)
+	}
+}
@@ -0,0 +1,86 @@
+package types
+
+import "time"
+
+// DefaultEstimatedDurations is how long each built-in step usually takes. NewDAG
+// fills in any component that has no EstimatedDuration of its own.
+var DefaultEstimatedDurations = map[ComponentType]time.Duration{
+	ComponentPayment:         10 * time.Second,
+	ComponentMakeDough:       2 * time.Minute,
+	ComponentProofDough:      5 * time.Minute,
+	ComponentRestDough:       2 * time.Minute, // The workflow's DoughRestDuration
+	ComponentAddSauce:        1 * time.Minute,
+	ComponentAddCheese:       1 * time.Minute,
+	ComponentAddToppings:     2 * time.Minute,
+	ComponentBakePizza:       12 * time.Minute,
+	ComponentDeliver:         20 * time.Minute,
+	ComponentPickupReady:     30 * time.Second,
+	ComponentPhotoProof:      1 * time.Minute,
+	ComponentAgeVerification: 1 * time.Minute,
+}
+
+// applyDefaultEstimate fills in the component's EstimatedDuration from
+// DefaultEstimatedDurations, unless it already has one
+func applyDefaultEstimate(c *Component) {
+	if c.EstimatedDuration == 0 {
+		c.EstimatedDuration = DefaultEstimatedDurations[c.Type]
+	}
+}
+
+// CriticalPath returns the chain of steps that decides how long the order takes,
+// and its total EstimatedDuration: the longest path through the graph. Parallel
+// branches contribute only the slower one, and an AnyOf group the fastest of
+// its alternatives. The path ends at the last required step; optional steps
+// only count when a required one waits for them.
+func (d *DAG) CriticalPath() ([]ComponentType, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sorted, err := d.topologicalSort()
+	if err != nil {
+		return nil, 0
+	}
+
+	// finish is the earliest a step can be done; via is the dependency it waited on last
+	finish := make(map[ComponentType]time.Duration, len(sorted))
+	via := make(map[ComponentType]ComponentType, len(sorted))
+	var last ComponentType
+	for _, c := range sorted {
+		var start time.Duration
+		wait := func(depType ComponentType, at time.Duration) {
+			if at > start || via[c.Type] == "" {
+				start = at
+				via[c.Type] = depType
+			}
+		}
+		for _, depType := range c.DependsOn {
+			wait(depType, finish[depType])
+		}
+		for _, group := range c.AnyOf {
+			if len(group) == 0 {
+				continue // NewDAG rejects these; nothing to wait for
+			}
+			fastest := group[0]
+			for _, depType := range group[1:] {
+				if finish[depType] < finish[fastest] {
+					fastest = depType
+				}
+			}
+			wait(fastest, finish[fastest])
+		}
+
+		finish[c.Type] = start + c.EstimatedDuration
+		if !c.Optional && (last == "" || finish[c.Type] > finish[last]) {
+			last = c.Type
+		}
+	}
+	if last == "" {
+		return nil, 0
+	}
+
+	var path []ComponentType
+	for step := last; step != ""; step = via[step] {
+		path = append([]ComponentType{step}, path...)
+	}
+	return path, finish[last]
+}
@@ -0,0 +1,75 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Event is one entry in an order's business-level event log
+type Event struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`            // e.g. "ORDER_CREATED", "PAYMENT_COMPLETED"
+	Detail string    `json:"detail,omitempty"` // e.g. the payment transaction ID
+}
+
+// BuildEventLog derives a chronological event log from the order state: its
+// creation, then each completed step with the details recorded for it. Unlike
+// the raw workflow history, it only contains business events.
+func BuildEventLog(order *PizzaOrder) []Event {
+	events := []Event{{Time: order.CreateTime, Event: "ORDER_CREATED", Detail: order.CustomerName}}
+
+	if order.DAG != nil {
+		for _, c := range order.DAG.GetComponents() {
+			if c.State != StateCompleted || c.CompleteTime == nil {
+				continue
+			}
+			events = append(events, Event{
+				Time:   *c.CompleteTime,
+				Event:  string(c.Type) + "_COMPLETED",
+				Detail: stepDetail(order, c.Type),
+			})
+		}
+	}
+
+	// Stable, so same-time events keep DAG order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// stepDetail summarizes what a completed step recorded on the order
+func stepDetail(order *PizzaOrder, componentType ComponentType) string {
+	switch componentType {
+	case ComponentPayment:
+		if order.CompReason != "" {
+			return "Comped: " + order.CompReason
+		}
+		return order.PaymentTxnID
+	case ComponentMakeDough:
+		return order.DoughType
+	case ComponentAddToppings:
+		return strings.Join(order.Toppings, ", ")
+	case ComponentBakePizza:
+		if order.DAG != nil {
+			if bake, err := order.DAG.GetComponent(ComponentBakePizza); err == nil && bake.Parameters["temperature"] != "" {
+				return bake.Parameters["temperature"] + "°F"
+			}
+		}
+	case ComponentDeliver:
+		if order.ManualDispatch {
+			return fmt.Sprintf("Driver %s (manual dispatch)", order.DriverName)
+		}
+		if len(order.Deliveries) > 1 {
+			return fmt.Sprintf("%d deliveries scheduled", len(order.Deliveries))
+		}
+		if order.DeliveryID != "" {
+			return fmt.Sprintf("Driver %s (%s)", order.DriverName, order.DeliveryID)
+		}
+	case ComponentPhotoProof:
+		return order.ProofPhotoURL
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+// OrderBundleVersion is bumped whenever the bundle layout changes
+const OrderBundleVersion = 1
+
+// OrderBundle is a self-contained export of an order for archival or transfer
+// to another system: its state, graph, pricing and event log
+type OrderBundle struct {
+	Version    int              `json:"version"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Order      *PizzaOrder      `json:"order"`
+	Steps      []StepDefinition `json:"steps"` // Graph structure with edges, no runtime state
+	Pricing    PriceBreakdown   `json:"pricing"`
+	Events     []Event          `json:"events"`
+}
+
+// NewOrderBundle packages the order for export
+func NewOrderBundle(order *PizzaOrder, exportedAt time.Time) *OrderBundle {
+	bundle := &OrderBundle{
+		Version:    OrderBundleVersion,
+		ExportedAt: exportedAt,
+		Order:      order,
+		Pricing:    order.PriceBreakdown(),
+		Events:     BuildEventLog(order),
+	}
+	if order.DAG != nil {
+		bundle.Steps = order.DAG.Definitions()
+	}
+	return bundle
+}
@@ -0,0 +1,46 @@
+package types
+
+import "time"
+
+// Order history event types. Step completions use "<COMPONENT>_COMPLETED",
+// e.g. "PAYMENT_COMPLETED", see ComponentCompletedEvent.
+const (
+	EventOrderCreated     = "ORDER_CREATED"
+	EventOrderImported    = "ORDER_IMPORTED"
+	EventComponentReady   = "COMPONENT_READY"
+	EventComponentRetried = "COMPONENT_RETRIED"
+	EventComponentReset   = "COMPONENT_RESET"
+	EventItemRemoved      = "ITEM_REMOVED"
+	EventStepFailed       = "STEP_FAILED"
+	EventOrderCancelled   = "ORDER_CANCELLED"
+	EventOrderCompleted   = "ORDER_COMPLETED"
+	EventOrderFailed      = "ORDER_FAILED"
+
+	EventDeliveryStatusChanged = "DELIVERY_STATUS_CHANGED"
+)
+
+// OrderEvent is one entry in the order's History, recorded by the workflow as
+// it happens. Unlike BuildEventLog, which is derived from the current state, it
+// also keeps what left no trace there, such as failed steps and retries.
+type OrderEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Message string    `json:"message,omitempty"`
+}
+
+// ComponentCompletedEvent is the event type recorded when a step completes
+func ComponentCompletedEvent(componentType ComponentType) string {
+	return string(componentType) + "_COMPLETED"
+}
+
+// RecordEvent appends an event to the order's History. Workflow code must
+// pass workflow.Now so the history is identical when replayed.
+func (po *PizzaOrder) RecordEvent(now time.Time, eventType, message string) {
+	po.History = append(po.History, OrderEvent{Time: now, Type: eventType, Message: message})
+}
+
+// RecordCompletion appends the completion event for a step, with what the
+// step recorded on the order as its message
+func (po *PizzaOrder) RecordCompletion(now time.Time, componentType ComponentType) {
+	po.RecordEvent(now, ComponentCompletedEvent(componentType), stepDetail(po, componentType))
+}
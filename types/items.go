@@ -0,0 +1,113 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Errors returned, wrapped with the item's ID, by DAG.RemoveItem
+var (
+	// ErrItemAlreadyBaked means the item's BAKE step is complete; it's
+	// going out with the order
+	ErrItemAlreadyBaked = errors.New("item is already baked")
+
+	// ErrLastItem means the item is the only one left. With no items the
+	// shared DELIVER would have nothing to wait for; cancel the order instead.
+	ErrLastItem = errors.New("item is the last one in the order")
+)
+
+// OrderItem is one pizza in a multi-item order. Each item is prepared and
+// baked on its own, see ItemSteps, and they all go out in one delivery.
+type OrderItem struct {
+	ID     string  `json:"id"`             // Uppercase letters, digits and dashes; names the item's steps
+	Name   string  `json:"name,omitempty"` // e.g. "Margherita"; defaults to the ID
+	Amount float64 `json:"amount"`         // Price before tax
+}
+
+// itemStepPrefix starts the type of every item step
+const itemStepPrefix = "ITEM_"
+
+// ItemPrepComponent is the step that prepares the item: dough and toppings
+func ItemPrepComponent(itemID string) ComponentType {
+	return ComponentType(itemStepPrefix + itemID + "_PREP")
+}
+
+// ItemBakeComponent is the step that bakes the item
+func ItemBakeComponent(itemID string) ComponentType {
+	return ComponentType(itemStepPrefix + itemID + "_BAKE")
+}
+
+// isItemBake reports whether the component is some item's BAKE step
+func isItemBake(componentType ComponentType) bool {
+	t := string(componentType)
+	return strings.HasPrefix(t, itemStepPrefix) && strings.HasSuffix(t, "_BAKE")
+}
+
+// ItemSteps builds the step graph of a multi-item delivery order: PAYMENT,
+// then a PREP → BAKE pair per item, and one DELIVER that waits for every BAKE
+func ItemSteps(items []OrderItem) []StepDefinition {
+	steps := []StepDefinition{
+		{Type: ComponentPayment, DependsOn: []ComponentType{}, OnCompleteActivity: ActivityProcessPayment, DisplayOrder: 10},
+	}
+	deliver := StepDefinition{
+		Type:               ComponentDeliver,
+		OnCompleteActivity: ActivityScheduleDelivery,
+		DisplayOrder:       20 * (len(items) + 1),
+	}
+	for i, item := range items {
+		prep, bake := ItemPrepComponent(item.ID), ItemBakeComponent(item.ID)
+		steps = append(steps,
+			StepDefinition{Type: prep, DependsOn: []ComponentType{ComponentPayment}, DisplayOrder: 20*(i+1) + 1},
+			StepDefinition{Type: bake, DependsOn: []ComponentType{prep}, DisplayOrder: 20*(i+1) + 2},
+		)
+		deliver.DependsOn = append(deliver.DependsOn, bake)
+	}
+	return append(steps, deliver)
+}
+
+// RemoveItem takes an item's PREP and BAKE steps out of a multi-item order.
+// Their dependents inherit their dependencies, as with RemoveComponent, so
+// the shared DELIVER keeps waiting on the other items' BAKE steps and becomes
+// ready if those are all done. A baked item, or the last one left, can't be
+// removed. On error the DAG is untouched; now timestamps the changes.
+func (d *DAG) RemoveItem(itemID string, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prep, bake := ItemPrepComponent(itemID), ItemBakeComponent(itemID)
+	baked, err := d.component(bake)
+	if err != nil {
+		return err
+	}
+	if _, err := d.component(prep); err != nil {
+		return err
+	}
+	if baked.State == StateCompleted {
+		return fmt.Errorf("%w: %s", ErrItemAlreadyBaked, itemID)
+	}
+	others := 0
+	for _, c := range d.components {
+		if isItemBake(c.Type) && c.Type != bake {
+			others++
+		}
+	}
+	if others == 0 {
+		return fmt.Errorf("%w: %s", ErrLastItem, itemID)
+	}
+
+	// Work on a copy, so a removal that fails part way leaves d as it was
+	next := d.clone()
+	for _, componentType := range []ComponentType{bake, prep} {
+		if err := next.removeComponent(componentType); err != nil {
+			return err
+		}
+	}
+	if err := next.validateDependencies(); err != nil {
+		return err
+	}
+	d.components = next.components
+	d.repair(now)
+	return nil
+}
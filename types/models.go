@@ -1,6 +1,9 @@
 package types
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 // ComponentType represents different steps in pizza order
 type ComponentType string
@@ -11,24 +14,106 @@ const (
 	ComponentAddToppings ComponentType = "ADD_TOPPINGS"
 	ComponentBakePizza   ComponentType = "BAKE_PIZZA"
 	ComponentDeliver     ComponentType = "DELIVER"
+	ComponentPhotoProof  ComponentType = "PHOTO_PROOF"
+	ComponentPickupReady ComponentType = "PICKUP_READY" // Replaces DELIVER for pickup orders
+
+	// Gourmet-only prep steps
+	ComponentProofDough ComponentType = "PROOF_DOUGH"
+	ComponentRestDough  ComponentType = "REST_DOUGH" // Timer step, completed by the workflow
+
+	// Parallel prep steps: sauce and cheese go on side by side before toppings
+	ComponentAddSauce  ComponentType = "ADD_SAUCE"
+	ComponentAddCheese ComponentType = "ADD_CHEESE"
+
+	// Compliance gate added to orders that contain alcohol, see AddAgeVerification
+	ComponentAgeVerification ComponentType = "AGE_VERIFICATION"
 )
 
 // ComponentState tracks progress of each component
 type ComponentState string
 
 const (
-	StateNeedsInit  ComponentState = "NEEDS_INIT"  // Not ready to start yet (dependencies not met)
-	StateIncomplete ComponentState = "INCOMPLETE"  // Ready to work on, but not done
-	StateCompleted  ComponentState = "COMPLETED"   // Done!
+	StateNeedsInit  ComponentState = "NEEDS_INIT" // Not ready to start yet (dependencies not met)
+	StateIncomplete ComponentState = "INCOMPLETE" // Ready to work on, but not done
+	StateCompleted  ComponentState = "COMPLETED"  // Done!
 )
 
 // Component represents a single step in the pizza order
 type Component struct {
-	Type         ComponentType   `json:"type"`
-	State        ComponentState  `json:"state"`
-	DependsOn    []ComponentType `json:"dependsOn"`    // Which steps must complete first
-	UpdateTime   time.Time       `json:"updateTime"`
-	CompleteTime *time.Time      `json:"completeTime"` // nil if not completed
+	Type         ComponentType     `json:"type"`
+	State        ComponentState    `json:"state"`
+	DependsOn    []ComponentType   `json:"dependsOn"`       // Which steps must complete first (all of them)
+	AnyOf        [][]ComponentType `json:"anyOf,omitempty"` // Each group needs at least one completed step
+	UpdateTime   time.Time         `json:"updateTime"`
+	ReadyTime    *time.Time        `json:"readyTime"`    // When the step became INCOMPLETE, nil until then
+	CompleteTime *time.Time        `json:"completeTime"` // nil if not completed
+	RetryCount   int               `json:"retryCount"`   // Explicit retries requested by an operator
+	Optional     bool              `json:"optional"`     // Optional steps don't block order completion
+
+	// OnCompleteActivity names an activity the workflow runs before completing
+	// the step (e.g. "ProcessPayment"); empty means the step has none
+	OnCompleteActivity string `json:"onCompleteActivity,omitempty"`
+
+	// DisplayOrder positions the step for frontends, independent of its
+	// dependencies; see DAG.ComponentsByDisplayOrder
+	DisplayOrder int `json:"displayOrder,omitempty"`
+
+	// ActivityTimeout bounds each attempt of OnCompleteActivity; zero uses the
+	// workflow's default
+	ActivityTimeout time.Duration `json:"activityTimeout,omitempty"`
+
+	// EstimatedDuration is how long the step usually takes, for ETAs; see
+	// DAG.CriticalPath and DefaultEstimatedDurations
+	EstimatedDuration time.Duration `json:"estimatedDuration,omitempty"`
+
+	// Parameters the step was completed with, e.g. BAKE's "temperature"; see
+	// DAG.SetParameter
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// StepProgress reports how far a multi-stage step, run as a child workflow,
+// has got. Stage is empty once every stage is done.
+type StepProgress struct {
+	Component       ComponentType `json:"component"`
+	ChildWorkflowID string        `json:"child_workflow_id"`
+	Stage           string        `json:"stage,omitempty"`
+	StagesDone      int           `json:"stages_done"`
+	StagesTotal     int           `json:"stages_total"`
+}
+
+// Summary webhook delivery statuses
+const (
+	WebhookPending   = "PENDING"   // Being delivered, possibly retrying
+	WebhookDelivered = "DELIVERED" // Acknowledged by the receiver
+	WebhookFailed    = "FAILED"    // Gave up after the delivery deadline
+	WebhookDisabled  = "DISABLED"  // No webhook URL configured
+)
+
+// WebhookDelivery tracks the order summary webhook
+type WebhookDelivery struct {
+	Status      string     `json:"status"`
+	Attempts    int32      `json:"attempts,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// StepDuration is the actual service time of a completed component:
+// from becoming ready (INCOMPLETE) to being completed
+type StepDuration struct {
+	Component       ComponentType `json:"component"`
+	ReadyTime       time.Time     `json:"ready_time"`
+	CompleteTime    time.Time     `json:"complete_time"`
+	DurationSeconds float64       `json:"duration_seconds"`
+}
+
+// dependencies returns every component this one can depend on,
+// including all AnyOf alternatives
+func (c *Component) dependencies() []ComponentType {
+	deps := append([]ComponentType{}, c.DependsOn...)
+	for _, group := range c.AnyOf {
+		deps = append(deps, group...)
+	}
+	return deps
 }
 
 // OrderState represents the overall state of a pizza order
@@ -37,45 +122,257 @@ type OrderState string
 const (
 	OrderStateInProgress OrderState = "IN_PROGRESS"
 	OrderStateCompleted  OrderState = "COMPLETED"
+	OrderStateCancelled  OrderState = "CANCELLED" // Stopped by the customer before fulfilment
+	OrderStateFailed     OrderState = "FAILED"    // Couldn't be fulfilled; its compensations ran
+)
+
+// Prep complexity levels - GOURMET adds dough proofing and resting steps
+const (
+	ComplexitySimple  = "SIMPLE"
+	ComplexityGourmet = "GOURMET"
+)
+
+// IsValidComplexity checks a complexity level (empty means SIMPLE)
+func IsValidComplexity(complexity string) bool {
+	switch complexity {
+	case "", ComplexitySimple, ComplexityGourmet:
+		return true
+	}
+	return false
+}
+
+// Fulfillment modes - PICKUP orders are collected by the customer, not delivered
+const (
+	FulfillmentDelivery = "DELIVERY"
+	FulfillmentPickup   = "PICKUP"
+)
+
+// IsValidFulfillment checks a fulfillment mode (empty means DELIVERY)
+func IsValidFulfillment(fulfillment string) bool {
+	switch fulfillment {
+	case "", FulfillmentDelivery, FulfillmentPickup:
+		return true
+	}
+	return false
+}
+
+// Notification channels a customer can choose
+const (
+	NotificationSMS   = "SMS"
+	NotificationEmail = "EMAIL"
+	NotificationPush  = "PUSH"
+	NotificationNone  = "NONE" // Opt out of all notifications
 )
 
+// NotificationPrefs controls how the customer is notified about their order
+type NotificationPrefs struct {
+	Channel string `json:"channel,omitempty"` // Empty uses each message's default channel
+}
+
+// IsValidNotificationChannel checks a channel name (empty means "use defaults")
+func IsValidNotificationChannel(channel string) bool {
+	switch channel {
+	case "", NotificationSMS, NotificationEmail, NotificationPush, NotificationNone:
+		return true
+	}
+	return false
+}
+
+// Overall statuses combine the order lifecycle with the delivery lifecycle.
+// A completed workflow only means delivery was scheduled, not that it arrived.
+const (
+	OverallStatusOutForDelivery = "OUT_FOR_DELIVERY"
+	OverallStatusDelivered      = "DELIVERED"
+	OverallStatusReadyForPickup = "READY_FOR_PICKUP"
+)
+
+// DeliveryStatusDelivered is the delivery service status for an arrived order
+const DeliveryStatusDelivered = "DELIVERED"
+
+// DeliveryStatusFailed marks a delivery group that couldn't be scheduled
+const DeliveryStatusFailed = "FAILED"
+
+// DeliveryGroup is one part of a split delivery (e.g. a large catering order)
+type DeliveryGroup struct {
+	Name            string `json:"name"`
+	DeliveryAddress string `json:"delivery_address,omitempty"` // Defaults to the order's address
+}
+
+// Payment methods a split can use
+const (
+	PaymentMethodCard     = "CARD"
+	PaymentMethodGiftCard = "GIFT_CARD"
+)
+
+// IsValidPaymentMethod checks a payment method name
+func IsValidPaymentMethod(method string) bool {
+	return method == PaymentMethodCard || method == PaymentMethodGiftCard
+}
+
+// PaymentSplit is one part of a payment spread across methods
+// (e.g. part gift card, part card)
+type PaymentSplit struct {
+	Method        string  `json:"method"`
+	Amount        float64 `json:"amount"`
+	TransactionID string  `json:"transaction_id,omitempty"` // Set once charged
+}
+
+// DeliveryResult is the outcome of scheduling one delivery group
+type DeliveryResult struct {
+	Group            string     `json:"group"`
+	DeliveryAddress  string     `json:"delivery_address"`
+	Zone             string     `json:"zone"`
+	DeliveryID       string     `json:"delivery_id,omitempty"`
+	DriverName       string     `json:"driver_name,omitempty"`
+	TrackingURL      string     `json:"tracking_url,omitempty"`
+	Status           string     `json:"status"`
+	EstimatedArrival *time.Time `json:"estimated_arrival,omitempty"`
+	Error            string     `json:"error,omitempty"`  // Why scheduling failed, if it did
+	EscalationLevel  int        `json:"escalation_level"` // How far the driver pool was widened
+}
+
+// Scheduled reports whether a driver was assigned to the group
+func (d *DeliveryResult) Scheduled() bool {
+	return d.DeliveryID != ""
+}
+
 // PizzaOrder is the complete workflow state
 type PizzaOrder struct {
-	OrderID         string       `json:"order_id"`
-	CustomerName    string       `json:"customer_name"`
-	CustomerEmail   string       `json:"customer_email,omitempty"`
-	CustomerPhone   string       `json:"customer_phone,omitempty"`
-	DeliveryAddress string       `json:"delivery_address,omitempty"`
-	State           OrderState   `json:"state"`
-	DAG             *DAG         `json:"components"` // The component graph
-	CreateTime      time.Time    `json:"create_time"`
-	UpdateTime      time.Time    `json:"update_time"`
+	OrderID         string     `json:"order_id"`
+	CustomerName    string     `json:"customer_name"`
+	CustomerEmail   string     `json:"customer_email,omitempty"`
+	CustomerPhone   string     `json:"customer_phone,omitempty"`
+	DeliveryAddress string     `json:"delivery_address,omitempty"`
+	State           OrderState `json:"state"`
+	DAG             *DAG       `json:"components"` // The component graph
+	CreateTime      time.Time  `json:"create_time"`
+	UpdateTime      time.Time  `json:"update_time"`
+	Complexity      string     `json:"complexity"`
+	Fulfillment     string     `json:"fulfillment"` // DELIVERY or PICKUP
+	Template        string     `json:"template"`    // DAG template the components were built from
+
+	NotificationPrefs NotificationPrefs `json:"notification_prefs"`
+
+	// RunID is the workflow run holding this state; it changes when the
+	// workflow is reset or continued as new
+	RunID string `json:"run_id,omitempty"`
+
+	// ContainsAlcohol orders carry an AGE_VERIFICATION step before the handoff
+	ContainsAlcohol bool `json:"contains_alcohol,omitempty"`
+
+	// Remakes are new orders that redo a completed one at no charge
+	RemakeOf     string `json:"remake_of,omitempty"` // Original order ID
+	RemakeReason string `json:"remake_reason,omitempty"`
+
+	// Step data supplied by the caller
+	DoughType string   `json:"dough_type,omitempty"`
+	Toppings  []string `json:"toppings,omitempty"`
+
+	// Items of a multi-item order, see ItemSteps; Subtotal is their sum
+	Items []OrderItem `json:"items,omitempty"`
+
+	// Pricing, computed when the order starts
+	Subtotal  float64 `json:"subtotal"`
+	TaxRate   float64 `json:"tax_rate"`
+	TaxAmount float64 `json:"tax_amount"`
+	Total     float64 `json:"total"` // What PAYMENT charges
+
+	// Delivery fee by distance from the shop; zero for pickup and free orders
+	DeliveryFee float64 `json:"delivery_fee"`
+	DistanceKm  float64 `json:"distance_km,omitempty"`
+
+	// PaymentSplits spread the charge across methods; empty charges Total in one go
+	PaymentSplits []PaymentSplit `json:"payment_splits,omitempty"`
 
 	// Activity results
-	PaymentTxnID    string     `json:"payment_txn_id,omitempty"`
-	PaymentAmount   float64    `json:"payment_amount,omitempty"`
-	DeliveryID      string     `json:"delivery_id,omitempty"`
-	DriverName      string     `json:"driver_name,omitempty"`
-	TrackingURL     string     `json:"tracking_url,omitempty"`
+	PaymentTxnID     string     `json:"payment_txn_id,omitempty"`
+	PaymentAmount    float64    `json:"payment_amount,omitempty"`
+	CompReason       string     `json:"comp_reason,omitempty"` // Set for free orders that skipped payment
+	DeliveryZone     string     `json:"delivery_zone,omitempty"`
+	DeliveryID       string     `json:"delivery_id,omitempty"`
+	DriverName       string     `json:"driver_name,omitempty"`
+	TrackingURL      string     `json:"tracking_url,omitempty"`
+	DeliveryStatus   string     `json:"delivery_status,omitempty"` // Latest status reported by the delivery service
 	EstimatedArrival *time.Time `json:"estimated_arrival,omitempty"`
+	ProofPhotoURL    string     `json:"proof_photo_url,omitempty"`
+	ManualDispatch   bool       `json:"manual_dispatch,omitempty"` // Driver assigned by a dispatcher, not the delivery service
+
+	// BakeProgress is reported by the gourmet bake child workflow
+	BakeProgress *StepProgress `json:"bake_progress,omitempty"`
+
+	// DeliveryEscalation is the widest driver pool any delivery needed
+	DeliveryEscalation int `json:"delivery_escalation"`
+
+	// DeliveryRetries counts automatic DELIVER retries after a failure
+	DeliveryRetries int `json:"delivery_retries,omitempty"`
+
+	// Optional delivery window requested by the customer; the ETA falls inside it
+	DeliveryWindowStart *time.Time `json:"delivery_window_start,omitempty"`
+	DeliveryWindowEnd   *time.Time `json:"delivery_window_end,omitempty"`
+
+	// Deliveries has one entry per delivery group; the fields above mirror the first
+	Deliveries []DeliveryResult `json:"deliveries,omitempty"`
+
+	// SLA set by a dispatcher: the order should complete by SLADeadline
+	SLADeadline *time.Time `json:"sla_deadline,omitempty"`
+	SLABreached bool       `json:"sla_breached,omitempty"`
+
+	// ExpiresAt is when the order is cancelled if it still isn't done, set
+	// from PizzaOrderInput.OrderTimeout
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Cancellation, see OrderStateCancelled; RefundAmount is what was refunded
+	CancelReason string  `json:"cancel_reason,omitempty"`
+	RefundAmount float64 `json:"refund_amount,omitempty"`
+
+	// FailureReason says why the workflow gave up, see OrderStateFailed
+	FailureReason string `json:"failure_reason,omitempty"`
+
+	// LoyaltyPoints awarded when the order completed
+	LoyaltyPoints int `json:"loyalty_points"`
+
+	// SummaryWebhook tracks the "order finished" webhook, once the order is terminal
+	SummaryWebhook *WebhookDelivery `json:"summary_webhook,omitempty"`
+
+	// Replayed marks a step update's result when the step had already
+	// completed, so the request ran nothing. The order itself never has it set.
+	Replayed bool `json:"replayed,omitempty"`
+
+	// Warnings are non-fatal anomalies worth showing to operators
+	Warnings []string `json:"warnings,omitempty"`
+
+	// History is the order's audit log, oldest first, see OrderEvent
+	History []OrderEvent `json:"history,omitempty"`
 }
 
-// Clone creates a deep copy of the order
+// Clone creates a deep copy of the order. Value fields are copied wholesale so
+// new ones can't be forgotten; only slice, pointer and DAG fields need a line below.
 func (po *PizzaOrder) Clone() *PizzaOrder {
-	clone := &PizzaOrder{
-		OrderID:         po.OrderID,
-		CustomerName:    po.CustomerName,
-		CustomerEmail:   po.CustomerEmail,
-		CustomerPhone:   po.CustomerPhone,
-		DeliveryAddress: po.DeliveryAddress,
-		State:           po.State,
-		CreateTime:      po.CreateTime,
-		UpdateTime:      po.UpdateTime,
-		PaymentTxnID:    po.PaymentTxnID,
-		PaymentAmount:   po.PaymentAmount,
-		DeliveryID:      po.DeliveryID,
-		DriverName:      po.DriverName,
-		TrackingURL:     po.TrackingURL,
+	clone := *po
+
+	if po.Toppings != nil {
+		clone.Toppings = make([]string, len(po.Toppings))
+		copy(clone.Toppings, po.Toppings)
+	}
+
+	if po.Items != nil {
+		clone.Items = make([]OrderItem, len(po.Items))
+		copy(clone.Items, po.Items)
+	}
+
+	if po.PaymentSplits != nil {
+		clone.PaymentSplits = make([]PaymentSplit, len(po.PaymentSplits))
+		copy(clone.PaymentSplits, po.PaymentSplits)
+	}
+
+	if po.Warnings != nil {
+		clone.Warnings = make([]string, len(po.Warnings))
+		copy(clone.Warnings, po.Warnings)
+	}
+
+	if po.History != nil {
+		clone.History = make([]OrderEvent, len(po.History))
+		copy(clone.History, po.History)
 	}
 
 	if po.EstimatedArrival != nil {
@@ -83,11 +380,101 @@ func (po *PizzaOrder) Clone() *PizzaOrder {
 		clone.EstimatedArrival = &t
 	}
 
+	if po.DeliveryWindowStart != nil {
+		t := *po.DeliveryWindowStart
+		clone.DeliveryWindowStart = &t
+	}
+
+	if po.DeliveryWindowEnd != nil {
+		t := *po.DeliveryWindowEnd
+		clone.DeliveryWindowEnd = &t
+	}
+
+	if po.BakeProgress != nil {
+		progress := *po.BakeProgress
+		clone.BakeProgress = &progress
+	}
+
+	if po.SLADeadline != nil {
+		t := *po.SLADeadline
+		clone.SLADeadline = &t
+	}
+
+	if po.ExpiresAt != nil {
+		t := *po.ExpiresAt
+		clone.ExpiresAt = &t
+	}
+
+	if po.SummaryWebhook != nil {
+		webhook := *po.SummaryWebhook
+		if webhook.DeliveredAt != nil {
+			t := *webhook.DeliveredAt
+			webhook.DeliveredAt = &t
+		}
+		clone.SummaryWebhook = &webhook
+	}
+
+	if po.Deliveries != nil {
+		clone.Deliveries = make([]DeliveryResult, len(po.Deliveries))
+		copy(clone.Deliveries, po.Deliveries)
+		for i := range clone.Deliveries {
+			if eta := clone.Deliveries[i].EstimatedArrival; eta != nil {
+				t := *eta
+				clone.Deliveries[i].EstimatedArrival = &t
+			}
+		}
+	}
+
 	if po.DAG != nil {
 		clone.DAG = po.DAG.Clone()
 	}
 
-	return clone
+	return &clone
+}
+
+// Redacted returns a copy of the order with customer contact details masked,
+// safe for public status pages and shared tracking links. The DAG and driver
+// details come through unchanged.
+func (po *PizzaOrder) Redacted() *PizzaOrder {
+	redacted := po.Clone()
+	redacted.CustomerEmail = maskEmail(po.CustomerEmail)
+	redacted.CustomerPhone = maskPhone(po.CustomerPhone)
+	redacted.DeliveryAddress = maskAll(po.DeliveryAddress)
+	for i := range redacted.Deliveries {
+		redacted.Deliveries[i].DeliveryAddress = maskAll(redacted.Deliveries[i].DeliveryAddress)
+	}
+	return redacted
+}
+
+// maskEmail keeps the first letter and the domain: a***@example.com
+func maskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return maskAll(email)
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// maskPhone keeps the last four digits: ***-1234
+func maskPhone(phone string) string {
+	digits := make([]rune, 0, len(phone))
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) <= 4 {
+		return maskAll(phone)
+	}
+	return "***-" + string(digits[len(digits)-4:])
+}
+
+// maskAll hides a value entirely, leaving empty values empty
+func maskAll(value string) string {
+	if value == "" {
+		return ""
+	}
+	return "[redacted]"
 }
 
 // IsDone checks if all components are completed
@@ -97,3 +484,84 @@ func (po *PizzaOrder) IsDone() bool {
 	}
 	return po.DAG.AllComponentsCompleted()
 }
+
+// OverallStatus derives a customer-facing status from both lifecycles:
+//
+//	delivery reported DELIVERED        -> DELIVERED
+//	DELIVER step done (driver assigned) -> OUT_FOR_DELIVERY
+//	otherwise                           -> the order state (e.g. IN_PROGRESS)
+//
+// A cancelled or failed order is CANCELLED or FAILED whatever the delivery reported.
+func (po *PizzaOrder) OverallStatus() string {
+	if po.State == OrderStateCancelled || po.State == OrderStateFailed {
+		return string(po.State)
+	}
+	if po.DeliveryStatus == DeliveryStatusDelivered {
+		return OverallStatusDelivered
+	}
+	if po.DAG != nil {
+		if deliver, err := po.DAG.GetComponent(ComponentDeliver); err == nil && deliver.State == StateCompleted {
+			return OverallStatusOutForDelivery
+		}
+		if pickup, err := po.DAG.GetComponent(ComponentPickupReady); err == nil && pickup.State == StateCompleted {
+			return OverallStatusReadyForPickup
+		}
+	}
+	return string(po.State)
+}
+
+// HasDeliveryWindow reports whether the customer asked for a delivery window
+func (po *PizzaOrder) HasDeliveryWindow() bool {
+	return po.DeliveryWindowStart != nil && po.DeliveryWindowEnd != nil
+}
+
+// PriceBreakdown itemizes the order's pricing. Tips and discounts aren't
+// supported yet, so they are always zero.
+func (po *PizzaOrder) PriceBreakdown() PriceBreakdown {
+	return PriceBreakdown{
+		Subtotal:    po.Subtotal,
+		DeliveryFee: po.DeliveryFee,
+		TaxRate:     po.TaxRate,
+		Tax:         po.TaxAmount,
+		Total:       po.Total,
+	}
+}
+
+// OrderSummary is a lightweight view of an order for list views
+type OrderSummary struct {
+	OrderID         string        `json:"order_id"`
+	CustomerName    string        `json:"customer_name"`
+	State           OrderState    `json:"state"`
+	NextStep        ComponentType `json:"next_step,omitempty"` // Empty when nothing is ready
+	ProgressPercent int           `json:"progress_percent"`
+}
+
+// Summary derives the lightweight summary from the full order state
+func (po *PizzaOrder) Summary() *OrderSummary {
+	summary := &OrderSummary{
+		OrderID:      po.OrderID,
+		CustomerName: po.CustomerName,
+		State:        po.State,
+	}
+
+	if po.DAG == nil {
+		return summary
+	}
+
+	if next := po.DAG.GetNextComponent(); next != nil {
+		summary.NextStep = next.Type
+	}
+
+	components := po.DAG.GetComponents()
+	completed := 0
+	for _, c := range components {
+		if c.State == StateCompleted {
+			completed++
+		}
+	}
+	if len(components) > 0 {
+		summary.ProgressPercent = completed * 100 / len(components)
+	}
+
+	return summary
+}
@@ -0,0 +1,157 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cloneSkipped lists fields Clone may share with the original, by path from
+// PizzaOrder. Anything else that can alias memory must be deep-copied.
+var cloneSkipped = map[string]string{
+	"DAG.mu": "a fresh mutex is built with the cloned DAG; it holds no memory",
+}
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	dagPtrType = reflect.TypeOf(&DAG{})
+)
+
+// fillValue sets every settable field reachable from v to a non-zero value,
+// giving slices and maps two elements and pointers a fresh target
+func fillValue(t *testing.T, path string, v reflect.Value) {
+	t.Helper()
+	switch {
+	case v.Type() == timeType:
+		v.Set(reflect.ValueOf(testTime))
+		return
+	case v.Type() == dagPtrType:
+		dag := NewPizzaOrderDAG(testTime)
+		if err := dag.SetParameter(ComponentPayment, "method", "CARD"); err != nil {
+			t.Fatal(err)
+		}
+		completeAll(t, dag, ComponentPayment)
+		v.Set(reflect.ValueOf(dag))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.Pointer:
+		target := reflect.New(v.Type().Elem())
+		fillValue(t, path, target.Elem())
+		v.Set(target)
+	case reflect.Slice:
+		s := reflect.MakeSlice(v.Type(), 2, 2)
+		for i := 0; i < s.Len(); i++ {
+			fillValue(t, path+"[]", s.Index(i))
+		}
+		v.Set(s)
+	case reflect.Map:
+		m := reflect.MakeMap(v.Type())
+		key := reflect.New(v.Type().Key()).Elem()
+		fillValue(t, path+"[key]", key)
+		value := reflect.New(v.Type().Elem()).Elem()
+		fillValue(t, path+"[]", value)
+		m.SetMapIndex(key, value)
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				fillValue(t, joinPath(path, v.Type().Field(i).Name), v.Field(i))
+			}
+		}
+	default:
+		t.Fatalf("%s: don't know how to fill a %s; teach fillValue and checkNoSharing", path, v.Kind())
+	}
+}
+
+// checkNoSharing walks original and clone side by side and reports every
+// pointer, slice or map they share
+func checkNoSharing(t *testing.T, path string, original, clone reflect.Value) {
+	t.Helper()
+	if _, skipped := cloneSkipped[path]; skipped || original.Type() == timeType {
+		return // time.Time's *Location is shared by design
+	}
+
+	switch original.Kind() {
+	case reflect.Pointer:
+		if original.IsNil() {
+			return
+		}
+		if original.Pointer() == clone.Pointer() {
+			t.Errorf("%s: clone shares the pointer", path)
+			return
+		}
+		checkNoSharing(t, path, original.Elem(), clone.Elem())
+	case reflect.Slice:
+		if original.Len() > 0 && original.Pointer() == clone.Pointer() {
+			t.Errorf("%s: clone shares the slice's backing array", path)
+			return
+		}
+		for i := 0; i < original.Len(); i++ {
+			checkNoSharing(t, path+"[]", original.Index(i), clone.Index(i))
+		}
+	case reflect.Map:
+		if original.IsNil() {
+			return
+		}
+		if original.Pointer() == clone.Pointer() {
+			t.Errorf("%s: clone shares the map", path)
+			return
+		}
+		for _, key := range original.MapKeys() {
+			checkNoSharing(t, path+"[]", original.MapIndex(key), clone.MapIndex(key))
+		}
+	case reflect.Struct:
+		for i := 0; i < original.NumField(); i++ {
+			name := joinPath(path, original.Type().Field(i).Name)
+			checkNoSharing(t, name, original.Field(i), clone.Field(i))
+		}
+	case reflect.Interface, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		t.Errorf("%s: %s fields can't be checked for sharing; list it in cloneSkipped if that is intended", path, original.Kind())
+	}
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func TestPizzaOrderCloneSharesNoMemory(t *testing.T) {
+	var order PizzaOrder
+	fillValue(t, "", reflect.ValueOf(&order).Elem())
+
+	// Every field must be filled, or a new one could slip past the walk
+	v := reflect.ValueOf(order)
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).IsZero() {
+			t.Fatalf("%s was left zero by fillValue", v.Type().Field(i).Name)
+		}
+	}
+
+	clone := order.Clone()
+	want, err := json.Marshal(&order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(clone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("clone differs from the original:\n got %s\nwant %s", got, want)
+	}
+	checkNoSharing(t, "", reflect.ValueOf(&order).Elem(), reflect.ValueOf(clone).Elem())
+}
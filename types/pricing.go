@@ -0,0 +1,33 @@
+package types
+
+import "math"
+
+// PriceBreakdown itemizes what an order costs, for receipts
+type PriceBreakdown struct {
+	Subtotal    float64 `json:"subtotal"`
+	DeliveryFee float64 `json:"delivery_fee"`
+	Tip         float64 `json:"tip"`
+	Discount    float64 `json:"discount"`
+	TaxRate     float64 `json:"tax_rate"`
+	Tax         float64 `json:"tax"`
+	Total       float64 `json:"total"`
+}
+
+// ComputeTotal prices an order. Tax applies to the discounted subtotal; delivery
+// fees and tips aren't taxed. Both results are rounded to cents.
+func ComputeTotal(subtotal, deliveryFee, tip, discount, taxRate float64) (total, tax float64) {
+	taxable := math.Max(subtotal-discount, 0)
+	tax = roundCents(taxable * taxRate)
+	total = roundCents(taxable + tax + deliveryFee + tip)
+	return total, tax
+}
+
+// IsValidTaxRate checks a tax rate is a fraction in [0, 1]
+func IsValidTaxRate(rate float64) bool {
+	return rate >= 0 && rate <= 1
+}
+
+// roundCents rounds an amount to two decimal places
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
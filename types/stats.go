@@ -0,0 +1,59 @@
+package types
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// ThroughputStats summarizes the orders completed in a time window.
+// Durations are end to end, from order creation to completion.
+type ThroughputStats struct {
+	WindowStart        time.Time `json:"window_start"`
+	WindowEnd          time.Time `json:"window_end"`
+	Completed          int       `json:"completed"`
+	AvgDurationSeconds float64   `json:"avg_duration_seconds"`
+	P50DurationSeconds float64   `json:"p50_duration_seconds"`
+	P95DurationSeconds float64   `json:"p95_duration_seconds"`
+}
+
+// Throughput aggregates the completed orders that finished in [start, end).
+// A completed order's UpdateTime is its completion time.
+func Throughput(orders []*PizzaOrder, start, end time.Time) ThroughputStats {
+	stats := ThroughputStats{WindowStart: start, WindowEnd: end}
+
+	var durations []float64
+	for _, order := range orders {
+		if order == nil || order.State != OrderStateCompleted {
+			continue
+		}
+		if order.UpdateTime.Before(start) || !order.UpdateTime.Before(end) {
+			continue
+		}
+		durations = append(durations, order.UpdateTime.Sub(order.CreateTime).Seconds())
+	}
+
+	stats.Completed = len(durations)
+	if len(durations) == 0 {
+		return stats
+	}
+
+	sort.Float64s(durations)
+	total := 0.0
+	for _, d := range durations {
+		total += d
+	}
+	stats.AvgDurationSeconds = total / float64(len(durations))
+	stats.P50DurationSeconds = percentile(durations, 50)
+	stats.P95DurationSeconds = percentile(durations, 95)
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted, non-empty values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
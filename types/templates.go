@@ -0,0 +1,191 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+// Built-in DAG template names
+const (
+	TemplateStandard      = "standard"
+	TemplateGourmet       = "gourmet"
+	TemplatePickup        = "pickup"
+	TemplateGourmetPickup = "gourmet-pickup"
+
+	TemplateSauceAndCheese       = "sauce-and-cheese"
+	TemplateSauceAndCheesePickup = "sauce-and-cheese-pickup"
+
+	// TemplateCustom marks orders built from caller-supplied steps, see NewDAGFromDefinitions
+	TemplateCustom = "custom"
+)
+
+// Activities the built-in templates attach to steps, see Component.OnCompleteActivity
+const (
+	ActivityProcessPayment   = "ProcessPayment"
+	ActivityScheduleDelivery = "ScheduleDelivery"
+)
+
+// StepDefinition describes a component and its edges, without any runtime state
+type StepDefinition struct {
+	Type      ComponentType     `json:"type"`
+	DependsOn []ComponentType   `json:"dependsOn"`
+	AnyOf     [][]ComponentType `json:"anyOf,omitempty"`
+	Optional  bool              `json:"optional,omitempty"`
+
+	OnCompleteActivity string        `json:"onCompleteActivity,omitempty"`
+	ActivityTimeout    time.Duration `json:"activityTimeout,omitempty"`
+	DisplayOrder       int           `json:"displayOrder,omitempty"`
+	EstimatedDuration  time.Duration `json:"estimatedDuration,omitempty"` // Zero uses DefaultEstimatedDurations
+}
+
+// DAGTemplate is a named constructor for an order's component graph
+type DAGTemplate struct {
+	Name        string
+	Description string
+	Build       func(now time.Time) *DAG // now timestamps the new components
+}
+
+// TemplateRegistry maps template names to DAG constructors
+type TemplateRegistry struct {
+	templates map[string]DAGTemplate
+	names     []string // Registration order, for stable listings
+}
+
+// NewTemplateRegistry creates an empty registry
+func NewTemplateRegistry() *TemplateRegistry {
+	return &TemplateRegistry{templates: make(map[string]DAGTemplate)}
+}
+
+// Register adds (or replaces) a template
+func (r *TemplateRegistry) Register(name, description string, build func(now time.Time) *DAG) {
+	if _, exists := r.templates[name]; !exists {
+		r.names = append(r.names, name)
+	}
+	r.templates[name] = DAGTemplate{Name: name, Description: description, Build: build}
+}
+
+// Has checks if a template is registered
+func (r *TemplateRegistry) Has(name string) bool {
+	_, ok := r.templates[name]
+	return ok
+}
+
+// Build creates a fresh DAG from the named template, timestamped with now
+func (r *TemplateRegistry) Build(name string, now time.Time) (*DAG, error) {
+	template, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown DAG template %q", name)
+	}
+	return template.Build(now), nil
+}
+
+// Templates returns every registered template in registration order
+func (r *TemplateRegistry) Templates() []DAGTemplate {
+	templates := make([]DAGTemplate, 0, len(r.names))
+	for _, name := range r.names {
+		templates = append(templates, r.templates[name])
+	}
+	return templates
+}
+
+// DefaultTemplates holds the built-in pizza order graphs
+var DefaultTemplates = NewTemplateRegistry()
+
+func init() {
+	DefaultTemplates.Register(TemplateStandard, "Payment, dough, toppings, bake and deliver", NewPizzaOrderDAG)
+	DefaultTemplates.Register(TemplateGourmet, "Standard steps plus dough proofing and resting", NewGourmetPizzaOrderDAG)
+	DefaultTemplates.Register(TemplatePickup, "Standard steps, collected by the customer", forPickup(NewPizzaOrderDAG))
+	DefaultTemplates.Register(TemplateGourmetPickup, "Gourmet steps, collected by the customer", forPickup(NewGourmetPizzaOrderDAG))
+	DefaultTemplates.Register(TemplateSauceAndCheese, "Standard steps with sauce and cheese added in parallel before toppings", NewSauceAndCheeseDAG)
+	DefaultTemplates.Register(TemplateSauceAndCheesePickup, "Sauce and cheese steps, collected by the customer", forPickup(NewSauceAndCheeseDAG))
+}
+
+// forPickup turns a delivery template into its pickup variant: DELIVER becomes
+// PICKUP_READY and steps that only make sense after a delivery are dropped
+func forPickup(build func(now time.Time) *DAG) func(now time.Time) *DAG {
+	return func(now time.Time) *DAG {
+		dag := build(now)
+		components := make([]*Component, 0, len(dag.components))
+		for _, c := range dag.components {
+			if c.Type == ComponentPhotoProof {
+				continue
+			}
+			if c.Type == ComponentDeliver {
+				c.Type = ComponentPickupReady
+				c.OnCompleteActivity = "" // Nothing to schedule
+				c.EstimatedDuration = DefaultEstimatedDurations[ComponentPickupReady]
+			}
+			components = append(components, c)
+		}
+
+		pickup, _ := NewDAG(components) // We know this won't error
+		return pickup
+	}
+}
+
+// NewDAGFromDefinitions builds a DAG from step definitions, e.g. a custom
+// graph sent with an order. Every step starts NEEDS_INIT and the ones without
+// dependencies become ready, all timestamped with now. The graph is validated
+// like NewDAG: duplicate types, unknown dependencies and cycles are rejected.
+func NewDAGFromDefinitions(steps []StepDefinition, now time.Time) (*DAG, error) {
+	components := make([]*Component, 0, len(steps))
+	for _, step := range steps {
+		if step.Type == "" {
+			return nil, fmt.Errorf("every step needs a type")
+		}
+
+		// Edges are copied, so changing the graph leaves the steps as given
+		var anyOf [][]ComponentType
+		for _, group := range step.AnyOf {
+			anyOf = append(anyOf, append([]ComponentType{}, group...))
+		}
+		components = append(components, &Component{
+			Type:       step.Type,
+			State:      StateNeedsInit,
+			DependsOn:  append([]ComponentType{}, step.DependsOn...),
+			AnyOf:      anyOf,
+			Optional:   step.Optional,
+			UpdateTime: now,
+
+			OnCompleteActivity: step.OnCompleteActivity,
+			ActivityTimeout:    step.ActivityTimeout,
+			DisplayOrder:       step.DisplayOrder,
+			EstimatedDuration:  step.EstimatedDuration,
+		})
+	}
+
+	dag, err := NewDAG(components)
+	if err != nil {
+		return nil, err
+	}
+	dag.repair(now) // Not shared yet, so mu isn't needed
+	return dag, nil
+}
+
+// SupportsPickup reports whether the DAG ends in a pickup instead of a delivery
+func (d *DAG) SupportsPickup() bool {
+	_, err := d.GetComponent(ComponentPickupReady)
+	return err == nil
+}
+
+// Definitions returns the graph structure of every component, in DAG order
+func (d *DAG) Definitions() []StepDefinition {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	definitions := make([]StepDefinition, 0, len(d.components))
+	for _, c := range d.components {
+		definitions = append(definitions, StepDefinition{
+			Type:      c.Type,
+			DependsOn: c.DependsOn,
+			AnyOf:     c.AnyOf,
+			Optional:  c.Optional,
+
+			OnCompleteActivity: c.OnCompleteActivity,
+			ActivityTimeout:    c.ActivityTimeout,
+			DisplayOrder:       c.DisplayOrder,
+			EstimatedDuration:  c.EstimatedDuration,
+		})
+	}
+	return definitions
+}
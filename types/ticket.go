@@ -0,0 +1,93 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KitchenTicketWidth is the line width of a 58mm thermal printer
+const KitchenTicketWidth = 32
+
+// KitchenTicket holds just what a kitchen ticket prints
+type KitchenTicket struct {
+	OrderID      string   `json:"order_id"` // Short form, see ticketID
+	CustomerName string   `json:"customer_name"`
+	Items        []string `json:"items"`
+	Total        float64  `json:"total"`
+	NextAction   string   `json:"next_action,omitempty"` // Empty once nothing is ready
+}
+
+// NewKitchenTicket extracts the ticket fields from the order
+func NewKitchenTicket(order *PizzaOrder) KitchenTicket {
+	ticket := KitchenTicket{
+		OrderID:      ticketID(order.OrderID),
+		CustomerName: order.CustomerName,
+		Total:        order.Total,
+	}
+
+	pizza := "1x Pizza"
+	var details []string
+	if order.Complexity == ComplexityGourmet {
+		details = append(details, "gourmet")
+	}
+	if order.DoughType != "" {
+		details = append(details, order.DoughType+" crust")
+	}
+	if len(details) > 0 {
+		pizza += " (" + strings.Join(details, ", ") + ")"
+	}
+	ticket.Items = append(ticket.Items, pizza)
+	for _, topping := range order.Toppings {
+		ticket.Items = append(ticket.Items, "  + "+topping)
+	}
+	if order.ContainsAlcohol {
+		ticket.Items = append(ticket.Items, "  ! contains alcohol - check ID")
+	}
+
+	if order.DAG != nil {
+		if next := order.DAG.GetNextComponent(); next != nil {
+			ticket.NextAction = string(next.Type)
+		}
+	}
+	return ticket
+}
+
+// FormatKitchenTicket renders the order as plain ASCII lines no wider than
+// KitchenTicketWidth, ready to send to an ESC/POS printer
+func FormatKitchenTicket(order *PizzaOrder) string {
+	ticket := NewKitchenTicket(order)
+	rule := strings.Repeat("-", KitchenTicketWidth)
+
+	var b strings.Builder
+	line := func(text string) {
+		if len(text) > KitchenTicketWidth {
+			text = text[:KitchenTicketWidth]
+		}
+		b.WriteString(text)
+		b.WriteString("\n")
+	}
+
+	line("ORDER #" + ticket.OrderID)
+	line(ticket.CustomerName)
+	line(rule)
+	for _, item := range ticket.Items {
+		line(item)
+	}
+	line(rule)
+	total := fmt.Sprintf("$%.2f", ticket.Total)
+	line("TOTAL" + strings.Repeat(" ", max(KitchenTicketWidth-len("TOTAL")-len(total), 1)) + total)
+	if ticket.NextAction != "" {
+		line("NEXT: " + ticket.NextAction)
+	}
+	return b.String()
+}
+
+// ticketID shortens an order ID to the first 8 characters of its last path
+// segment, uppercased, which is enough for the kitchen to match tickets
+func ticketID(orderID string) string {
+	id := orderID[strings.LastIndex(orderID, "/")+1:]
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return strings.ToUpper(id)
+}
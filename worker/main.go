@@ -2,8 +2,12 @@ package main
 
 import (
 	"log"
+	"net/http"
+	"os"
+	"strconv"
 
 	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/metrics"
 	"pizza-order-dag-demo/workflow"
 
 	"go.temporal.io/sdk/client"
@@ -20,18 +24,29 @@ func main() {
 	}
 	defer c.Close()
 
+	if radius := os.Getenv("MAX_DELIVERY_RADIUS_KM"); radius != "" {
+		km, err := strconv.ParseFloat(radius, 64)
+		if err != nil {
+			log.Fatalf("Invalid MAX_DELIVERY_RADIUS_KM %q: %v", radius, err)
+		}
+		activities.MaxDeliveryRadiusKm = km
+	}
+
 	// 2. Create worker that listens on the task queue
 	w := worker.New(c, workflow.PizzaOrderTaskQueue, worker.Options{})
 
 	// 3. Register workflow
 	w.RegisterWorkflow(workflow.PizzaOrderWorkflow)
+	w.RegisterWorkflow(workflow.GourmetBakeWorkflow)
 
 	// 4. Register activities
 	paymentActivities := &activities.PaymentActivities{}
 	w.RegisterActivity(paymentActivities.ProcessPayment)
 	w.RegisterActivity(paymentActivities.RefundPayment)
+	w.RegisterActivity(paymentActivities.RefundPartialPayment)
 
 	deliveryActivities := &activities.DeliveryActivities{}
+	w.RegisterActivity(deliveryActivities.EstimateDeliveryFee)
 	w.RegisterActivity(deliveryActivities.ScheduleDelivery)
 	w.RegisterActivity(deliveryActivities.UpdateDeliveryStatus)
 
@@ -39,12 +54,40 @@ func main() {
 	w.RegisterActivity(notificationActivities.SendNotification)
 	w.RegisterActivity(notificationActivities.SendOrderConfirmation)
 	w.RegisterActivity(notificationActivities.SendDeliveryNotification)
+	w.RegisterActivity(notificationActivities.SendDeliveredNotification)
+	w.RegisterActivity(notificationActivities.SendPickupReadyNotification)
+	w.RegisterActivity(notificationActivities.SendDeliveryFailedNotification)
+	w.RegisterActivity(notificationActivities.SendDeliveryStatusNotification)
+	w.RegisterActivity(notificationActivities.SendOrderFailedNotification)
+	w.RegisterActivity(notificationActivities.SendDeliveryWindowConfirmation)
+
+	loyaltyActivities := &activities.LoyaltyActivities{
+		Ledger: activities.NewLoyaltyLedger(os.Getenv("LOYALTY_LEDGER_PATH")),
+	}
+	w.RegisterActivity(loyaltyActivities.AccrueLoyaltyPoints)
+
+	webhookActivities := &activities.WebhookActivities{URL: os.Getenv("ORDER_WEBHOOK_URL")}
+	w.RegisterActivity(webhookActivities.SendOrderSummaryWebhook)
+
+	// Activity failures and order durations are recorded in this process
+	metricsAddr := os.Getenv("WORKER_METRICS_ADDR")
+	if metricsAddr == "" {
+		metricsAddr = ":9091"
+	}
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+			log.Println("Worker metrics server stopped:", err)
+		}
+	}()
 
 	// 5. Start worker
 	log.Println("Worker starting...")
 	log.Println("Task Queue:", workflow.PizzaOrderTaskQueue)
-	log.Println("Registered Workflows:", workflow.PizzaOrderWorkflowName)
-	log.Println("Registered Activities: Payment, Delivery, Notification")
+	log.Println("Metrics:", metricsAddr+"/metrics")
+	log.Println("Registered Workflows:", workflow.PizzaOrderWorkflowName, workflow.GourmetBakeWorkflowName)
+	log.Println("Registered Activities: Payment, Delivery, Notification, Loyalty, Webhook")
 	log.Println("\nWaiting for workflow tasks...")
 
 	err = w.Run(worker.InterruptCh())
@@ -0,0 +1,43 @@
+package workflow
+
+import (
+	"time"
+
+	"pizza-order-dag-demo/types"
+)
+
+// Limits on failed step attempts per component. These protect the workflow
+// from logically-invalid retries, independent of any HTTP rate limiting.
+const (
+	MaxFailedAttempts   = 5
+	FailedAttemptWindow = 10 * time.Minute
+)
+
+// attemptTracker records recent failed attempts per component (workflow state)
+type attemptTracker struct {
+	failures map[types.ComponentType][]time.Time
+}
+
+func newAttemptTracker() *attemptTracker {
+	return &attemptTracker{failures: make(map[types.ComponentType][]time.Time)}
+}
+
+// allowed prunes failures outside the window and reports whether another attempt may run
+func (t *attemptTracker) allowed(componentType types.ComponentType, now time.Time) bool {
+	recent := t.failures[componentType][:0]
+	for _, failedAt := range t.failures[componentType] {
+		if now.Sub(failedAt) < FailedAttemptWindow {
+			recent = append(recent, failedAt)
+		}
+	}
+	t.failures[componentType] = recent
+	return len(recent) < MaxFailedAttempts
+}
+
+func (t *attemptTracker) recordFailure(componentType types.ComponentType, now time.Time) {
+	t.failures[componentType] = append(t.failures[componentType], now)
+}
+
+func (t *attemptTracker) reset(componentType types.ComponentType) {
+	delete(t.failures, componentType)
+}
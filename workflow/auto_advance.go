@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"time"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// autoAdvance drives an AutoAdvance order: whenever a step it can run is
+// ready, it waits delay on a timer and then runs the step's handler. A manual
+// update that completes the step first cancels the timer. A step that fails
+// stays ready and is tried again after another delay. Returns once the order
+// is done or no longer active.
+func autoAdvance(ctx workflow.Context, state *types.PizzaOrder,
+	steps map[types.ComponentType]func(workflow.Context) (*types.PizzaOrder, error), delay time.Duration) {
+	logger := orderLogger(ctx)
+	stopped := func() bool { return checkOrderActive(state) != nil || state.IsDone() }
+
+	for {
+		var next *types.Component
+		err := workflow.Await(ctx, func() bool {
+			next = nil
+			for _, c := range state.DAG.GetReadyComponents() {
+				if _, ok := steps[c.Type]; ok {
+					next = c
+					break
+				}
+			}
+			return next != nil || stopped()
+		})
+		if err != nil || next == nil {
+			return
+		}
+
+		// Cancel the timer as soon as the step is done some other way
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		workflow.Go(timerCtx, func(ctx workflow.Context) {
+			err := workflow.Await(ctx, func() bool { return next.State != types.StateIncomplete || stopped() })
+			if err == nil {
+				cancelTimer()
+			}
+		})
+		err = workflow.NewTimer(timerCtx, delay).Get(timerCtx, nil)
+		cancelTimer() // Stops the watcher when the timer fired
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Info("Auto-advance timer cancelled, step completed manually", "component", next.Type)
+			continue
+		}
+
+		logger.Info("Auto-advancing", "component", next.Type)
+		if _, err := steps[next.Type](ctx); err != nil {
+			logger.Warn("Auto-advance step failed, will try again", "component", next.Type, "error", err)
+		}
+	}
+}
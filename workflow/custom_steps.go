@@ -0,0 +1,74 @@
+package workflow
+
+import (
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// builtinSteps are the components the workflow completes through their own
+// update (or by itself, like REST_DOUGH). Any other component in a custom
+// graph is a custom step.
+var builtinSteps = map[types.ComponentType]bool{
+	types.ComponentPayment:         true,
+	types.ComponentMakeDough:       true,
+	types.ComponentProofDough:      true,
+	types.ComponentRestDough:       true,
+	types.ComponentAddSauce:        true,
+	types.ComponentAddCheese:       true,
+	types.ComponentAddToppings:     true,
+	types.ComponentBakePizza:       true,
+	types.ComponentDeliver:         true,
+	types.ComponentPickupReady:     true,
+	types.ComponentPhotoProof:      true,
+	types.ComponentAgeVerification: true,
+}
+
+// CustomStepInput is the input to a custom step's update
+type CustomStepInput struct {
+	StepOptions
+}
+
+// IsCustomStep reports whether the component has no built-in update and is
+// completed through CustomStepUpdateName
+func IsCustomStep(componentType types.ComponentType) bool {
+	return !builtinSteps[componentType]
+}
+
+// CustomStepUpdateName is the update that completes a custom step
+func CustomStepUpdateName(componentType types.ComponentType) string {
+	return "CompleteStep:" + string(componentType)
+}
+
+// registerCustomSteps registers an update for every custom step in the
+// order's graph. Custom steps run their OnCompleteActivity, if any, and are
+// guarded like the built-in ones. Returns each step's handler, bound to an
+// empty input, for the workflow to run itself.
+func registerCustomSteps(ctx workflow.Context, guard *stepGuard, state *types.PizzaOrder) (map[types.ComponentType]func(workflow.Context) (*types.PizzaOrder, error), error) {
+	logger := orderLogger(ctx)
+	handlers := make(map[types.ComponentType]func(workflow.Context) (*types.PizzaOrder, error))
+	for _, component := range state.DAG.GetComponents() {
+		componentType := component.Type
+		if !IsCustomStep(componentType) {
+			continue
+		}
+
+		complete := guardStep(guard, componentType, func(ctx workflow.Context, stepInput CustomStepInput) (*types.PizzaOrder, error) {
+			if err := checkOrderActive(state); err != nil {
+				return nil, err
+			}
+			logger.Info("Processing custom step", "component", componentType)
+			if err := completeWithActivity(ctx, state, componentType); err != nil {
+				return nil, err
+			}
+			state.UpdateTime = workflow.Now(ctx)
+			logger.Info("Custom step completed", "component", componentType, "nextComponent", state.DAG.GetNextComponent())
+			return state, nil
+		})
+		if err := registerStep(ctx, CustomStepUpdateName(componentType), componentType, state, complete); err != nil {
+			return nil, err
+		}
+		handlers[componentType] = func(ctx workflow.Context) (*types.PizzaOrder, error) { return complete(ctx, CustomStepInput{}) }
+	}
+	return handlers, nil
+}
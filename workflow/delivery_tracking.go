@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// trackDelivery polls the delivery service once a driver has been scheduled:
+// every interval it runs UpdateDeliveryStatus and, when the status changed,
+// stores it on the order, records it in the history and tells the customer.
+// Returns once the delivery is DELIVERED, the order is no longer active, or
+// ctx is cancelled. Manual dispatches have no delivery ID and aren't tracked.
+func trackDelivery(ctx workflow.Context, state *types.PizzaOrder, interval time.Duration) {
+	logger := orderLogger(ctx)
+
+	err := workflow.Await(ctx, func() bool { return state.DeliveryID != "" || checkOrderActive(state) != nil })
+	if err != nil || state.DeliveryID == "" {
+		return
+	}
+
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: DefaultActivityTimeout,
+	})
+	for state.DeliveryStatus != types.DeliveryStatusDelivered {
+		if err := workflow.Sleep(ctx, interval); err != nil {
+			return
+		}
+		if checkOrderActive(state) != nil {
+			return
+		}
+
+		var status string
+		err := workflow.ExecuteActivity(activityCtx, "UpdateDeliveryStatus", state.DeliveryID).Get(activityCtx, &status)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Warn("Failed to poll delivery status, will try again", "deliveryID", state.DeliveryID, "error", err)
+			continue
+		}
+		if status == state.DeliveryStatus {
+			continue
+		}
+
+		previous := state.DeliveryStatus
+		state.DeliveryStatus = status
+		if len(state.Deliveries) > 0 {
+			state.Deliveries[0].Status = status
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		state.RecordEvent(state.UpdateTime, types.EventDeliveryStatusChanged, fmt.Sprintf("%s -> %s", previous, status))
+		logger.Info("Delivery status changed", "from", previous, "to", status)
+
+		var notifErr error
+		if recipient, ok := notificationRecipient(state); ok {
+			workflow.ExecuteActivity(activityCtx, "SendDeliveryStatusNotification",
+				recipient, status).Get(activityCtx, &notifErr)
+			// Ignore notification errors - not critical
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/temporal"
+)
+
+// Application error types returned by update handlers.
+// Errors lose their Go identity when they cross the Temporal boundary, so the
+// HTTP layer matches on these names via temporal.ApplicationError.Type().
+const (
+	ErrOrderNotActive        = "ErrOrderNotActive"
+	ErrInvalidInput          = "ErrInvalidInput"          // PizzaOrderInput failed Normalize, or a step input its Validate
+	ErrComponentNotFound     = "ErrComponentNotFound"     // The component isn't part of this order's DAG
+	ErrComponentNotReady     = "ErrComponentNotReady"     // The component's dependencies aren't complete
+	ErrComponentNotRetryable = "ErrComponentNotRetryable" // The component has no activity to retry
+	ErrActivityTimeout       = "ErrActivityTimeout"       // An activity hit its StartToCloseTimeout
+	ErrPaymentDeclined       = "ErrPaymentDeclined"       // The payment gateway rejected the charge
+	ErrDeliveryUnavailable   = "ErrDeliveryUnavailable"   // The delivery service couldn't assign a driver
+	ErrDeliveryWindowNotOpen = "ErrDeliveryWindowNotOpen" // DELIVER was requested before the delivery window
+	ErrStepActivityFailed    = "ErrStepActivityFailed"    // A step's OnCompleteActivity failed
+	ErrTooManyAttempts       = "ErrTooManyAttempts"       // A component failed too often within the attempt window
+	ErrStepNotResettable     = "ErrStepNotResettable"     // The component isn't completed, or can't be redone
+	ErrItemNotFound          = "ErrItemNotFound"          // The item isn't part of this order
+	ErrItemNotRemovable      = "ErrItemNotRemovable"      // The item is baked, or the last one left
+)
+
+// checkOrderActive is the shared precondition for update handlers:
+// steps can only be completed while the order is still IN_PROGRESS
+func checkOrderActive(order *types.PizzaOrder) error {
+	if order.State != types.OrderStateInProgress {
+		return temporal.NewApplicationError(
+			fmt.Sprintf("order %s is not active (current: %s)", order.OrderID, order.State),
+			ErrOrderNotActive)
+	}
+	return nil
+}
+
+// checkStepReady is the shared precondition used by update validators: the
+// order must be active and the component must exist and have its dependencies
+// met. Already-completed components pass, so repeats return the current state.
+func checkStepReady(order *types.PizzaOrder, componentType types.ComponentType) error {
+	if err := checkOrderActive(order); err != nil {
+		return err
+	}
+
+	component, err := order.DAG.GetComponent(componentType)
+	if err != nil {
+		return temporal.NewApplicationError(
+			fmt.Sprintf("component %s is not part of order %s", componentType, order.OrderID),
+			ErrComponentNotFound)
+	}
+	if component.State == types.StateNeedsInit {
+		return temporal.NewApplicationError(
+			fmt.Sprintf("component %s is not ready yet - its dependencies are incomplete", componentType),
+			ErrComponentNotReady)
+	}
+	return nil
+}
+
+// dagFailure converts an error from a DAG operation into a typed application
+// error, so the HTTP layer can tell a missing component (404) from one in the
+// wrong state (409)
+func dagFailure(err error) error {
+	switch {
+	case errors.Is(err, types.ErrComponentNotFound):
+		return temporal.NewApplicationError(err.Error(), ErrComponentNotFound)
+	case errors.Is(err, types.ErrDependenciesNotMet), errors.Is(err, types.ErrComponentNotReady):
+		return temporal.NewApplicationError(err.Error(), ErrComponentNotReady)
+	}
+	return err
+}
+
+// activityFailure converts an activity error into a typed application error,
+// telling timeouts apart from business failures (which get failureType)
+func activityFailure(message string, err error, failureType string) error {
+	var timeoutErr *temporal.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return temporal.NewApplicationErrorWithCause(
+			fmt.Sprintf("%s: activity timed out", message), ErrActivityTimeout, err)
+	}
+
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return temporal.NewApplicationErrorWithCause(
+			fmt.Sprintf("%s: %s", message, appErr.Message()), failureType, err)
+	}
+
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// terminalFailure reports whether a step failed for good: its activity (or
+// child workflow) already used up its retry policy, so re-sending the step
+// won't help. Validation and DAG errors aren't terminal.
+func terminalFailure(err error) bool {
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+	switch appErr.Type() {
+	case ErrStepActivityFailed, ErrActivityTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package workflow
+
+import (
+	"time"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// GourmetBakeWorkflowName is the child workflow that runs a gourmet BAKE
+	GourmetBakeWorkflowName = "GourmetBakeWorkflow"
+
+	// QueryBakeProgress returns the child's own view of its progress
+	QueryBakeProgress = "QueryBakeProgress"
+
+	// SignalBakeProgress is sent by the child to its parent order after each stage
+	SignalBakeProgress = "BakeProgress"
+)
+
+// BakeStage is one timed stage of a multi-stage bake
+type BakeStage struct {
+	Name     string
+	Duration time.Duration
+}
+
+// GourmetBakeStages are the stages a gourmet BAKE goes through, in order
+var GourmetBakeStages = []BakeStage{
+	{Name: "PREHEAT_STONE", Duration: 5 * time.Second},
+	{Name: "BAKE", Duration: 20 * time.Second},
+	{Name: "FINISH", Duration: 5 * time.Second},
+}
+
+// GourmetBakeInput is the input to the gourmet bake child workflow
+type GourmetBakeInput struct {
+	OrderID string
+	Stages  []BakeStage
+}
+
+// GourmetBakeWorkflow runs each bake stage in turn. It reports progress to its
+// parent order with a signal after every stage, so the order's own query can
+// show it, and returns the final progress when the bake is done.
+func GourmetBakeWorkflow(ctx workflow.Context, input GourmetBakeInput) (*types.StepProgress, error) {
+	logger := workflow.GetLogger(ctx)
+	info := workflow.GetInfo(ctx)
+
+	progress := &types.StepProgress{
+		Component:       types.ComponentBakePizza,
+		ChildWorkflowID: info.WorkflowExecution.ID,
+		StagesTotal:     len(input.Stages),
+	}
+	err := workflow.SetQueryHandler(ctx, QueryBakeProgress, func() (*types.StepProgress, error) {
+		return progress, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Progress reports are best effort: a parent that can't be reached
+	// (e.g. it was terminated) must not fail the bake
+	report := func() {
+		parent := info.ParentWorkflowExecution
+		if parent == nil {
+			return
+		}
+		err := workflow.SignalExternalWorkflow(ctx, parent.ID, "", SignalBakeProgress, *progress).Get(ctx, nil)
+		if err != nil {
+			logger.Warn("Failed to report bake progress", "error", err)
+		}
+	}
+
+	for _, stage := range input.Stages {
+		progress.Stage = stage.Name
+		report()
+		logger.Info("Bake stage started", "orderID", input.OrderID, "stage", stage.Name, "duration", stage.Duration)
+		if err := workflow.Sleep(ctx, stage.Duration); err != nil {
+			return nil, err
+		}
+		progress.StagesDone++
+	}
+
+	progress.Stage = ""
+	report()
+	logger.Info("Gourmet bake finished", "orderID", input.OrderID)
+	return progress, nil
+}
+
+// bakeWithChild runs the gourmet bake as a child workflow and completes BAKE
+// when it finishes. The child is terminated if the order is.
+func bakeWithChild(ctx workflow.Context, state *types.PizzaOrder) error {
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:               state.OrderID + "/gourmet-bake",
+		WorkflowExecutionTimeout: 10 * time.Minute,
+	})
+
+	var result types.StepProgress
+	err := workflow.ExecuteChildWorkflow(childCtx, GourmetBakeWorkflowName, GourmetBakeInput{
+		OrderID: state.OrderID,
+		Stages:  GourmetBakeStages,
+	}).Get(childCtx, &result)
+	if err != nil {
+		return activityFailure("gourmet bake failed", err, ErrStepActivityFailed)
+	}
+
+	state.BakeProgress = &result
+	return completeComponent(ctx, state, types.ComponentBakePizza)
+}
@@ -0,0 +1,122 @@
+package workflow
+
+import (
+	"fmt"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// stepGuard holds the per-order state used to protect step handlers
+type stepGuard struct {
+	state    *types.PizzaOrder
+	attempts *attemptTracker
+	keys     *idempotencyTracker
+	running  map[types.ComponentType]bool // Steps with a handler currently in flight
+
+	// cancelling is set while a CancelOrder update refunds the order. Steps
+	// wait it out, then fail the active check if the cancel went through.
+	cancelling bool
+}
+
+func newStepGuard(state *types.PizzaOrder) *stepGuard {
+	return &stepGuard{
+		state:    state,
+		attempts: newAttemptTracker(),
+		keys:     newIdempotencyTracker(),
+		running:  make(map[types.ComponentType]bool),
+	}
+}
+
+// completed reports whether the component is already done
+func (g *stepGuard) completed(componentType types.ComponentType) bool {
+	component, err := g.state.DAG.GetComponent(componentType)
+	return err == nil && component.State == types.StateCompleted
+}
+
+// guardStep wraps a step handler:
+//   - concurrent requests for the same step run one at a time. Handlers can
+//     interleave while one waits on an activity, so later ones wait for it.
+//     They also wait for an in-flight cancellation to settle.
+//   - a request whose idempotency key already completed this component gets
+//     the order that request returned; one that arrives after the component
+//     completed gets the current order. Either is marked Replayed, and the
+//     step (and its activities) doesn't run again.
+//   - too many failures on the same component within FailedAttemptWindow
+//     are rejected with ErrTooManyAttempts; the counter resets on success
+func guardStep[T stepRequest](guard *stepGuard, componentType types.ComponentType,
+	handler func(workflow.Context, T) (*types.PizzaOrder, error)) func(workflow.Context, T) (*types.PizzaOrder, error) {
+	return func(ctx workflow.Context, stepInput T) (*types.PizzaOrder, error) {
+		err := workflow.Await(ctx, func() bool { return !guard.running[componentType] && !guard.cancelling })
+		if err != nil {
+			return nil, err
+		}
+
+		key := stepInput.idempotencyKey()
+		if prior, ok := guard.keys.result(componentType, key); key != "" && ok {
+			workflow.GetLogger(ctx).Info("Duplicate step request, returning prior result",
+				"component", componentType, "idempotencyKey", key)
+			return replayed(prior), nil
+		}
+		if guard.completed(componentType) {
+			workflow.GetLogger(ctx).Info("Step already completed, returning current state",
+				"component", componentType)
+			return replayed(guard.state), nil
+		}
+
+		if !guard.attempts.allowed(componentType, workflow.Now(ctx)) {
+			return nil, temporal.NewApplicationError(
+				fmt.Sprintf("too many failed attempts on %s, try again later", componentType),
+				ErrTooManyAttempts)
+		}
+
+		guard.running[componentType] = true
+		order, err := handler(ctx, stepInput)
+		delete(guard.running, componentType)
+		if err != nil {
+			guard.attempts.recordFailure(componentType, workflow.Now(ctx))
+			guard.state.RecordEvent(workflow.Now(ctx), types.EventStepFailed,
+				fmt.Sprintf("%s: %v", componentType, err))
+			return nil, err
+		}
+
+		guard.attempts.reset(componentType)
+		if key != "" {
+			// The same copy answers this request and any repeat of it
+			order = guard.keys.record(componentType, key, order)
+		}
+		return order, nil
+	}
+}
+
+// replayed is the result of a step request that ran nothing: a copy of the
+// order marked Replayed, so callers don't count the completion twice
+func replayed(state *types.PizzaOrder) *types.PizzaOrder {
+	result := state.Clone()
+	result.Replayed = true
+	return result
+}
+
+// stepValidator is implemented by update inputs whose fields need checking
+type stepValidator interface {
+	Validate() error
+}
+
+// registerStep sets a step's update handler along with a validator that
+// rejects steps that aren't ready, or whose input fails its Validate, before
+// the update is admitted to history
+func registerStep[T stepRequest](ctx workflow.Context, updateName string, componentType types.ComponentType,
+	state *types.PizzaOrder, handler func(workflow.Context, T) (*types.PizzaOrder, error)) error {
+	return workflow.SetUpdateHandlerWithOptions(ctx, updateName, handler, workflow.UpdateHandlerOptions{
+		Validator: func(stepInput T) error {
+			if v, ok := any(stepInput).(stepValidator); ok {
+				if err := v.Validate(); err != nil {
+					return temporal.NewApplicationError(err.Error(), ErrInvalidInput)
+				}
+			}
+			return checkStepReady(state, componentType)
+		},
+	})
+}
@@ -0,0 +1,41 @@
+package workflow
+
+import (
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// completeComponent marks a component complete and records it in the order's
+// History, along with a COMPONENT_READY event for each dependent it unblocks.
+// Workflow code completes components through here rather than calling
+// DAG.CompleteComponentAt directly.
+func completeComponent(ctx workflow.Context, state *types.PizzaOrder, componentType types.ComponentType) error {
+	waiting := map[types.ComponentType]bool{}
+	for _, c := range state.DAG.GetComponents() {
+		if c.State == types.StateNeedsInit {
+			waiting[c.Type] = true
+		}
+	}
+
+	now := workflow.Now(ctx)
+	if err := state.DAG.CompleteComponentAt(componentType, now); err != nil {
+		return dagFailure(err)
+	}
+
+	state.RecordCompletion(now, componentType)
+	for _, c := range state.DAG.GetReadyComponents() {
+		if waiting[c.Type] {
+			state.RecordEvent(now, types.EventComponentReady, string(c.Type))
+		}
+	}
+	return nil
+}
+
+// recordReadyComponents records a COMPONENT_READY event for every component
+// that is ready when the order starts
+func recordReadyComponents(ctx workflow.Context, state *types.PizzaOrder) {
+	for _, c := range state.DAG.GetReadyComponents() {
+		state.RecordEvent(workflow.Now(ctx), types.EventComponentReady, string(c.Type))
+	}
+}
@@ -0,0 +1,70 @@
+package workflow
+
+import "pizza-order-dag-demo/types"
+
+// MaxIdempotencyKeys bounds how many completed keys the workflow remembers.
+// The oldest keys are forgotten first.
+const MaxIdempotencyKeys = 100
+
+// StepOptions carries request metadata shared by every step input.
+// It is embedded in each update input struct.
+type StepOptions struct {
+	// IdempotencyKey makes a step safe to resend: once a step completed with
+	// a key, repeating it returns the order as that request returned it,
+	// without re-running anything
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// SetIdempotencyKey lets the HTTP layer fill the key from a request header
+func (o *StepOptions) SetIdempotencyKey(key string) {
+	o.IdempotencyKey = key
+}
+
+func (o StepOptions) idempotencyKey() string {
+	return o.IdempotencyKey
+}
+
+// stepRequest is satisfied by every update input that embeds StepOptions
+type stepRequest interface {
+	idempotencyKey() string
+}
+
+// idempotencyKey identifies a completed request for one component
+type idempotencyKey struct {
+	component types.ComponentType
+	key       string
+}
+
+// idempotencyTracker remembers what each completed key returned, per
+// component (workflow state)
+type idempotencyTracker struct {
+	results map[idempotencyKey]*types.PizzaOrder
+	order   []idempotencyKey // Insertion order, for eviction
+}
+
+func newIdempotencyTracker() *idempotencyTracker {
+	return &idempotencyTracker{results: make(map[idempotencyKey]*types.PizzaOrder)}
+}
+
+// result returns what the request with this key returned, if it completed
+func (t *idempotencyTracker) result(componentType types.ComponentType, key string) (*types.PizzaOrder, bool) {
+	result, ok := t.results[idempotencyKey{componentType, key}]
+	return result, ok
+}
+
+// record keeps a copy of the order a keyed request returned, since the order
+// itself moves on, and returns the copy to answer the request with
+func (t *idempotencyTracker) record(componentType types.ComponentType, key string, result *types.PizzaOrder) *types.PizzaOrder {
+	k := idempotencyKey{componentType, key}
+	if prior, ok := t.results[k]; ok {
+		return prior
+	}
+
+	t.results[k] = result.Clone()
+	t.order = append(t.order, k)
+	if len(t.order) > MaxIdempotencyKeys {
+		delete(t.results, t.order[0])
+		t.order = t.order[1:]
+	}
+	return t.results[k]
+}
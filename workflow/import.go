@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"fmt"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// ImportInput builds the input that resumes an exported order in a new
+// workflow, checking the bundle the way order creation checks a request.
+// Decoding the bundle has already validated and recomputed its graph; the
+// graph is kept as exported, so Steps come from it rather than from the
+// order's template, items or alcohol flag. Only in-progress orders can be
+// imported, since a finished one has nothing left to run.
+func ImportInput(bundle *types.OrderBundle) (*PizzaOrderInput, error) {
+	if bundle.Version != types.OrderBundleVersion {
+		return nil, fmt.Errorf("bundle version %d is not supported, want %d", bundle.Version, types.OrderBundleVersion)
+	}
+	order := bundle.Order
+	if order == nil || order.DAG == nil {
+		return nil, fmt.Errorf("bundle has no order components")
+	}
+	if order.State != types.OrderStateInProgress {
+		return nil, fmt.Errorf("only %s orders can be imported, got %s", types.OrderStateInProgress, order.State)
+	}
+	steps := order.DAG.Definitions()
+	if len(bundle.Steps) > 0 && !sameStepTypes(bundle.Steps, steps) {
+		return nil, fmt.Errorf("bundle steps don't match the order's components")
+	}
+
+	in := &PizzaOrderInput{
+		CustomerName:    order.CustomerName,
+		CustomerEmail:   order.CustomerEmail,
+		CustomerPhone:   order.CustomerPhone,
+		DeliveryAddress: order.DeliveryAddress,
+		Amount:          order.Subtotal,
+		TaxRate:         order.TaxRate,
+		CompReason:      order.CompReason,
+		Complexity:      order.Complexity,
+		DAGTemplate:     types.TemplateCustom,
+		Fulfillment:     order.Fulfillment,
+		RemakeOf:        order.RemakeOf,
+		RemakeReason:    order.RemakeReason,
+		Toppings:        order.Toppings,
+
+		DeliveryWindowStart: order.DeliveryWindowStart,
+		DeliveryWindowEnd:   order.DeliveryWindowEnd,
+
+		NotificationPreference: order.NotificationPrefs.Channel,
+
+		Steps:    steps,
+		Imported: order,
+	}
+	// Splits already charged are refunded as recorded, not re-checked
+	if order.PaymentAmount == 0 {
+		in.PaymentSplits = order.PaymentSplits
+	}
+	if err := in.Normalize(); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// importedState is the state an imported order resumes from: the exported
+// order under this workflow's ID and run, with an event recording where it
+// came from. fresh is the state the workflow built from the input.
+func importedState(ctx workflow.Context, imported, fresh *types.PizzaOrder) *types.PizzaOrder {
+	state := imported.Clone()
+	state.OrderID = fresh.OrderID
+	state.RunID = fresh.RunID
+	state.ExpiresAt = fresh.ExpiresAt
+	state.SummaryWebhook = nil
+	state.Replayed = false
+	state.UpdateTime = workflow.Now(ctx)
+	state.RecordEvent(state.UpdateTime, types.EventOrderImported, imported.OrderID)
+	return state
+}
@@ -0,0 +1,373 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/types"
+)
+
+// Defaults applied by PizzaOrderInput.Normalize
+const (
+	DefaultCustomerPhone   = "+1-555-0100"
+	DefaultDeliveryAddress = "123 Main St, San Francisco, CA"
+
+	// DefaultDeliveryRetryInterval spaces automatic delivery retries
+	DefaultDeliveryRetryInterval = 10 * time.Minute
+
+	// DefaultDeliveryPollInterval spaces delivery status checks
+	DefaultDeliveryPollInterval = 1 * time.Minute
+
+	// DefaultStepDelay is how long an AutoAdvance order waits on each step
+	DefaultStepDelay = 5 * time.Second
+)
+
+// Normalize fills in defaults and validates the input. The HTTP layer calls it
+// before starting an order, and the workflow calls it again as a safety net
+// for orders started by other means (CLI, tests).
+//
+// Amount has no default here: 0 means a free order, which needs a CompReason.
+func (in *PizzaOrderInput) Normalize() error {
+	if in.CustomerName == "" {
+		return fmt.Errorf("customer_name is required")
+	}
+	if in.CustomerEmail == "" {
+		in.CustomerEmail = fmt.Sprintf("%s@example.com", in.CustomerName)
+	}
+	if in.CustomerPhone == "" {
+		in.CustomerPhone = DefaultCustomerPhone
+	}
+
+	in.NotificationPreference = strings.ToUpper(in.NotificationPreference)
+	if !types.IsValidNotificationChannel(in.NotificationPreference) {
+		return fmt.Errorf("notification_preference must be one of SMS, EMAIL, PUSH, NONE, got %q", in.NotificationPreference)
+	}
+
+	in.Fulfillment = strings.ToUpper(in.Fulfillment)
+	if in.Fulfillment == "" {
+		in.Fulfillment = types.FulfillmentDelivery
+	}
+	if !types.IsValidFulfillment(in.Fulfillment) {
+		return fmt.Errorf("fulfillment must be DELIVERY or PICKUP, got %q", in.Fulfillment)
+	}
+	pickup := in.Fulfillment == types.FulfillmentPickup
+
+	if (in.DeliveryWindowStart == nil) != (in.DeliveryWindowEnd == nil) {
+		return fmt.Errorf("delivery_window_start and delivery_window_end must be given together")
+	}
+	if in.DeliveryWindowStart != nil {
+		if pickup {
+			return fmt.Errorf("a delivery window can't be set for pickup orders")
+		}
+		if !in.DeliveryWindowStart.Before(*in.DeliveryWindowEnd) {
+			return fmt.Errorf("delivery_window_start must be before delivery_window_end")
+		}
+	}
+
+	// Pickup orders have nowhere to deliver to, so no address is needed
+	if in.DeliveryAddress == "" && !pickup {
+		in.DeliveryAddress = DefaultDeliveryAddress
+	}
+
+	if in.DeliveryRetryAttempts < 0 || in.DeliveryRetryInterval < 0 {
+		return fmt.Errorf("delivery retry attempts and interval must not be negative")
+	}
+	if in.DeliveryRetryAttempts > 0 && in.DeliveryRetryInterval == 0 {
+		in.DeliveryRetryInterval = DefaultDeliveryRetryInterval
+	}
+
+	if in.DeliveryPollInterval < 0 {
+		return fmt.Errorf("delivery_poll_interval must not be negative")
+	}
+	if in.DeliveryPollInterval == 0 {
+		in.DeliveryPollInterval = DefaultDeliveryPollInterval
+	}
+
+	if err := in.validateActivityOverrides(); err != nil {
+		return err
+	}
+
+	if in.OrderTimeout < 0 {
+		return fmt.Errorf("order_timeout must not be negative")
+	}
+
+	if in.StepDelay < 0 {
+		return fmt.Errorf("step_delay must not be negative")
+	}
+	if in.AutoAdvance && in.StepDelay == 0 {
+		in.StepDelay = DefaultStepDelay
+	}
+
+	if err := in.normalizeItems(); err != nil {
+		return err
+	}
+	if in.Amount < 0 {
+		return fmt.Errorf("amount must not be negative")
+	}
+	if in.Amount == 0 && in.CompReason == "" {
+		return fmt.Errorf("comp_reason is required for free orders")
+	}
+	if !types.IsValidTaxRate(in.TaxRate) {
+		return fmt.Errorf("tax_rate must be between 0 and 1, got %v", in.TaxRate)
+	}
+	if err := in.normalizePaymentSplits(); err != nil {
+		return err
+	}
+
+	in.Complexity = strings.ToUpper(in.Complexity)
+	if in.Complexity == "" {
+		in.Complexity = types.ComplexitySimple
+	}
+	if !types.IsValidComplexity(in.Complexity) {
+		return fmt.Errorf("complexity must be SIMPLE or GOURMET, got %q", in.Complexity)
+	}
+
+	// Custom steps replace the template; without either, prep complexity and
+	// fulfillment pick one
+	if len(in.Steps) > 0 {
+		if in.DAGTemplate != "" && in.DAGTemplate != types.TemplateCustom {
+			return fmt.Errorf("steps and template can't both be given")
+		}
+		in.DAGTemplate = types.TemplateCustom
+		if err := in.normalizeSteps(); err != nil {
+			return err
+		}
+	}
+	if in.DAGTemplate == "" {
+		in.DAGTemplate = defaultTemplate(in.Complexity, pickup)
+	}
+	dag, err := in.BuildDAG(types.Now())
+	if err != nil {
+		return err
+	}
+	if dag.SupportsPickup() != pickup {
+		return fmt.Errorf("template %q does not support %s fulfillment", in.DAGTemplate, in.Fulfillment)
+	}
+	// Custom graphs might have no handoff step for the age check to gate
+	if in.ContainsAlcohol {
+		if err := dag.AddAgeVerification(types.Now()); err != nil {
+			return fmt.Errorf("template %q can't take an age verification step: %w", in.DAGTemplate, err)
+		}
+	}
+	return nil
+}
+
+// BuildDAG creates the order's component graph from its custom Steps, or
+// from its template, with the order's activity timeouts applied. now
+// timestamps the components; the workflow passes workflow.Now so a replay
+// builds the same graph.
+func (in *PizzaOrderInput) BuildDAG(now time.Time) (*types.DAG, error) {
+	var dag *types.DAG
+	var err error
+	if in.DAGTemplate == types.TemplateCustom {
+		dag, err = types.NewDAGFromDefinitions(in.Steps, now)
+	} else {
+		dag, err = types.DefaultTemplates.Build(in.DAGTemplate, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts := map[types.ComponentType]time.Duration{
+		types.ComponentPayment: in.PaymentTimeout,
+		types.ComponentDeliver: in.DeliveryTimeout,
+	}
+	for componentType, timeout := range timeouts {
+		if timeout > 0 {
+			// Templates without the step have nothing to override
+			_ = dag.SetActivityTimeout(componentType, timeout)
+		}
+	}
+	return dag, nil
+}
+
+// validateActivityOverrides checks the per-order payment and delivery
+// attempts and timeouts; zero means the default
+func (in *PizzaOrderInput) validateActivityOverrides() error {
+	for _, attempts := range []int{in.PaymentMaxAttempts, in.DeliveryMaxAttempts} {
+		if attempts < 0 || attempts > MaxActivityAttempts {
+			return fmt.Errorf("max attempts must be between 1 and %d", MaxActivityAttempts)
+		}
+	}
+	for _, timeout := range []time.Duration{in.PaymentTimeout, in.DeliveryTimeout} {
+		if timeout < 0 || timeout > MaxActivityTimeout {
+			return fmt.Errorf("activity timeouts must be positive and at most %s", MaxActivityTimeout)
+		}
+	}
+	return nil
+}
+
+// ValidateSteps checks a custom step graph the way order creation does,
+// without starting an order: step types are normalized, and the graph must
+// include PAYMENT, only name known activities and be a valid DAG
+func ValidateSteps(steps []types.StepDefinition) (*types.DAG, error) {
+	in := PizzaOrderInput{Steps: steps}
+	if err := in.normalizeSteps(); err != nil {
+		return nil, err
+	}
+	return types.NewDAGFromDefinitions(in.Steps, types.Now())
+}
+
+// normalizeSteps uppercases custom step types and checks what the workflow
+// relies on: a PAYMENT step, and only activities it knows how to call
+func (in *PizzaOrderInput) normalizeSteps() error {
+	hasPayment := false
+	for i := range in.Steps {
+		step := &in.Steps[i]
+		step.Type = types.ComponentType(strings.ToUpper(string(step.Type)))
+		for j, dep := range step.DependsOn {
+			step.DependsOn[j] = types.ComponentType(strings.ToUpper(string(dep)))
+		}
+		for _, group := range step.AnyOf {
+			if len(group) == 0 {
+				return fmt.Errorf("step %s has an empty anyOf group", step.Type)
+			}
+			for j, dep := range group {
+				group[j] = types.ComponentType(strings.ToUpper(string(dep)))
+			}
+		}
+		if step.Type == types.ComponentPayment {
+			hasPayment = true
+		}
+		if step.OnCompleteActivity != "" {
+			if _, ok := stepActivityInputs[step.OnCompleteActivity]; !ok {
+				return fmt.Errorf("step %s names unknown activity %q", step.Type, step.OnCompleteActivity)
+			}
+		}
+	}
+	if !hasPayment {
+		return fmt.Errorf("steps must include %s", types.ComponentPayment)
+	}
+	return nil
+}
+
+// normalizeItems uppercases item IDs, checks each item is priced, and sets
+// Amount and Steps from the items. Amount, if given, must match their sum.
+// Normalize runs twice, so Steps the items already generated are accepted.
+func (in *PizzaOrderInput) normalizeItems() error {
+	if len(in.Items) == 0 {
+		return nil
+	}
+	if in.Fulfillment != types.FulfillmentDelivery {
+		return fmt.Errorf("items can only be ordered for delivery")
+	}
+
+	seen := make(map[string]bool, len(in.Items))
+	var sum float64
+	for i := range in.Items {
+		item := &in.Items[i]
+		item.ID = strings.ToUpper(strings.TrimSpace(item.ID))
+		if !validItemID(item.ID) {
+			return fmt.Errorf("item id %q must be letters, digits and dashes", item.ID)
+		}
+		if seen[item.ID] {
+			return fmt.Errorf("item id %s is used twice", item.ID)
+		}
+		seen[item.ID] = true
+		if item.Name == "" {
+			item.Name = item.ID
+		}
+		if item.Amount <= 0 {
+			return fmt.Errorf("item %s must have a positive amount", item.ID)
+		}
+		sum += item.Amount
+	}
+	sum = math.Round(sum*100) / 100
+	if in.Amount != 0 && math.Round(in.Amount*100) != math.Round(sum*100) {
+		return fmt.Errorf("amount %.2f doesn't match the items, which add up to %.2f", in.Amount, sum)
+	}
+	in.Amount = sum
+
+	steps := types.ItemSteps(in.Items)
+	if len(in.Steps) > 0 && !sameStepTypes(in.Steps, steps) {
+		return fmt.Errorf("steps and items can't both be given")
+	}
+	if in.DAGTemplate != "" && in.DAGTemplate != types.TemplateCustom {
+		return fmt.Errorf("template and items can't both be given")
+	}
+	in.Steps = steps
+	return nil
+}
+
+// validItemID reports whether id is non-empty and only uppercase letters,
+// digits and dashes, so it can name the item's steps
+func validItemID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStepTypes reports whether two step lists have the same types in order
+func sameStepTypes(a, b []types.StepDefinition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizePaymentSplits uppercases split methods and checks the splits add
+// up, to the cent, to what PAYMENT will charge
+func (in *PizzaOrderInput) normalizePaymentSplits() error {
+	if len(in.PaymentSplits) == 0 {
+		return nil
+	}
+	if in.Amount == 0 {
+		return fmt.Errorf("free orders can't have payment_splits")
+	}
+
+	var sum float64
+	for i := range in.PaymentSplits {
+		split := &in.PaymentSplits[i]
+		split.Method = strings.ToUpper(split.Method)
+		if !types.IsValidPaymentMethod(split.Method) {
+			return fmt.Errorf("payment split method must be CARD or GIFT_CARD, got %q", split.Method)
+		}
+		if split.Amount <= 0 {
+			return fmt.Errorf("payment split amounts must be positive")
+		}
+		sum += split.Amount
+	}
+	if total := in.Total(); math.Round(sum*100) != math.Round(total*100) {
+		return fmt.Errorf("payment_splits add up to %.2f but the order total is %.2f", sum, total)
+	}
+	return nil
+}
+
+// Total is what PAYMENT will charge: Amount plus tax, plus the delivery fee
+// for delivery orders that aren't free. It matches what the workflow computes.
+func (in *PizzaOrderInput) Total() float64 {
+	var fee float64
+	if in.Fulfillment == types.FulfillmentDelivery && in.Amount > 0 {
+		fee = activities.DeliveryFeeFor(activities.DistanceForAddress(in.DeliveryAddress))
+	}
+	total, _ := types.ComputeTotal(in.Amount, fee, 0, 0, in.TaxRate)
+	return total
+}
+
+// defaultTemplate maps prep complexity and fulfillment to a built-in template
+func defaultTemplate(complexity string, pickup bool) string {
+	gourmet := complexity == types.ComplexityGourmet
+	switch {
+	case gourmet && pickup:
+		return types.TemplateGourmetPickup
+	case gourmet:
+		return types.TemplateGourmet
+	case pickup:
+		return types.TemplatePickup
+	}
+	return types.TemplateStandard
+}
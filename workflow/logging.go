@@ -0,0 +1,30 @@
+package workflow
+
+import (
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/workflow"
+)
+
+// orderLogger is the workflow logger, tagged with the request ID the order was
+// created by (MemoRequestID) so worker logs can be matched to API logs
+func orderLogger(ctx workflow.Context) log.Logger {
+	logger := workflow.GetLogger(ctx)
+	if id := memoRequestID(ctx); id != "" {
+		logger = log.With(logger, "request_id", id)
+	}
+	return logger
+}
+
+// memoRequestID reads MemoRequestID from the workflow's memo, empty if unset
+func memoRequestID(ctx workflow.Context) string {
+	payload, ok := workflow.GetInfo(ctx).Memo.GetFields()[MemoRequestID]
+	if !ok {
+		return ""
+	}
+	var id string
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &id); err != nil {
+		return ""
+	}
+	return id
+}
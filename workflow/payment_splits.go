@@ -0,0 +1,104 @@
+package workflow
+
+import (
+	"fmt"
+	"math"
+
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// chargeSplits charges each of the order's payment splits with the PAYMENT
+// activity, as a saga: if one split fails, the splits already charged are
+// refunded before the error is returned, so a retry starts from scratch.
+// On success result sums the splits and carries the first transaction ID.
+// ctx carries the caller's activity options.
+func chargeSplits(ctx workflow.Context, state *types.PizzaOrder, result *activities.PaymentResult) (bool, error) {
+	name := stepActivityName(state, types.ComponentPayment)
+	if name == "" {
+		return false, nil
+	}
+
+	var total float64
+	for _, split := range state.PaymentSplits {
+		total += split.Amount
+	}
+	if !coversTotal(total, state.Total) {
+		return false, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment splits add up to %.2f but the order total is %.2f", total, state.Total),
+			ErrPaymentDeclined, nil)
+	}
+
+	logger := orderLogger(ctx)
+	for i := range state.PaymentSplits {
+		split := &state.PaymentSplits[i]
+		var charge activities.PaymentResult
+		err := workflow.ExecuteActivity(ctx, name, activities.PaymentInput{
+			OrderID:      state.OrderID,
+			CustomerName: state.CustomerName,
+			Amount:       split.Amount,
+			Method:       split.Method,
+
+			IdempotencyKey: paymentIdempotencyKey(state, fmt.Sprintf("split-%d", i)),
+		}).Get(ctx, &charge)
+		if err != nil {
+			logger.Error("Payment split failed, refunding earlier splits", "method", split.Method, "amount", split.Amount, "error", err)
+			refundSplits(ctx, state, state.PaymentSplits[:i])
+			return true, err
+		}
+		split.TransactionID = charge.TransactionID
+	}
+
+	result.TransactionID = state.PaymentSplits[0].TransactionID
+	result.Amount = math.Round(total*100) / 100
+	return true, nil
+}
+
+// refundSplits compensates splits that were charged, clearing their
+// transaction IDs. A failed refund is left on the order as a warning for an
+// operator to settle by hand.
+func refundSplits(ctx workflow.Context, state *types.PizzaOrder, charged []types.PaymentSplit) {
+	for i := range charged {
+		split := &charged[i]
+		if split.TransactionID == "" {
+			continue
+		}
+		if err := workflow.ExecuteActivity(ctx, "RefundPayment", split.TransactionID).Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Error("Failed to refund payment split", "txnID", split.TransactionID, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf(
+				"refund of %s split %s (%.2f) failed: %v", split.Method, split.TransactionID, split.Amount, err))
+			continue
+		}
+		split.TransactionID = ""
+	}
+}
+
+// refundPayment refunds everything the order was charged, split by split or
+// as the single PAYMENT transaction, and records it in state.RefundAmount.
+// Free and unpaid orders have nothing to refund. If any refund fails the
+// error is returned; splits already refunded aren't refunded again on retry.
+// ctx carries the caller's activity options.
+func refundPayment(ctx workflow.Context, state *types.PizzaOrder) error {
+	if len(state.PaymentSplits) > 0 {
+		refundSplits(ctx, state, state.PaymentSplits)
+		for _, split := range state.PaymentSplits {
+			if split.TransactionID != "" {
+				return fmt.Errorf("refund of %s split %s failed", split.Method, split.TransactionID)
+			}
+		}
+	} else if state.PaymentTxnID != "" {
+		if err := workflow.ExecuteActivity(ctx, "RefundPayment", state.PaymentTxnID).Get(ctx, nil); err != nil {
+			return err
+		}
+	}
+	state.RefundAmount = state.PaymentAmount
+	return nil
+}
+
+// coversTotal compares amounts to the cent
+func coversTotal(paid, total float64) bool {
+	return math.Round(paid*100) >= math.Round(total*100)
+}
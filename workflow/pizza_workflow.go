@@ -1,10 +1,15 @@
 package workflow
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/metrics"
 	"pizza-order-dag-demo/types"
 
 	"go.temporal.io/sdk/temporal"
@@ -16,17 +21,103 @@ const (
 	PizzaOrderWorkflowName = "PizzaOrderWorkflow"
 	PizzaOrderTaskQueue    = "pizza-order-queue"
 
-	// Query name
-	QueryOrderState = "QueryOrderState"
+	// Signal names
+	SignalUpdateNotificationPrefs = "UpdateNotificationPrefs"
+	SignalAssignDriver            = "AssignDriver"
+	SignalSetOrderSLA             = "SetOrderSLA"
+
+	// Query names
+	QueryOrderState       = "QueryOrderState"
+	QueryOrderStatePublic = "QueryOrderStatePublic"
+	QueryOrderSummary     = "QueryOrderSummary"
+	QueryStepDurations    = "QueryStepDurations"
+	QueryEventLog         = "QueryEventLog"
+	QueryComponentState   = "QueryComponentState"
+	QueryDeliveryStatus   = "QueryDeliveryStatus"
 
 	// Update names
-	UpdateCompletePayment     = "CompletePayment"
-	UpdateMakeDough           = "MakeDough"
-	UpdateAddToppings         = "AddToppings"
-	UpdateBakePizza           = "BakePizza"
-	UpdateDeliver             = "Deliver"
+	UpdateCompletePayment = "CompletePayment"
+	UpdateMakeDough       = "MakeDough"
+	UpdateProofDough      = "ProofDough"
+	UpdateAddSauce        = "AddSauce"
+	UpdateAddCheese       = "AddCheese"
+	UpdateAddToppings     = "AddToppings"
+	UpdateBakePizza       = "BakePizza"
+	UpdateDeliver         = "Deliver"
+	UpdateUploadProof     = "UploadProof"
+	UpdatePickupReady     = "PickupReady"
+	UpdateVerifyAge       = "VerifyAge"
+	UpdateRetryComponent  = "RetryComponent"
+	UpdateCancelOrder     = "CancelOrder"
+	UpdateResetComponent  = "ResetComponent"
+	UpdateRemoveItem      = "RemoveItem"
+
+	// OptionalStepWindow is how long the workflow stays open for optional steps
+	// (like photo proof) once all required steps are done
+	OptionalStepWindow = 1 * time.Hour
+
+	// DefaultActivityTimeout bounds activities that aren't a component's
+	// OnCompleteActivity, or whose component sets no ActivityTimeout
+	DefaultActivityTimeout = 30 * time.Second
+
+	// DeliveryTravelTime is the estimated drive time given to the delivery service
+	DeliveryTravelTime = 30 * time.Minute
+
+	// MaxDeliveryEstimate caps the drive time a Deliver request can set
+	MaxDeliveryEstimate = 3 * time.Hour
+
+	// Oven temperatures (°F) a BakePizza request can set
+	MinBakeTemperature = 400
+	MaxBakeTemperature = 950
+
+	// RemakeWindow is how long after completion an order can be remade
+	RemakeWindow = 24 * time.Hour
+
+	// DoughRestDuration is how long gourmet dough rests before toppings
+	DoughRestDuration = 2 * time.Minute
+
+	// Memo keys attached when an order workflow starts. Memos are returned with
+	// visibility records, so list views can show them without querying each workflow.
+	MemoCustomerName = "customer_name"
+	MemoAmount       = "amount"
+	MemoOrderState   = "order_state" // Upserted when the order finishes, see finishOrder
+	MemoRequestID    = "request_id"  // X-Request-ID of the API request that created the order
+)
+
+// Retry policies for the side-effecting activities. Kept as package variables
+// so the policy the workflow uses is the one tests assert against.
+var (
+	paymentRetryPolicy = &temporal.RetryPolicy{
+		MaximumAttempts: 3,
+	}
+	// "No drivers" isn't retried: the Deliver handler escalates to a wider pool instead
+	deliveryRetryPolicy = &temporal.RetryPolicy{
+		MaximumAttempts:        3,
+		NonRetryableErrorTypes: []string{activities.ErrNoDriversAvailable},
+	}
+
+	// MaxActivityAttempts and MaxActivityTimeout bound the per-order overrides
+	MaxActivityAttempts = 10
+	MaxActivityTimeout  = 5 * time.Minute
+
+	// MaxDeliveryEscalation caps how far Deliver widens the driver pool
+	// (see activities.EscalationZone .. EscalationPremium) before giving up
+	MaxDeliveryEscalation = activities.EscalationPremium
 )
 
+// DriverAssignment is the AssignDriver signal payload: a dispatcher's manual
+// choice of driver, applied instead of calling the delivery service
+type DriverAssignment struct {
+	DriverName       string    `json:"driver_name"`
+	EstimatedArrival time.Time `json:"estimated_arrival"` // Zero means 30 minutes from now
+}
+
+// OrderSLA is the SetOrderSLA signal payload: how long after creation the
+// order should be complete. A new target replaces the old one.
+type OrderSLA struct {
+	Target time.Duration `json:"target"`
+}
+
 // PizzaOrderInput is the input to start a new pizza order workflow
 type PizzaOrderInput struct {
 	OrderID         string
@@ -34,32 +125,264 @@ type PizzaOrderInput struct {
 	CustomerEmail   string
 	CustomerPhone   string
 	DeliveryAddress string
-	Amount          float64 // Pizza price
+	Amount          float64 // Pizza price before tax (0 for a free order)
+	TaxRate         float64 // Fraction in [0, 1] applied to Amount before charging
+	CompReason      string  // Why the order is free - required when Amount is 0
+	Complexity      string  // "SIMPLE" (default) or "GOURMET"
+	DAGTemplate     string  // Name in types.DefaultTemplates; Normalize derives it from Complexity
+	Fulfillment     string  // "DELIVERY" (default) or "PICKUP"
+	RemakeOf        string  // Original order ID when this order remakes one
+
+	// Optional delivery window; DELIVER is held until the driver can arrive in it
+	DeliveryWindowStart *time.Time
+	DeliveryWindowEnd   *time.Time
+
+	RemakeReason string   // Customer complaint that triggered the remake
+	Toppings     []string // Preselected toppings (e.g. from a reorder), used if ADD_TOPPINGS sends none
+
+	// ContainsAlcohol adds an AGE_VERIFICATION step that must complete before
+	// the order is handed over
+	ContainsAlcohol bool
+
+	// NotificationPreference is the channel for every notification: "SMS",
+	// "EMAIL", "PUSH" or "NONE". Empty uses each message's default channel.
+	NotificationPreference string
+
+	// Optional split of the charge across payment methods; must add up to the total
+	PaymentSplits []types.PaymentSplit
+
+	// Automatic DELIVER retries after a failure; 0 attempts waits for a re-POST
+	DeliveryRetryAttempts int
+	DeliveryRetryInterval time.Duration // Defaults to DefaultDeliveryRetryInterval
+
+	// How often the delivery service is polled for status once a driver is scheduled
+	DeliveryPollInterval time.Duration // Defaults to DefaultDeliveryPollInterval
+
+	// Per-order overrides of the payment and delivery activity options, e.g.
+	// to stress-test retries. Zero keeps the defaults: the component's
+	// ActivityTimeout and the MaximumAttempts of the package retry policy.
+	PaymentMaxAttempts  int
+	PaymentTimeout      time.Duration
+	DeliveryMaxAttempts int
+	DeliveryTimeout     time.Duration
+
+	// OrderTimeout cancels the order, refunding any payment, if its required
+	// steps aren't all done this long after creation. Zero never expires.
+	OrderTimeout time.Duration
+
+	// AutoAdvance makes the order self-driving: StepDelay after a step becomes
+	// ready, the workflow completes it unless someone already did
+	AutoAdvance bool
+	StepDelay   time.Duration // Defaults to DefaultStepDelay
+
+	// Steps is a custom component graph used instead of a template, for items
+	// with their own prep (calzones, salads). Steps without a built-in update
+	// are completed through CustomStepUpdateName.
+	Steps []types.StepDefinition
+
+	// Items makes a multi-item delivery order: Normalize prices it at their
+	// sum and builds its Steps with types.ItemSteps. Items can be removed
+	// until they're baked, see UpdateRemoveItem.
+	Items []types.OrderItem
+
+	// Imported is an exported order to resume instead of starting afresh:
+	// its components keep their state, and its payment and history carry
+	// over. See ImportInput.
+	Imported *types.PizzaOrder
+}
+
+// Update handler inputs - each step can carry its own data from the caller.
+// An empty request body decodes to the zero value, so every field is optional.
+
+// CompletePaymentInput is the input to the CompletePayment update
+type CompletePaymentInput struct {
+	StepOptions
+}
+
+// MakeDoughInput is the input to the MakeDough update
+type MakeDoughInput struct {
+	StepOptions
+	DoughType string `json:"dough_type"` // e.g. "thin", "thick", "gluten-free"
+}
+
+// ProofDoughInput is the input to the ProofDough update (gourmet only)
+type ProofDoughInput struct {
+	StepOptions
+}
+
+// AddSauceInput is the input to the AddSauce update (sauce-and-cheese only)
+type AddSauceInput struct {
+	StepOptions
+}
+
+// AddCheeseInput is the input to the AddCheese update (sauce-and-cheese only)
+type AddCheeseInput struct {
+	StepOptions
+}
+
+// AddToppingsInput is the input to the AddToppings update
+type AddToppingsInput struct {
+	StepOptions
+	Toppings []string `json:"toppings"`
+}
+
+// BakePizzaInput is the input to the BakePizza update
+type BakePizzaInput struct {
+	StepOptions
+	Temperature int `json:"temperature,omitempty"` // °F, recorded on the BAKE component; 0 leaves the oven as is
+}
+
+// Validate checks the temperature is one the oven can do
+func (in BakePizzaInput) Validate() error {
+	if in.Temperature != 0 && (in.Temperature < MinBakeTemperature || in.Temperature > MaxBakeTemperature) {
+		return fmt.Errorf("temperature must be between %d and %d", MinBakeTemperature, MaxBakeTemperature)
+	}
+	return nil
+}
+
+// DeliverInput is the input to the Deliver update. Without groups the order
+// goes out as a single delivery; a retry re-schedules only the failed groups.
+type DeliverInput struct {
+	StepOptions
+	Groups []types.DeliveryGroup `json:"groups,omitempty"`
+
+	// Overrides for this delivery: the address replaces the order's (groups
+	// with their own address keep it), and estimated_time the drive time in
+	// minutes given to the delivery service (default DeliveryTravelTime)
+	DeliveryAddress string `json:"delivery_address,omitempty"`
+	EstimatedTime   int    `json:"estimated_time,omitempty"`
+}
+
+// Validate checks the overrides: the address must be within delivery range
+// and the drive time positive and at most MaxDeliveryEstimate
+func (in DeliverInput) Validate() error {
+	if in.DeliveryAddress != "" {
+		if km := activities.DistanceForAddress(in.DeliveryAddress); km > activities.MaxDeliveryRadiusKm {
+			return fmt.Errorf("delivery_address is %.1f km away, beyond the %.0f km delivery radius",
+				km, activities.MaxDeliveryRadiusKm)
+		}
+	}
+	if in.EstimatedTime < 0 || in.EstimatedTime > int(MaxDeliveryEstimate.Minutes()) {
+		return fmt.Errorf("estimated_time must be between 1 and %d minutes, or 0 for the default", int(MaxDeliveryEstimate.Minutes()))
+	}
+	return nil
+}
+
+// travelTime is the drive time to give the delivery service
+func (in DeliverInput) travelTime() time.Duration {
+	if in.EstimatedTime > 0 {
+		return time.Duration(in.EstimatedTime) * time.Minute
+	}
+	return DeliveryTravelTime
+}
+
+// PickupReadyInput is the input to the PickupReady update
+type PickupReadyInput struct {
+	StepOptions
+}
+
+// VerifyAgeInput is the input to the VerifyAge update
+type VerifyAgeInput struct {
+	StepOptions
+	VerificationToken string `json:"verification_token"` // From the ID check, e.g. a scanner or third-party service
+}
+
+// UploadProofInput is the input to the UploadProof update
+type UploadProofInput struct {
+	StepOptions
+	PhotoURL string `json:"photo_url"`
+}
+
+// CancelOrderInput is the input to the CancelOrder update
+type CancelOrderInput struct {
+	Reason string `json:"reason"`
+}
+
+// RemoveItemInput is the input to the RemoveItem update
+type RemoveItemInput struct {
+	ItemID string `json:"item_id"`
+	Reason string `json:"reason"`
 }
 
 // PizzaOrderWorkflow is the main Temporal workflow
 // This is the KEY function - it runs in the Temporal worker
 func PizzaOrderWorkflow(ctx workflow.Context, input *PizzaOrderInput) (*types.PizzaOrder, error) {
-	logger := workflow.GetLogger(ctx)
+	logger := orderLogger(ctx)
 	logger.Info("Starting pizza order workflow", "orderID", input.OrderID, "customer", input.CustomerName)
 
+	if err := input.Normalize(); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError("invalid order input", ErrInvalidInput, err)
+	}
+
 	// 1. Initialize the workflow state (THIS IS JUST A REGULAR GO VARIABLE!)
+	dag, err := input.BuildDAG(workflow.Now(ctx)) // Create the component graph
+	if err != nil {
+		return nil, err
+	}
+	if input.ContainsAlcohol {
+		if err := dag.AddAgeVerification(workflow.Now(ctx)); err != nil {
+			return nil, err
+		}
+	}
+
 	state := &types.PizzaOrder{
-		OrderID:         input.OrderID,
-		CustomerName:    input.CustomerName,
-		CustomerEmail:   input.CustomerEmail,
-		CustomerPhone:   input.CustomerPhone,
-		DeliveryAddress: input.DeliveryAddress,
-		State:           types.OrderStateInProgress,
-		DAG:             types.NewPizzaOrderDAG(), // Create the component graph
-		CreateTime:      workflow.Now(ctx),
-		UpdateTime:      workflow.Now(ctx),
+		OrderID:             input.OrderID,
+		CustomerName:        input.CustomerName,
+		CustomerEmail:       input.CustomerEmail,
+		CustomerPhone:       input.CustomerPhone,
+		NotificationPrefs:   types.NotificationPrefs{Channel: input.NotificationPreference},
+		DeliveryAddress:     input.DeliveryAddress,
+		State:               types.OrderStateInProgress,
+		DAG:                 dag,
+		Complexity:          input.Complexity,
+		Fulfillment:         input.Fulfillment,
+		Template:            input.DAGTemplate,
+		Toppings:            input.Toppings,
+		Items:               input.Items,
+		RemakeOf:            input.RemakeOf,
+		DeliveryWindowStart: input.DeliveryWindowStart,
+		DeliveryWindowEnd:   input.DeliveryWindowEnd,
+		RemakeReason:        input.RemakeReason,
+		PaymentSplits:       input.PaymentSplits,
+		ContainsAlcohol:     input.ContainsAlcohol,
+		Subtotal:            input.Amount,
+		TaxRate:             input.TaxRate,
+		CreateTime:          workflow.Now(ctx),
+		UpdateTime:          workflow.Now(ctx),
+
+		RunID: workflow.GetInfo(ctx).WorkflowExecution.RunID,
+	}
+	if input.OrderTimeout > 0 {
+		expiresAt := state.CreateTime.Add(input.OrderTimeout)
+		state.ExpiresAt = &expiresAt
+	}
+
+	if input.Imported != nil {
+		state = importedState(ctx, input.Imported, state)
+	} else {
+		state.Total, state.TaxAmount = types.ComputeTotal(input.Amount, 0, 0, 0, input.TaxRate)
+		state.RecordEvent(workflow.Now(ctx), types.EventOrderCreated, input.CustomerName)
+		recordReadyComponents(ctx, state)
+	}
+
+	// Retry policies with the order's attempt overrides applied
+	paymentPolicy := withMaxAttempts(paymentRetryPolicy, input.PaymentMaxAttempts)
+	deliveryPolicy := withMaxAttempts(deliveryRetryPolicy, input.DeliveryMaxAttempts)
+
+	// Free (comped) orders have nothing to charge, so PAYMENT completes up
+	// front. Imported ones already did.
+	if input.Amount == 0 && input.Imported == nil {
+		state.CompReason = input.CompReason
+		if err := completeComponent(ctx, state, types.ComponentPayment); err != nil {
+			return nil, err
+		}
+		logger.Info("Free order - skipping payment", "reason", input.CompReason)
 	}
 
 	logger.Info("Initial DAG state", "components", state.DAG.GetComponents())
 
 	// 2. Setup Query Handler - allows external systems to READ current state
-	err := workflow.SetQueryHandler(ctx, QueryOrderState, func() (*types.PizzaOrder, error) {
+	err = workflow.SetQueryHandler(ctx, QueryOrderState, func() (*types.PizzaOrder, error) {
 		logger.Info("Query received - returning current state")
 		return state, nil // Just return the current state variable!
 	})
@@ -67,165 +390,1142 @@ func PizzaOrderWorkflow(ctx workflow.Context, input *PizzaOrderInput) (*types.Pi
 		return nil, fmt.Errorf("failed to set query handler: %w", err)
 	}
 
+	// Public query - the same state with customer contact details masked
+	err = workflow.SetQueryHandler(ctx, QueryOrderStatePublic, func() (*types.PizzaOrder, error) {
+		return state.Redacted(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	// Summary query reads the same state but returns only what a list view needs
+	err = workflow.SetQueryHandler(ctx, QueryOrderSummary, func() (*types.OrderSummary, error) {
+		return state.Summary(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	// Step durations query - actual service time per completed component
+	err = workflow.SetQueryHandler(ctx, QueryStepDurations, func() ([]types.StepDuration, error) {
+		return state.DAG.StepDurations(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	err = workflow.SetQueryHandler(ctx, QueryEventLog, func() ([]types.Event, error) {
+		return types.BuildEventLog(state), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	// Component query - one step, for pollers that don't need the whole order
+	err = workflow.SetQueryHandler(ctx, QueryComponentState, func(componentType types.ComponentType) (*types.Component, error) {
+		component, err := state.DAG.GetComponent(componentType)
+		if err != nil {
+			return nil, temporal.NewApplicationError(
+				fmt.Sprintf("component %s is not part of order %s", componentType, state.OrderID),
+				ErrComponentNotFound)
+		}
+		return component, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	// Delivery status query - the latest status reported by the delivery service
+	err = workflow.SetQueryHandler(ctx, QueryDeliveryStatus, func() (string, error) {
+		return state.DeliveryStatus, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set query handler: %w", err)
+	}
+
+	// Delivery fee - priced by distance so PAYMENT charges it. The estimate
+	// runs once every handler is registered, so a step sent straight after the
+	// order is created isn't rejected as unknown; PAYMENT waits for it.
+	// Imported orders keep the fee they were exported with.
+	deliveryPriced := state.Fulfillment != types.FulfillmentDelivery || input.Imported != nil
+	var pricingErr error
+
+	// Signal handler - customers can change notification preferences mid-order.
+	// Later notifications read state.NotificationPrefs, so they use the new channel.
+	prefsCh := workflow.GetSignalChannel(ctx, SignalUpdateNotificationPrefs)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var prefs types.NotificationPrefs
+			prefsCh.Receive(ctx, &prefs)
+			if !types.IsValidNotificationChannel(prefs.Channel) {
+				logger.Warn("Ignoring invalid notification channel", "channel", prefs.Channel)
+				continue
+			}
+			state.NotificationPrefs = prefs
+			state.UpdateTime = workflow.Now(ctx)
+			logger.Info("Notification preferences updated", "channel", prefs.Channel)
+		}
+	})
+
+	// Delivery window - DELIVER is held until a driver leaving now would arrive
+	// inside the window. A timer opens it; orders without a window start open.
+	deliveryWindowOpen := !state.HasDeliveryWindow()
+	if state.HasDeliveryWindow() {
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: DefaultActivityTimeout,
+			})
+			var notifErr error
+			if recipient, ok := notificationRecipient(state); ok {
+				workflow.ExecuteActivity(activityCtx, "SendDeliveryWindowConfirmation",
+					recipient, *state.DeliveryWindowStart, *state.DeliveryWindowEnd).Get(activityCtx, &notifErr)
+				// Ignore notification errors - not critical
+			}
+
+			dispatchAt := state.DeliveryWindowStart.Add(-DeliveryTravelTime)
+			if wait := dispatchAt.Sub(workflow.Now(ctx)); wait > 0 {
+				if err := workflow.Sleep(ctx, wait); err != nil {
+					return
+				}
+			}
+			deliveryWindowOpen = true
+			logger.Info("Delivery window open", "start", state.DeliveryWindowStart, "end", state.DeliveryWindowEnd)
+		})
+	}
+
 	// 3. Setup Update Handlers - allows external systems to MODIFY state
 	// Each update handler modifies the state variable and returns it
 	// Temporal automatically stores the returned state!
+	// Step handlers are wrapped to dedupe idempotent requests and cap repeated failures.
+	guard := newStepGuard(state)
+
+	// Saga - steps with side effects push a compensation, and failOrder runs
+	// them newest first. The apology is pushed first so it goes out last,
+	// once the refund is known.
+	var saga compensations
+	compensating := false // Set while failOrder runs, so the workflow waits for it
+	saga.push("apologize to customer", func(ctx workflow.Context) error {
+		recipient, ok := contactRecipient(state)
+		if !ok {
+			return nil
+		}
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: DefaultActivityTimeout,
+		})
+		return workflow.ExecuteActivity(activityCtx, "SendOrderFailedNotification",
+			recipient, state.OrderID, state.RefundAmount).Get(activityCtx, nil)
+	})
+
+	// Only an imported order can have been charged already; refund it like
+	// a payment made here
+	if state.PaymentAmount > 0 {
+		saga.push("refund payment", func(ctx workflow.Context) error {
+			return refundPayment(workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: stepActivityTimeout(state, types.ComponentPayment),
+				RetryPolicy:         paymentPolicy,
+			}), state)
+		})
+	}
+
+	// failTerminally fails the order once a step can't succeed, running the
+	// saga. The workflow waits on compensating before it closes.
+	failTerminally := func(ctx workflow.Context, reason string) {
+		compensating = true
+		failOrder(ctx, state, saga, reason)
+		compensating = false
+	}
 
-	err = workflow.SetUpdateHandler(ctx, UpdateCompletePayment, func() (*types.PizzaOrder, error) {
+	completePayment := guardStep(guard, types.ComponentPayment, func(ctx workflow.Context, stepInput CompletePaymentInput) (*types.PizzaOrder, error) {
+		if err := workflow.Await(ctx, func() bool { return deliveryPriced || pricingErr != nil }); err != nil {
+			return nil, err
+		}
+		if pricingErr != nil {
+			return nil, activityFailure("delivery fee estimate failed", pricingErr, ErrDeliveryUnavailable)
+		}
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
 		logger.Info("Processing payment - calling payment gateway activity")
 
 		// Configure activity options (timeout, retry policy, etc.)
 		activityOptions := workflow.ActivityOptions{
-			StartToCloseTimeout: 30 * time.Second,
-			RetryPolicy: &temporal.RetryPolicy{
-				MaximumAttempts: 3,
-			},
+			StartToCloseTimeout: stepActivityTimeout(state, types.ComponentPayment),
+			RetryPolicy:         paymentPolicy,
 		}
 		activityCtx := workflow.WithActivityOptions(ctx, activityOptions)
 
-		// Call payment activity (non-deterministic operation!)
-		paymentInput := activities.PaymentInput{
-			OrderID:      state.OrderID,
-			CustomerName: state.CustomerName,
-			Amount:       input.Amount,
-		}
-
+		// Call the payment activity the DAG names (non-deterministic operation!)
 		var paymentResult activities.PaymentResult
-		err := workflow.ExecuteActivity(activityCtx, "ProcessPayment", paymentInput).Get(activityCtx, &paymentResult)
+		var charged bool
+		var err error
+		if len(state.PaymentSplits) > 0 {
+			charged, err = chargeSplits(activityCtx, state, &paymentResult)
+		} else {
+			charged, err = runStepActivity(activityCtx, state, types.ComponentPayment, &paymentResult)
+		}
 		if err != nil {
 			logger.Error("Payment failed", "error", err)
-			return nil, fmt.Errorf("payment processing failed: %w", err)
+			return nil, activityFailure("payment processing failed", err, ErrPaymentDeclined)
 		}
 
 		// Store payment result
-		state.PaymentTxnID = paymentResult.TransactionID
-		state.PaymentAmount = paymentResult.Amount
+		if charged {
+			state.PaymentTxnID = paymentResult.TransactionID
+			state.PaymentAmount = paymentResult.Amount
+			saga.push("refund payment", func(ctx workflow.Context) error {
+				return refundPayment(workflow.WithActivityOptions(ctx, activityOptions), state)
+			})
+		}
 
 		// Send confirmation notification
 		var notifErr error
-		workflow.ExecuteActivity(activityCtx, "SendOrderConfirmation",
-			state.OrderID, state.CustomerName, state.CustomerEmail).Get(activityCtx, &notifErr)
-		// Ignore notification errors - not critical
+		if recipient, ok := notificationRecipient(state); ok {
+			workflow.ExecuteActivity(activityCtx, "SendOrderConfirmation",
+				recipient, state.OrderID).Get(activityCtx, &notifErr)
+			// Ignore notification errors - not critical
+		}
 
-		if err := state.DAG.CompleteComponent(types.ComponentPayment); err != nil {
+		if err := completeComponent(ctx, state, types.ComponentPayment); err != nil {
 			return nil, err
 		}
 		state.UpdateTime = workflow.Now(ctx)
 		logger.Info("Payment completed", "txnID", paymentResult.TransactionID, "nextComponent", state.DAG.GetNextComponent())
 		return state, nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	err = workflow.SetUpdateHandler(ctx, UpdateMakeDough, func() (*types.PizzaOrder, error) {
-		logger.Info("Processing make dough")
-		if err := state.DAG.CompleteComponent(types.ComponentMakeDough); err != nil {
+	makeDough := guardStep(guard, types.ComponentMakeDough, func(ctx workflow.Context, stepInput MakeDoughInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing make dough", "doughType", stepInput.DoughType)
+		if err := completeWithActivity(ctx, state, types.ComponentMakeDough); err != nil {
 			return nil, err
 		}
+		state.DoughType = stepInput.DoughType
 		state.UpdateTime = workflow.Now(ctx)
 		logger.Info("Dough made", "nextComponent", state.DAG.GetNextComponent())
 		return state, nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	err = workflow.SetUpdateHandler(ctx, UpdateAddToppings, func() (*types.PizzaOrder, error) {
-		logger.Info("Processing add toppings")
-		if err := state.DAG.CompleteComponent(types.ComponentAddToppings); err != nil {
+	proofDough := guardStep(guard, types.ComponentProofDough, func(ctx workflow.Context, stepInput ProofDoughInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing proof dough")
+		if err := completeWithActivity(ctx, state, types.ComponentProofDough); err != nil {
 			return nil, err
 		}
 		state.UpdateTime = workflow.Now(ctx)
+		logger.Info("Dough proofed", "nextComponent", state.DAG.GetNextComponent())
+		return state, nil
+	})
+
+	addSauce := guardStep(guard, types.ComponentAddSauce, func(ctx workflow.Context, stepInput AddSauceInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing add sauce")
+		if err := completeWithActivity(ctx, state, types.ComponentAddSauce); err != nil {
+			return nil, err
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		logger.Info("Sauce added", "nextComponent", state.DAG.GetNextComponent())
+		return state, nil
+	})
+
+	addCheese := guardStep(guard, types.ComponentAddCheese, func(ctx workflow.Context, stepInput AddCheeseInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing add cheese")
+		if err := completeWithActivity(ctx, state, types.ComponentAddCheese); err != nil {
+			return nil, err
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		logger.Info("Cheese added", "nextComponent", state.DAG.GetNextComponent())
+		return state, nil
+	})
+
+	addToppings := guardStep(guard, types.ComponentAddToppings, func(ctx workflow.Context, stepInput AddToppingsInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing add toppings", "toppings", stepInput.Toppings)
+		if err := completeWithActivity(ctx, state, types.ComponentAddToppings); err != nil {
+			return nil, err
+		}
+		if len(stepInput.Toppings) > 0 {
+			state.Toppings = stepInput.Toppings
+		}
+		state.UpdateTime = workflow.Now(ctx)
 		logger.Info("Toppings added", "nextComponent", state.DAG.GetNextComponent())
 		return state, nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	err = workflow.SetUpdateHandler(ctx, UpdateBakePizza, func() (*types.PizzaOrder, error) {
-		logger.Info("Processing bake pizza")
-		if err := state.DAG.CompleteComponent(types.ComponentBakePizza); err != nil {
+	bakePizza := guardStep(guard, types.ComponentBakePizza, func(ctx workflow.Context, stepInput BakePizzaInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing bake pizza", "temperature", stepInput.Temperature)
+		if stepInput.Temperature != 0 {
+			err := state.DAG.SetParameter(types.ComponentBakePizza, "temperature", strconv.Itoa(stepInput.Temperature))
+			if err != nil {
+				return nil, dagFailure(err)
+			}
+		}
+		var err error
+		if state.Complexity == types.ComplexityGourmet {
+			err = bakeWithChild(ctx, state) // Multi-stage bake, see GourmetBakeWorkflow
+		} else {
+			err = completeWithActivity(ctx, state, types.ComponentBakePizza)
+		}
+		if err != nil {
+			// The bake activity has already been retried; a pizza that
+			// can't be baked won't be delivered, so refund the customer
+			if terminalFailure(err) {
+				failTerminally(ctx, fmt.Sprintf("%s failed: %v", types.ComponentBakePizza, err))
+			}
 			return nil, err
 		}
 		state.UpdateTime = workflow.Now(ctx)
 		logger.Info("Pizza baked", "nextComponent", state.DAG.GetNextComponent())
 		return state, nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	err = workflow.SetUpdateHandler(ctx, UpdateDeliver, func() (*types.PizzaOrder, error) {
+	deliveryFailed := false // Set once scheduling fails, starting automatic retries
+	deliver := guardStep(guard, types.ComponentDeliver, func(ctx workflow.Context, stepInput DeliverInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		if err := checkDeliveryWindow(state, deliveryWindowOpen); err != nil {
+			return nil, err
+		}
 		logger.Info("Processing delivery - calling delivery service activity")
 
 		activityOptions := workflow.ActivityOptions{
-			StartToCloseTimeout: 30 * time.Second,
-			RetryPolicy: &temporal.RetryPolicy{
-				MaximumAttempts: 3,
-			},
+			StartToCloseTimeout: stepActivityTimeout(state, types.ComponentDeliver),
+			RetryPolicy:         deliveryPolicy,
 		}
 		activityCtx := workflow.WithActivityOptions(ctx, activityOptions)
 
-		// Call delivery activity (non-deterministic operation!)
-		deliveryInput := activities.DeliveryInput{
-			OrderID:         state.OrderID,
-			CustomerName:    state.CustomerName,
-			DeliveryAddress: state.DeliveryAddress,
-			EstimatedTime:   30, // 30 minutes
+		// A DAG whose DELIVER names no activity has nothing to schedule
+		activityName := stepActivityName(state, types.ComponentDeliver)
+		if activityName == "" {
+			if err := completeComponent(ctx, state, types.ComponentDeliver); err != nil {
+				return nil, err
+			}
+			state.UpdateTime = workflow.Now(ctx)
+			return state, nil
 		}
 
-		var deliveryResult activities.DeliveryResult
-		err := workflow.ExecuteActivity(activityCtx, "ScheduleDelivery", deliveryInput).Get(activityCtx, &deliveryResult)
+		previousAddress := state.DeliveryAddress
+		if stepInput.DeliveryAddress != "" && stepInput.DeliveryAddress != state.DeliveryAddress {
+			logger.Info("Delivery address overridden", "from", state.DeliveryAddress, "to", stepInput.DeliveryAddress)
+			state.DeliveryAddress = stepInput.DeliveryAddress
+			if err := priceDelivery(ctx, state); err != nil {
+				return nil, activityFailure("delivery fee estimate failed", err, ErrDeliveryUnavailable)
+			}
+		}
+		groups, err := deliveryGroups(state, stepInput.Groups, previousAddress)
 		if err != nil {
-			logger.Error("Delivery scheduling failed", "error", err)
-			return nil, fmt.Errorf("delivery scheduling failed: %w", err)
+			return nil, err
+		}
+
+		// Schedule every group that doesn't have a driver yet in parallel,
+		// each escalating to a wider driver pool when nobody is available
+		type scheduleOutcome struct {
+			input  activities.DeliveryInput
+			result activities.DeliveryResult
+			err    error
+		}
+		outcomes := make(map[int]*scheduleOutcome)
+		wg := workflow.NewWaitGroup(ctx)
+		for i := range groups {
+			group := &groups[i]
+			if group.Scheduled() {
+				continue
+			}
+			// Call delivery activity (non-deterministic operation!)
+			outcome := &scheduleOutcome{input: activities.DeliveryInput{
+				OrderID:         state.OrderID,
+				CustomerName:    state.CustomerName,
+				DeliveryAddress: group.DeliveryAddress,
+				Zone:            activities.ZoneForAddress(group.DeliveryAddress),
+				EstimatedTime:   int(stepInput.travelTime().Minutes()),
+				EscalationLevel: group.EscalationLevel, // Resume where an earlier attempt stopped
+				WindowStart:     state.DeliveryWindowStart,
+				WindowEnd:       state.DeliveryWindowEnd,
+			}}
+			group.Zone = outcome.input.Zone
+			outcomes[i] = outcome
+
+			wg.Add(1)
+			workflow.Go(ctx, func(ctx workflow.Context) {
+				defer wg.Done()
+				activityCtx := workflow.WithActivityOptions(ctx, activityOptions)
+				outcome.err = scheduleWithEscalation(activityCtx, activityName, &outcome.input, &outcome.result)
+			})
+		}
+		wg.Wait(ctx)
+
+		var failed int
+		var lastErr error
+		for i := range groups {
+			outcome, ok := outcomes[i]
+			if !ok {
+				continue
+			}
+			group := &groups[i]
+			group.EscalationLevel = outcome.input.EscalationLevel
+			state.DeliveryEscalation = max(state.DeliveryEscalation, group.EscalationLevel)
+			deliveryResult := outcome.result
+			if err := outcome.err; err != nil {
+				logger.Error("Delivery scheduling failed", "group", group.Group, "escalation", group.EscalationLevel, "error", err)
+				group.Status = types.DeliveryStatusFailed
+				group.Error = err.Error()
+				failed++
+				lastErr = err
+				continue
+			}
+
+			// The ETA comes from the activity's wall clock. If clock skew or a stale
+			// retry put it in the past, re-anchor it on workflow time.
+			now := workflow.Now(ctx)
+			if deliveryResult.EstimatedArrival.Before(now) {
+				eta := now.Add(time.Duration(outcome.input.EstimatedTime) * time.Minute)
+				logger.Warn("Delivery ETA was in the past, recomputing", "reported", deliveryResult.EstimatedArrival, "recomputed", eta)
+				state.Warnings = append(state.Warnings, fmt.Sprintf(
+					"delivery ETA %s was in the past; recomputed as %s",
+					deliveryResult.EstimatedArrival.Format(time.RFC3339), eta.Format(time.RFC3339)))
+				deliveryResult.EstimatedArrival = eta
+			}
+
+			group.DeliveryID = deliveryResult.DeliveryID
+			group.DriverName = deliveryResult.DriverName
+			group.TrackingURL = deliveryResult.TrackingURL
+			group.Status = deliveryResult.Status
+			group.EstimatedArrival = &deliveryResult.EstimatedArrival
+			group.Error = ""
+
+			// Send delivery notification
+			var notifErr error
+			if recipient, ok := notificationRecipient(state); ok {
+				workflow.ExecuteActivity(activityCtx, "SendDeliveryNotification",
+					recipient, deliveryResult.DriverName, deliveryResult.EstimatedArrival).Get(activityCtx, &notifErr)
+				// Ignore notification errors - not critical
+			}
+			logger.Info("Delivery scheduled", "group", group.Group, "deliveryID", deliveryResult.DeliveryID, "driver", deliveryResult.DriverName)
 		}
 
-		// Store delivery result
-		state.DeliveryID = deliveryResult.DeliveryID
-		state.DriverName = deliveryResult.DriverName
-		state.TrackingURL = deliveryResult.TrackingURL
-		state.EstimatedArrival = &deliveryResult.EstimatedArrival
+		// Per-group status is kept even on failure, so a retry only
+		// re-schedules the groups that didn't get a driver
+		state.Deliveries = groups
+		primary := groups[0]
+		state.DeliveryZone = primary.Zone
+		state.DeliveryID = primary.DeliveryID
+		state.DriverName = primary.DriverName
+		state.TrackingURL = primary.TrackingURL
+		state.DeliveryStatus = primary.Status
+		state.EstimatedArrival = primary.EstimatedArrival
+		state.UpdateTime = workflow.Now(ctx)
+
+		if failed > 0 {
+			err := activityFailure(
+				fmt.Sprintf("delivery scheduling failed for %d of %d groups", failed, len(groups)),
+				lastErr, ErrDeliveryUnavailable)
+			// DELIVER stays ready for a manual retry or driver assignment; only
+			// automatic retries running out gives up on the order
+			deliveryFailed = true
+			return nil, err
+		}
 
-		// Send delivery notification
+		// A cancel that landed while drivers were being scheduled wins
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		if err := completeComponent(ctx, state, types.ComponentDeliver); err != nil {
+			return nil, err
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		return state, nil
+	})
+
+	// Automatic delivery retries - once scheduling fails, retry DELIVER on a timer
+	// instead of waiting for a re-POST. A manual retry or driver assignment that
+	// completes DELIVER in the meantime ends the loop early.
+	if input.DeliveryRetryAttempts > 0 {
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			if err := workflow.Await(ctx, func() bool { return deliveryFailed }); err != nil {
+				return
+			}
+			for state.DeliveryRetries < input.DeliveryRetryAttempts {
+				delivered, err := workflow.AwaitWithTimeout(ctx, input.DeliveryRetryInterval, func() bool {
+					return guard.completed(types.ComponentDeliver)
+				})
+				if err != nil || delivered || checkOrderActive(state) != nil {
+					return
+				}
+				state.DeliveryRetries++
+				logger.Info("Retrying delivery", "attempt", state.DeliveryRetries, "of", input.DeliveryRetryAttempts)
+				if _, err := deliver(ctx, DeliverInput{}); err == nil {
+					return
+				}
+			}
+			if guard.completed(types.ComponentDeliver) {
+				return
+			}
+
+			// Out of retries, the order can't be fulfilled: fail it, refunding
+			// the customer. Steps still running finish first, and may end it.
+			logger.Warn("Delivery retries exhausted", "attempts", state.DeliveryRetries)
+			if err := workflow.Await(ctx, func() bool { return len(guard.running) == 0 }); err != nil {
+				return
+			}
+			if checkOrderActive(state) != nil || guard.completed(types.ComponentDeliver) {
+				return
+			}
+			failTerminally(ctx, fmt.Sprintf(
+				"delivery could not be scheduled after %d automatic retries", state.DeliveryRetries))
+		})
+	}
+
+	// Delivery tracking - poll the delivery service until the order arrives.
+	// The loop is cancelled when the workflow returns, completed or cancelled.
+	if state.Fulfillment == types.FulfillmentDelivery {
+		trackingCtx, stopTracking := workflow.WithCancel(ctx)
+		defer stopTracking()
+		workflow.Go(trackingCtx, func(ctx workflow.Context) {
+			trackDelivery(ctx, state, input.DeliveryPollInterval)
+		})
+	}
+
+	uploadProof := guardStep(guard, types.ComponentPhotoProof, func(ctx workflow.Context, stepInput UploadProofInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing photo proof of delivery", "photoURL", stepInput.PhotoURL)
+		if stepInput.PhotoURL == "" {
+			return nil, fmt.Errorf("photo_url is required")
+		}
+		if err := completeComponent(ctx, state, types.ComponentPhotoProof); err != nil {
+			return nil, err
+		}
+		state.ProofPhotoURL = stepInput.PhotoURL
+
+		// Send "delivered" notification with the proof link
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: DefaultActivityTimeout,
+		})
 		var notifErr error
-		workflow.ExecuteActivity(activityCtx, "SendDeliveryNotification",
-			state.CustomerName, deliveryResult.DriverName, deliveryResult.EstimatedArrival).Get(activityCtx, &notifErr)
-		// Ignore notification errors - not critical
+		if recipient, ok := notificationRecipient(state); ok {
+			workflow.ExecuteActivity(activityCtx, "SendDeliveredNotification",
+				recipient, state.ProofPhotoURL).Get(activityCtx, &notifErr)
+			// Ignore notification errors - not critical
+		}
+
+		state.UpdateTime = workflow.Now(ctx)
+		logger.Info("Proof of delivery uploaded")
+		return state, nil
+	})
 
-		if err := state.DAG.CompleteComponent(types.ComponentDeliver); err != nil {
+	// Orders containing alcohol can't be handed over until the customer's age
+	// is verified; DELIVER and PICKUP_READY depend on this step
+	verifyAge := guardStep(guard, types.ComponentAgeVerification, func(ctx workflow.Context, stepInput VerifyAgeInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		if stepInput.VerificationToken == "" {
+			return nil, fmt.Errorf("verification_token is required")
+		}
+		if err := completeComponent(ctx, state, types.ComponentAgeVerification); err != nil {
+			return nil, err
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		logger.Info("Customer age verified")
+		return state, nil
+	})
+
+	// Pickup orders end here instead of at DELIVER: no driver, just a heads-up
+	pickupReady := guardStep(guard, types.ComponentPickupReady, func(ctx workflow.Context, stepInput PickupReadyInput) (*types.PizzaOrder, error) {
+		if err := checkOrderActive(state); err != nil {
+			return nil, err
+		}
+		logger.Info("Processing pickup ready")
+		if err := completeWithActivity(ctx, state, types.ComponentPickupReady); err != nil {
 			return nil, err
 		}
+
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: DefaultActivityTimeout,
+		})
+		var notifErr error
+		if recipient, ok := notificationRecipient(state); ok {
+			workflow.ExecuteActivity(activityCtx, "SendPickupReadyNotification",
+				recipient, state.OrderID).Get(activityCtx, &notifErr)
+			// Ignore notification errors - not critical
+		}
+
 		state.UpdateTime = workflow.Now(ctx)
-		logger.Info("Delivery scheduled", "deliveryID", deliveryResult.DeliveryID, "driver", deliveryResult.DriverName)
+		logger.Info("Order ready for pickup")
 		return state, nil
 	})
+
+	// Signal handler - dispatchers can assign a driver by hand, overriding the
+	// delivery service. Waits out an in-flight DELIVER update so they don't race.
+	assignCh := workflow.GetSignalChannel(ctx, SignalAssignDriver)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var assignment DriverAssignment
+			assignCh.Receive(ctx, &assignment)
+			if err := workflow.Await(ctx, func() bool { return !guard.running[types.ComponentDeliver] }); err != nil {
+				return
+			}
+			if err := assignDriver(ctx, state, assignment); err != nil {
+				logger.Warn("Ignoring driver assignment", "driver", assignment.DriverName, "error", err)
+			}
+		}
+	})
+
+	// Signal handler - dispatchers can tighten or loosen the order's SLA. A timer
+	// flags the order as breached if it's still in progress at the deadline; a
+	// new target cancels that timer and starts another.
+	slaCh := workflow.GetSignalChannel(ctx, SignalSetOrderSLA)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		var slaTimer workflow.Future
+		cancelTimer := func() {}
+		for {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(slaCh, func(c workflow.ReceiveChannel, more bool) {
+				var sla OrderSLA
+				c.Receive(ctx, &sla)
+				if sla.Target <= 0 {
+					logger.Warn("Ignoring non-positive SLA", "target", sla.Target)
+					return
+				}
+
+				cancelTimer()
+				slaTimer = nil
+				now := workflow.Now(ctx)
+				deadline := state.CreateTime.Add(sla.Target)
+				state.SLADeadline = &deadline
+				state.SLABreached = !deadline.After(now) && state.State == types.OrderStateInProgress
+				state.UpdateTime = now
+				logger.Info("Order SLA set", "target", sla.Target, "deadline", deadline)
+
+				if deadline.After(now) {
+					timerCtx, cancel := workflow.WithCancel(ctx)
+					cancelTimer = cancel
+					slaTimer = workflow.NewTimer(timerCtx, deadline.Sub(now))
+				}
+			})
+			if slaTimer != nil {
+				selector.AddFuture(slaTimer, func(f workflow.Future) {
+					slaTimer = nil
+					if f.Get(ctx, nil) != nil {
+						return // Cancelled by a newer target
+					}
+					if state.State == types.OrderStateInProgress {
+						state.SLABreached = true
+						state.Warnings = append(state.Warnings, fmt.Sprintf(
+							"SLA breached: order not complete by %s", state.SLADeadline.Format(time.RFC3339)))
+						state.UpdateTime = workflow.Now(ctx)
+						logger.Warn("Order SLA breached", "deadline", state.SLADeadline)
+					}
+				})
+			}
+			selector.Select(ctx)
+		}
+	})
+
+	// Signal handler - the gourmet bake child workflow reports each stage here,
+	// so QueryOrderState shows its progress while BAKE is running
+	bakeProgressCh := workflow.GetSignalChannel(ctx, SignalBakeProgress)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var progress types.StepProgress
+			bakeProgressCh.Receive(ctx, &progress)
+			state.BakeProgress = &progress
+			state.UpdateTime = workflow.Now(ctx)
+		}
+	})
+
+	// Register each step under its update name, with a validator
+	if err := registerStep(ctx, UpdateCompletePayment, types.ComponentPayment, state, completePayment); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateMakeDough, types.ComponentMakeDough, state, makeDough); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateProofDough, types.ComponentProofDough, state, proofDough); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateAddSauce, types.ComponentAddSauce, state, addSauce); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateAddCheese, types.ComponentAddCheese, state, addCheese); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateAddToppings, types.ComponentAddToppings, state, addToppings); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateBakePizza, types.ComponentBakePizza, state, bakePizza); err != nil {
+		return nil, err
+	}
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateDeliver, deliver, workflow.UpdateHandlerOptions{
+		Validator: func(stepInput DeliverInput) error {
+			if err := stepInput.Validate(); err != nil {
+				return temporal.NewApplicationError(err.Error(), ErrInvalidInput)
+			}
+			if err := checkStepReady(state, types.ComponentDeliver); err != nil {
+				return err
+			}
+			return checkDeliveryWindow(state, deliveryWindowOpen)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdatePickupReady, types.ComponentPickupReady, state, pickupReady); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateUploadProof, types.ComponentPhotoProof, state, uploadProof); err != nil {
+		return nil, err
+	}
+	if err := registerStep(ctx, UpdateVerifyAge, types.ComponentAgeVerification, state, verifyAge); err != nil {
+		return nil, err
+	}
+	customSteps, err := registerCustomSteps(ctx, guard, state)
 	if err != nil {
 		return nil, err
 	}
 
+	// Steps that call activities can be re-invoked by RetryComponent.
+	// Retries carry no step data - the original request already failed before storing any.
+	retryableSteps := map[types.ComponentType]func(workflow.Context) (*types.PizzaOrder, error){
+		types.ComponentPayment: func(ctx workflow.Context) (*types.PizzaOrder, error) {
+			return completePayment(ctx, CompletePaymentInput{})
+		},
+		types.ComponentDeliver: func(ctx workflow.Context) (*types.PizzaOrder, error) { return deliver(ctx, DeliverInput{}) },
+	}
+
+	// RetryComponent re-runs the step handler for a component that is ready but
+	// not yet completed (e.g. its payment or delivery activity failed).
+	// Unlike a reset, it never reverts completed work.
+	checkRetryable := func(componentType types.ComponentType) error {
+		if err := checkStepReady(state, componentType); err != nil {
+			return err
+		}
+		component, _ := state.DAG.GetComponent(componentType)
+		if component.State != types.StateIncomplete {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("component %s cannot be retried (current: %s)", componentType, component.State),
+				ErrComponentNotReady)
+		}
+		if _, ok := retryableSteps[componentType]; !ok {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("component %s has no activity to retry", componentType),
+				ErrComponentNotRetryable)
+		}
+		return nil
+	}
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateRetryComponent, func(ctx workflow.Context, componentType types.ComponentType) (*types.PizzaOrder, error) {
+		if err := checkRetryable(componentType); err != nil {
+			return nil, err
+		}
+
+		retries, err := state.DAG.IncrementRetryCount(componentType)
+		if err != nil {
+			return nil, dagFailure(err)
+		}
+		logger.Info("Retrying component", "component", componentType, "attempt", retries)
+		state.RecordEvent(workflow.Now(ctx), types.EventComponentRetried,
+			fmt.Sprintf("%s, retry %d", componentType, retries))
+		return retryableSteps[componentType](ctx)
+	}, workflow.UpdateHandlerOptions{Validator: checkRetryable})
+	if err != nil {
+		return nil, err
+	}
+
+	// ResetComponent reopens a completed step so it can be redone, e.g. after
+	// wrong toppings. Steps with an activity (charging, dispatching a driver)
+	// can't be redone this way, and neither can steps of a fulfilled order.
+	checkResettable := func(componentType types.ComponentType) error {
+		if err := checkOrderActive(state); err != nil {
+			return err
+		}
+		if state.IsDone() {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("order %s is already fulfilled", state.OrderID),
+				ErrOrderNotActive)
+		}
+		component, err := state.DAG.GetComponent(componentType)
+		if err != nil {
+			return temporal.NewApplicationError(err.Error(), ErrComponentNotFound)
+		}
+		if component.State != types.StateCompleted {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("component %s is not completed (current: %s)", componentType, component.State),
+				ErrStepNotResettable)
+		}
+		if component.OnCompleteActivity != "" {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("component %s runs %s and can't be redone", componentType, component.OnCompleteActivity),
+				ErrStepNotResettable)
+		}
+		return nil
+	}
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateResetComponent, func(ctx workflow.Context, componentType types.ComponentType) (*types.PizzaOrder, error) {
+		if err := checkResettable(componentType); err != nil {
+			return nil, err
+		}
+
+		if err := state.DAG.ResetComponentAt(componentType, workflow.Now(ctx)); err != nil {
+			return nil, temporal.NewApplicationError(err.Error(), ErrStepNotResettable)
+		}
+		state.UpdateTime = workflow.Now(ctx)
+		state.RecordEvent(state.UpdateTime, types.EventComponentReset, string(componentType))
+		logger.Info("Component reset", "component", componentType)
+		return state, nil
+	}, workflow.UpdateHandlerOptions{Validator: checkResettable})
+	if err != nil {
+		return nil, err
+	}
+
+	// RemoveItem takes an unbaked item off a multi-item order. The shared
+	// DELIVER then waits only for the other items, and a paid order gets the
+	// difference back. Split payments were sized to the old total, so their
+	// items stay.
+	findItem := func(itemID string) (int, bool) {
+		for i, item := range state.Items {
+			if item.ID == strings.ToUpper(itemID) {
+				return i, true
+			}
+		}
+		return -1, false
+	}
+	checkRemovable := func(removeInput RemoveItemInput) error {
+		if err := checkOrderActive(state); err != nil {
+			return err
+		}
+		i, ok := findItem(removeInput.ItemID)
+		if !ok {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("item %s is not part of order %s", removeInput.ItemID, state.OrderID),
+				ErrItemNotFound)
+		}
+		if len(state.PaymentSplits) > 0 {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("order %s has split payments; cancel it instead", state.OrderID),
+				ErrItemNotRemovable)
+		}
+		bake, err := state.DAG.GetComponent(types.ItemBakeComponent(state.Items[i].ID))
+		if err == nil && bake.State == types.StateCompleted {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("item %s is already baked", state.Items[i].ID),
+				ErrItemNotRemovable)
+		}
+		if len(state.Items) == 1 {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("item %s is the last one in order %s; cancel the order instead", state.Items[i].ID, state.OrderID),
+				ErrItemNotRemovable)
+		}
+		return nil
+	}
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateRemoveItem, func(ctx workflow.Context, removeInput RemoveItemInput) (*types.PizzaOrder, error) {
+		// Let a charge, or a step of the item, that's in flight finish first:
+		// the charge must be refunded from its result, and a finished bake
+		// means the item stays
+		i, _ := findItem(removeInput.ItemID)
+		if i >= 0 {
+			itemID := state.Items[i].ID
+			err := workflow.Await(ctx, func() bool {
+				return !guard.running[types.ComponentPayment] &&
+					!guard.running[types.ItemPrepComponent(itemID)] && !guard.running[types.ItemBakeComponent(itemID)]
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := checkRemovable(removeInput); err != nil {
+			return nil, err
+		}
+		i, _ = findItem(removeInput.ItemID)
+		item := state.Items[i]
+
+		waiting := map[types.ComponentType]bool{}
+		for _, c := range state.DAG.GetComponents() {
+			if c.State == types.StateNeedsInit {
+				waiting[c.Type] = true
+			}
+		}
+		now := workflow.Now(ctx)
+		if err := state.DAG.RemoveItem(item.ID, now); err != nil {
+			if errors.Is(err, types.ErrItemAlreadyBaked) || errors.Is(err, types.ErrLastItem) {
+				return nil, temporal.NewApplicationError(err.Error(), ErrItemNotRemovable)
+			}
+			return nil, dagFailure(err)
+		}
+		state.Items = append(state.Items[:i:i], state.Items[i+1:]...)
+
+		previousTotal := state.Total
+		state.Subtotal = math.Round((state.Subtotal-item.Amount)*100) / 100
+		state.Total, state.TaxAmount = types.ComputeTotal(state.Subtotal, state.DeliveryFee, 0, 0, state.TaxRate)
+		state.UpdateTime = now
+		message := item.ID
+		if removeInput.Reason != "" {
+			message += ": " + removeInput.Reason
+		}
+		state.RecordEvent(now, types.EventItemRemoved, message)
+		for _, c := range state.DAG.GetReadyComponents() {
+			if waiting[c.Type] {
+				state.RecordEvent(now, types.EventComponentReady, string(c.Type))
+			}
+		}
+
+		// A refund that fails is left for an operator, like a failed split
+		// refund: the item is off the order either way
+		refund := math.Round((previousTotal-state.Total)*100) / 100
+		if state.PaymentTxnID != "" && refund > 0 {
+			activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: stepActivityTimeout(state, types.ComponentPayment),
+				RetryPolicy:         paymentPolicy,
+			})
+			err := workflow.ExecuteActivity(activityCtx, "RefundPartialPayment", state.PaymentTxnID, refund).Get(activityCtx, nil)
+			if err != nil {
+				logger.Error("Failed to refund removed item", "item", item.ID, "error", err)
+				state.Warnings = append(state.Warnings, fmt.Sprintf(
+					"refund of %.2f for removed item %s failed: %v", refund, item.ID, err))
+			} else {
+				state.RefundAmount = math.Round((state.RefundAmount+refund)*100) / 100
+			}
+		}
+		logger.Info("Item removed", "item", item.ID, "total", state.Total, "refund", state.RefundAmount)
+		return state, nil
+	}, workflow.UpdateHandlerOptions{Validator: checkRemovable})
+	if err != nil {
+		return nil, err
+	}
+
+	// Auto-advance runs every step that needs no input from a person; photo
+	// proof and age verification still wait for their update
+	if input.AutoAdvance {
+		autoSteps := map[types.ComponentType]func(workflow.Context) (*types.PizzaOrder, error){
+			types.ComponentPayment: func(ctx workflow.Context) (*types.PizzaOrder, error) {
+				return completePayment(ctx, CompletePaymentInput{})
+			},
+			types.ComponentMakeDough:   func(ctx workflow.Context) (*types.PizzaOrder, error) { return makeDough(ctx, MakeDoughInput{}) },
+			types.ComponentProofDough:  func(ctx workflow.Context) (*types.PizzaOrder, error) { return proofDough(ctx, ProofDoughInput{}) },
+			types.ComponentAddSauce:    func(ctx workflow.Context) (*types.PizzaOrder, error) { return addSauce(ctx, AddSauceInput{}) },
+			types.ComponentAddCheese:   func(ctx workflow.Context) (*types.PizzaOrder, error) { return addCheese(ctx, AddCheeseInput{}) },
+			types.ComponentAddToppings: func(ctx workflow.Context) (*types.PizzaOrder, error) { return addToppings(ctx, AddToppingsInput{}) },
+			types.ComponentBakePizza:   func(ctx workflow.Context) (*types.PizzaOrder, error) { return bakePizza(ctx, BakePizzaInput{}) },
+			types.ComponentDeliver: func(ctx workflow.Context) (*types.PizzaOrder, error) {
+				if err := checkDeliveryWindow(state, deliveryWindowOpen); err != nil {
+					return nil, err
+				}
+				return deliver(ctx, DeliverInput{})
+			},
+			types.ComponentPickupReady: func(ctx workflow.Context) (*types.PizzaOrder, error) { return pickupReady(ctx, PickupReadyInput{}) },
+		}
+		for componentType, handler := range customSteps {
+			autoSteps[componentType] = handler
+		}
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			autoAdvance(ctx, state, autoSteps, input.StepDelay)
+		})
+	}
+
+	// CancelOrder refunds whatever was charged and stops the order. Orders that
+	// are finished, or whose required steps are all done, can't be cancelled.
+	checkCancellable := func(CancelOrderInput) error {
+		if err := checkOrderActive(state); err != nil {
+			return err
+		}
+		if state.IsDone() {
+			return temporal.NewApplicationError(
+				fmt.Sprintf("order %s is already fulfilled", state.OrderID),
+				ErrOrderNotActive)
+		}
+		return nil
+	}
+	err = workflow.SetUpdateHandlerWithOptions(ctx, UpdateCancelOrder, func(ctx workflow.Context, cancelInput CancelOrderInput) (*types.PizzaOrder, error) {
+		if err := checkCancellable(cancelInput); err != nil {
+			return nil, err
+		}
+
+		// Hold off new steps, and let a charge in flight finish so the refund
+		// covers it. Other steps in flight see the order cancelled when their
+		// activity returns, and don't notify the customer.
+		guard.cancelling = true
+		defer func() { guard.cancelling = false }()
+		if err := workflow.Await(ctx, func() bool { return !guard.running[types.ComponentPayment] }); err != nil {
+			return nil, err
+		}
+		if err := checkCancellable(cancelInput); err != nil {
+			return nil, err
+		}
+
+		logger.Info("Cancelling order", "reason", cancelInput.Reason)
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: stepActivityTimeout(state, types.ComponentPayment),
+			RetryPolicy:         paymentPolicy,
+		})
+		if err := refundPayment(activityCtx, state); err != nil {
+			return nil, activityFailure("refund failed, order not cancelled", err, ErrStepActivityFailed)
+		}
+
+		state.State = types.OrderStateCancelled
+		state.CancelReason = cancelInput.Reason
+		state.UpdateTime = workflow.Now(ctx)
+		state.RecordEvent(state.UpdateTime, types.EventOrderCancelled, cancelInput.Reason)
+		logger.Info("Order cancelled", "refund", state.RefundAmount)
+		return state, nil
+	}, workflow.UpdateHandlerOptions{Validator: checkCancellable})
+	if err != nil {
+		return nil, err
+	}
+
+	// REST_DOUGH is a timer step: once it becomes ready the workflow completes it itself
+	if _, err := state.DAG.GetComponent(types.ComponentRestDough); err == nil {
+		workflow.Go(ctx, func(ctx workflow.Context) {
+			err := workflow.Await(ctx, func() bool {
+				rest, _ := state.DAG.GetComponent(types.ComponentRestDough)
+				return rest.State == types.StateIncomplete
+			})
+			if err != nil {
+				return
+			}
+
+			logger.Info("Resting dough", "duration", DoughRestDuration)
+			if err := workflow.Sleep(ctx, DoughRestDuration); err != nil {
+				return
+			}
+			if checkOrderActive(state) != nil {
+				return
+			}
+			if err := completeComponent(ctx, state, types.ComponentRestDough); err != nil {
+				logger.Error("Failed to complete dough rest", "error", err)
+				return
+			}
+			state.UpdateTime = workflow.Now(ctx)
+			logger.Info("Dough rested", "nextComponent", state.DAG.GetNextComponent())
+		})
+	}
+
+	// Free orders still check the address is deliverable, but the fee is
+	// waived. An undeliverable address fails the order once waiting steps
+	// have answered their callers.
+	if !deliveryPriced {
+		if pricingErr = priceDelivery(ctx, state); pricingErr != nil {
+			if err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); err != nil {
+				return nil, err
+			}
+			return nil, pricingErr
+		}
+		deliveryPriced = true
+	}
+
 	// 4. Wait for all components to complete
 	// This is where the workflow "blocks" waiting for user actions
 	logger.Info("Waiting for all components to complete...")
 
-	err = workflow.Await(ctx, func() bool {
-		// This function is called after every update
-		// It checks if we should continue waiting or not
-		completed := state.IsDone()
-		if completed {
-			logger.Info("All components completed!")
-		}
-		return completed
+	finished, settleFinished := workflow.NewFuture(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		err := workflow.Await(ctx, func() bool {
+			// This function is called after every update
+			// It checks if we should continue waiting or not
+			if state.State == types.OrderStateCancelled || state.State == types.OrderStateFailed {
+				return true
+			}
+			completed := state.IsDone()
+			if completed {
+				logger.Info("All components completed!")
+			}
+			return completed
+		})
+		settleFinished.Set(nil, err)
+	})
+
+	// Orders with an OrderTimeout race the wait against a timer; whichever
+	// fires first wins
+	var expired bool
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(finished, func(f workflow.Future) {
+		err = f.Get(ctx, nil)
 	})
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	if state.ExpiresAt != nil {
+		timer := workflow.NewTimer(timerCtx, state.ExpiresAt.Sub(workflow.Now(ctx)))
+		selector.AddFuture(timer, func(f workflow.Future) {
+			expired = f.Get(ctx, nil) == nil
+		})
+	}
+	selector.Select(ctx)
+	cancelTimer()
 	if err != nil {
 		return nil, err
 	}
+	if expired {
+		// A step still in flight may be charging the customer; let it finish
+		// so the refund covers it, then expire only if it didn't end the order
+		if err := workflow.Await(ctx, func() bool { return len(guard.running) == 0 }); err != nil {
+			return nil, err
+		}
+		if checkOrderActive(state) == nil && !state.IsDone() {
+			expireOrder(ctx, state, input.OrderTimeout, paymentPolicy)
+		}
+	}
+	if state.State == types.OrderStateCancelled {
+		// Steps still in flight see the cancel and answer their callers
+		if err := workflow.Await(ctx, func() bool { return workflow.AllHandlersFinished(ctx) }); err != nil {
+			return nil, err
+		}
+		finishOrder(ctx, state)
+		logger.Info("Pizza order workflow cancelled")
+		return state, nil
+	}
+	if state.State == types.OrderStateFailed {
+		if err := workflow.Await(ctx, func() bool { return !compensating }); err != nil {
+			return nil, err
+		}
+		finishOrder(ctx, state)
+		logger.Info("Pizza order workflow failed, compensations done")
+		return state, nil
+	}
+
+	// Optional steps (like photo proof) don't block completion, but any that
+	// became ready get a window to be completed before the workflow closes
+	if state.DAG.HasReadyOptionalComponents() {
+		logger.Info("Waiting for optional components", "window", OptionalStepWindow)
+		_, err = workflow.AwaitWithTimeout(ctx, OptionalStepWindow, func() bool {
+			return !state.DAG.HasReadyOptionalComponents()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Award loyalty points for what the customer paid. The activity is
+	// idempotent per order; a failure here doesn't fail the order.
+	if state.PaymentAmount > 0 {
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: DefaultActivityTimeout,
+		})
+		var points int
+		err := workflow.ExecuteActivity(activityCtx, "AccrueLoyaltyPoints",
+			state.OrderID, state.CustomerEmail, state.PaymentAmount).Get(activityCtx, &points)
+		if err != nil {
+			logger.Warn("Failed to accrue loyalty points", "error", err)
+		} else {
+			state.LoyaltyPoints = points
+		}
+	}
 
 	// 5. All done! Mark order as completed
 	state.State = types.OrderStateCompleted
 	state.UpdateTime = workflow.Now(ctx)
+	state.RecordEvent(state.UpdateTime, types.EventOrderCompleted, "")
+	finishOrder(ctx, state)
 
 	logger.Info("Pizza order workflow completed successfully!")
 
@@ -233,6 +1533,242 @@ func PizzaOrderWorkflow(ctx workflow.Context, input *PizzaOrderInput) (*types.Pi
 	return state, nil
 }
 
+// finishOrder runs once the order reaches a terminal state: the state is
+// recorded in the memo, since both COMPLETED and CANCELLED orders close as
+// completed workflows, the order duration metric is observed, and the order
+// summary webhook is sent
+func finishOrder(ctx workflow.Context, state *types.PizzaOrder) {
+	if err := workflow.UpsertMemo(ctx, map[string]interface{}{MemoOrderState: state.State}); err != nil {
+		workflow.GetLogger(ctx).Warn("Failed to record order state in memo", "error", err)
+	}
+
+	localCtx := workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{
+		StartToCloseTimeout: time.Second,
+	})
+	err := workflow.ExecuteLocalActivity(localCtx, metrics.ObserveOrderFinished,
+		string(state.State), state.UpdateTime.Sub(state.CreateTime)).Get(localCtx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Warn("Failed to record order duration metric", "error", err)
+	}
+	sendSummaryWebhook(ctx, state)
+}
+
+// expireOrder cancels an order that hit its OrderTimeout, refunding whatever
+// was charged. Unlike a cancel request, a failed refund doesn't keep the order
+// open: nobody is waiting on the answer, so it is left as a warning for staff.
+func expireOrder(ctx workflow.Context, state *types.PizzaOrder, timeout time.Duration, paymentPolicy *temporal.RetryPolicy) {
+	logger := orderLogger(ctx)
+	reason := fmt.Sprintf("order expired after %s", timeout)
+	logger.Info("Order expired, cancelling", "timeout", timeout)
+
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: stepActivityTimeout(state, types.ComponentPayment),
+		RetryPolicy:         paymentPolicy,
+	})
+	if err := refundPayment(activityCtx, state); err != nil {
+		logger.Error("Refund of expired order failed", "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("refund failed when the order expired: %v", err))
+	}
+
+	state.State = types.OrderStateCancelled
+	state.CancelReason = reason
+	state.UpdateTime = workflow.Now(ctx)
+	state.RecordEvent(state.UpdateTime, types.EventOrderCancelled, reason)
+	logger.Info("Order cancelled", "refund", state.RefundAmount)
+}
+
+// notificationRecipient builds the notification target from the order.
+// Returns false when the customer opted out of notifications, or when the order
+// is no longer active - every notification checks this right before it is
+// sent, so a cancel that lands mid-step suppresses "on the way" messages.
+func notificationRecipient(state *types.PizzaOrder) (activities.Recipient, bool) {
+	if checkOrderActive(state) != nil {
+		return activities.Recipient{}, false
+	}
+	return contactRecipient(state)
+}
+
+// contactRecipient is notificationRecipient without the active check, for
+// messages about the order ending, such as the apology when it fails.
+// Returns false when the customer opted out of notifications.
+func contactRecipient(state *types.PizzaOrder) (activities.Recipient, bool) {
+	if state.NotificationPrefs.Channel == types.NotificationNone {
+		return activities.Recipient{}, false
+	}
+	return activities.Recipient{
+		CustomerName:  state.CustomerName,
+		CustomerEmail: state.CustomerEmail,
+		CustomerPhone: state.CustomerPhone,
+		Channel:       state.NotificationPrefs.Channel,
+	}, true
+}
+
+// assignDriver applies a manual driver assignment and completes DELIVER
+// without calling the delivery service
+func assignDriver(ctx workflow.Context, state *types.PizzaOrder, assignment DriverAssignment) error {
+	if assignment.DriverName == "" {
+		return fmt.Errorf("driver_name is required")
+	}
+	if err := checkStepReady(state, types.ComponentDeliver); err != nil {
+		return err
+	}
+	deliver, _ := state.DAG.GetComponent(types.ComponentDeliver)
+	if deliver.State == types.StateCompleted {
+		return fmt.Errorf("delivery already scheduled")
+	}
+
+	now := workflow.Now(ctx)
+	eta := assignment.EstimatedArrival
+	if eta.IsZero() {
+		eta = now.Add(30 * time.Minute)
+	}
+
+	state.DriverName = assignment.DriverName
+	state.EstimatedArrival = &eta
+	state.DeliveryStatus = "DRIVER_ASSIGNED"
+	state.DeliveryZone = activities.ZoneForAddress(state.DeliveryAddress)
+	state.ManualDispatch = true
+	state.Deliveries = []types.DeliveryResult{{
+		Group:            "main",
+		DeliveryAddress:  state.DeliveryAddress,
+		Zone:             state.DeliveryZone,
+		DriverName:       assignment.DriverName,
+		Status:           state.DeliveryStatus,
+		EstimatedArrival: &eta,
+	}}
+
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: DefaultActivityTimeout,
+	})
+	var notifErr error
+	if recipient, ok := notificationRecipient(state); ok {
+		workflow.ExecuteActivity(activityCtx, "SendDeliveryNotification",
+			recipient, assignment.DriverName, eta).Get(activityCtx, &notifErr)
+		// Ignore notification errors - not critical
+	}
+
+	if err := completeComponent(ctx, state, types.ComponentDeliver); err != nil {
+		return err
+	}
+	state.UpdateTime = workflow.Now(ctx)
+	workflow.GetLogger(ctx).Info("Driver assigned manually", "driver", assignment.DriverName, "eta", eta)
+	return nil
+}
+
+// withMaxAttempts returns a copy of policy allowing the given number of
+// attempts, or policy itself when attempts is zero
+func withMaxAttempts(policy *temporal.RetryPolicy, attempts int) *temporal.RetryPolicy {
+	if attempts == 0 {
+		return policy
+	}
+	custom := *policy
+	custom.MaximumAttempts = int32(attempts)
+	return &custom
+}
+
+// checkDeliveryWindow rejects DELIVER until the order's delivery window is
+// close enough for a driver to arrive inside it
+func checkDeliveryWindow(state *types.PizzaOrder, open bool) error {
+	if open {
+		return nil
+	}
+	return temporal.NewApplicationError(
+		fmt.Sprintf("delivery window starts at %s - delivery can be scheduled from %s",
+			state.DeliveryWindowStart.Format(time.RFC3339),
+			state.DeliveryWindowStart.Add(-DeliveryTravelTime).Format(time.RFC3339)),
+		ErrDeliveryWindowNotOpen)
+}
+
+// scheduleWithEscalation schedules one delivery, widening the driver pool a
+// level at a time while nobody is available, up to MaxDeliveryEscalation.
+// input.EscalationLevel is left at the last level tried.
+func scheduleWithEscalation(ctx workflow.Context, activityName string, input *activities.DeliveryInput, result *activities.DeliveryResult) error {
+	for {
+		err := workflow.ExecuteActivity(ctx, activityName, *input).Get(ctx, result)
+		var appErr *temporal.ApplicationError
+		noDrivers := errors.As(err, &appErr) && appErr.Type() == activities.ErrNoDriversAvailable
+		if !noDrivers || input.EscalationLevel >= MaxDeliveryEscalation {
+			return err
+		}
+		input.EscalationLevel++
+		workflow.GetLogger(ctx).Warn("No drivers available, escalating driver pool",
+			"zone", input.Zone, "level", input.EscalationLevel)
+	}
+}
+
+// deliveryGroups resolves the groups to deliver: the requested ones, else the
+// groups recorded by an earlier attempt, else a single group for the order.
+// Groups that already have a driver keep their earlier result.
+func deliveryGroups(state *types.PizzaOrder, requested []types.DeliveryGroup, previousAddress string) ([]types.DeliveryResult, error) {
+	if len(requested) == 0 {
+		if len(state.Deliveries) > 0 {
+			// Groups still waiting for a driver follow an address override
+			groups := append([]types.DeliveryResult{}, state.Deliveries...)
+			for i := range groups {
+				if !groups[i].Scheduled() && groups[i].DeliveryAddress == previousAddress {
+					groups[i].DeliveryAddress = state.DeliveryAddress
+				}
+			}
+			return groups, nil
+		}
+		requested = []types.DeliveryGroup{{Name: "main"}}
+	}
+
+	previous := make(map[string]types.DeliveryResult, len(state.Deliveries))
+	for _, d := range state.Deliveries {
+		previous[d.Group] = d
+	}
+
+	groups := make([]types.DeliveryResult, 0, len(requested))
+	seen := make(map[string]bool, len(requested))
+	for i, group := range requested {
+		name := group.Name
+		if name == "" {
+			name = fmt.Sprintf("group-%d", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate delivery group %q", name)
+		}
+		seen[name] = true
+
+		if prior, ok := previous[name]; ok && prior.Scheduled() {
+			groups = append(groups, prior)
+			continue
+		}
+		address := group.DeliveryAddress
+		if address == "" {
+			address = state.DeliveryAddress
+		}
+		groups = append(groups, types.DeliveryResult{Group: name, DeliveryAddress: address})
+	}
+	return groups, nil
+}
+
+// priceDelivery prices delivery to the order's address by distance and
+// recomputes the total. Free orders still check the address is deliverable,
+// but the fee is waived. A re-price after payment is left as a warning, since
+// the charge isn't adjusted.
+func priceDelivery(ctx workflow.Context, state *types.PizzaOrder) error {
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: DefaultActivityTimeout,
+	})
+	var estimate activities.DeliveryFeeEstimate
+	if err := workflow.ExecuteActivity(activityCtx, "EstimateDeliveryFee", state.DeliveryAddress).Get(activityCtx, &estimate); err != nil {
+		return err
+	}
+	state.DistanceKm = estimate.DistanceKm
+	if state.Subtotal > 0 {
+		state.DeliveryFee = estimate.Fee
+		state.Total, state.TaxAmount = types.ComputeTotal(state.Subtotal, state.DeliveryFee, 0, 0, state.TaxRate)
+	}
+	if charged := state.PaymentAmount - state.RefundAmount; state.PaymentAmount > 0 && math.Round(charged*100) != math.Round(state.Total*100) {
+		state.Warnings = append(state.Warnings, fmt.Sprintf(
+			"total is now %.2f after the delivery address changed, but %.2f was charged", state.Total, charged))
+	}
+	orderLogger(ctx).Info("Delivery fee estimated", "distanceKm", state.DistanceKm, "fee", state.DeliveryFee)
+	return nil
+}
+
 // Helper function to create workflow ID
 func CreateWorkflowID(customerName string) string {
 	return fmt.Sprintf("pizza-orders/%s-%d", customerName, time.Now().Unix())
@@ -0,0 +1,1343 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+)
+
+// testActivities stands in for the worker's activities. Payments go through
+// PaymentActivities with a FakeGateway; delivery is a deterministic stub.
+// Every call is counted by activity name.
+type testActivities struct {
+	gateway  *activities.FakeGateway
+	payments *activities.PaymentActivities
+	loyalty  *activities.LoyaltyActivities
+
+	// Errors to return instead of doing the work, nil to succeed
+	paymentErr  error
+	scheduleErr error
+	refundErr   error
+	feeErr      error
+
+	// paymentDelay holds ProcessPayment up, e.g. past its StartToCloseTimeout;
+	// scheduleDelay and feeDelay do the same for ScheduleDelivery and
+	// EstimateDeliveryFee
+	paymentDelay  time.Duration
+	scheduleDelay time.Duration
+	feeDelay      time.Duration
+
+	// Registered as GourmetBakeWorkflow in place of the real one, if set
+	gourmetBake func(workflow.Context, GourmetBakeInput) (*types.StepProgress, error)
+
+	mu            sync.Mutex
+	calls         map[string]int
+	notifications []activities.Recipient     // Recipient of every customer notification sent
+	scheduled     []activities.DeliveryInput // Input of every ScheduleDelivery attempt
+}
+
+func newTestActivities(t *testing.T) *testActivities {
+	gateway := &activities.FakeGateway{}
+	return &testActivities{
+		gateway:  gateway,
+		payments: &activities.PaymentActivities{Gateway: gateway},
+		loyalty: &activities.LoyaltyActivities{
+			Ledger: activities.NewLoyaltyLedger(filepath.Join(t.TempDir(), "ledger.json")),
+		},
+		calls: make(map[string]int),
+	}
+}
+
+// called records a call and returns how many there have been
+func (a *testActivities) called(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls[name]++
+	return a.calls[name]
+}
+
+// count returns how many times the named activity ran
+func (a *testActivities) count(name string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls[name]
+}
+
+func (a *testActivities) notify(name string, recipient activities.Recipient) error {
+	a.called(name)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.notifications = append(a.notifications, recipient)
+	return nil
+}
+
+func (a *testActivities) ProcessPayment(ctx context.Context, input activities.PaymentInput) (*activities.PaymentResult, error) {
+	a.called(types.ActivityProcessPayment)
+	select {
+	case <-time.After(a.paymentDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if a.paymentErr != nil {
+		return nil, a.paymentErr
+	}
+	return a.payments.ProcessPayment(ctx, input)
+}
+
+func (a *testActivities) RefundPayment(ctx context.Context, transactionID string) error {
+	a.called("RefundPayment")
+	if a.refundErr != nil {
+		return a.refundErr
+	}
+	return a.payments.RefundPayment(ctx, transactionID)
+}
+
+func (a *testActivities) RefundPartialPayment(ctx context.Context, transactionID string, amount float64) error {
+	a.called("RefundPartialPayment")
+	return a.payments.RefundPartialPayment(ctx, transactionID, amount)
+}
+
+func (a *testActivities) EstimateDeliveryFee(ctx context.Context, address string) (*activities.DeliveryFeeEstimate, error) {
+	a.called("EstimateDeliveryFee")
+	select {
+	case <-time.After(a.feeDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if a.feeErr != nil {
+		return nil, a.feeErr
+	}
+	distance := activities.DistanceForAddress(address)
+	return &activities.DeliveryFeeEstimate{Fee: activities.DeliveryFeeFor(distance), DistanceKm: distance}, nil
+}
+
+func (a *testActivities) ScheduleDelivery(ctx context.Context, input activities.DeliveryInput) (*activities.DeliveryResult, error) {
+	a.called(types.ActivityScheduleDelivery)
+	a.mu.Lock()
+	a.scheduled = append(a.scheduled, input)
+	a.mu.Unlock()
+	select {
+	case <-time.After(a.scheduleDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	a.mu.Lock()
+	err := a.scheduleErr
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &activities.DeliveryResult{
+		DeliveryID:       "DEL-" + input.OrderID,
+		DriverName:       "Dana",
+		EstimatedArrival: activity.GetInfo(ctx).StartedTime.Add(time.Duration(input.EstimatedTime) * time.Minute),
+		TrackingURL:      "https://tracking.example.com/" + input.OrderID,
+		Status:           "DRIVER_ASSIGNED",
+	}, nil
+}
+
+func (a *testActivities) UpdateDeliveryStatus(ctx context.Context, deliveryID string) (string, error) {
+	a.called("UpdateDeliveryStatus")
+	return "DELIVERED", nil
+}
+
+func (a *testActivities) SendOrderConfirmation(ctx context.Context, r activities.Recipient, orderID string) error {
+	return a.notify("SendOrderConfirmation", r)
+}
+
+func (a *testActivities) SendDeliveryNotification(ctx context.Context, r activities.Recipient, driverName string, eta time.Time) error {
+	return a.notify("SendDeliveryNotification", r)
+}
+
+func (a *testActivities) SendDeliveredNotification(ctx context.Context, r activities.Recipient, proofURL string) error {
+	return a.notify("SendDeliveredNotification", r)
+}
+
+func (a *testActivities) SendPickupReadyNotification(ctx context.Context, r activities.Recipient, orderID string) error {
+	return a.notify("SendPickupReadyNotification", r)
+}
+
+func (a *testActivities) SendDeliveryFailedNotification(ctx context.Context, r activities.Recipient, orderID string) error {
+	return a.notify("SendDeliveryFailedNotification", r)
+}
+
+func (a *testActivities) SendDeliveryStatusNotification(ctx context.Context, r activities.Recipient, status string) error {
+	return a.notify("SendDeliveryStatusNotification", r)
+}
+
+func (a *testActivities) SendOrderFailedNotification(ctx context.Context, r activities.Recipient, orderID string, refund float64) error {
+	return a.notify("SendOrderFailedNotification", r)
+}
+
+func (a *testActivities) SendDeliveryWindowConfirmation(ctx context.Context, r activities.Recipient, start, end time.Time) error {
+	return a.notify("SendDeliveryWindowConfirmation", r)
+}
+
+// newTestEnv returns a test environment with the order workflows and acts registered
+func newTestEnv(acts *testActivities) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(PizzaOrderWorkflow)
+	if acts.gourmetBake != nil {
+		env.RegisterWorkflowWithOptions(acts.gourmetBake, workflow.RegisterOptions{Name: GourmetBakeWorkflowName})
+	} else {
+		env.RegisterWorkflow(GourmetBakeWorkflow)
+	}
+	env.RegisterActivity(acts)
+	env.RegisterActivity(acts.loyalty.AccrueLoyaltyPoints)
+	env.RegisterActivity((&activities.WebhookActivities{}).SendOrderSummaryWebhook)
+	return env
+}
+
+// testOrderInput is a standard $20 delivery order
+func testOrderInput() *PizzaOrderInput {
+	return &PizzaOrderInput{
+		OrderID:      "order-1",
+		CustomerName: "alice",
+		Amount:       20,
+	}
+}
+
+// updateOutcome is what an update sent with sendUpdate returned
+type updateOutcome struct {
+	sent     bool
+	rejected bool
+	order    *types.PizzaOrder
+	err      error
+}
+
+// sendUpdate sends the update delay after the workflow starts and records its outcome
+func sendUpdate(env *testsuite.TestWorkflowEnvironment, delay time.Duration, name string, args ...interface{}) *updateOutcome {
+	outcome := &updateOutcome{}
+	env.RegisterDelayedCallback(func() {
+		outcome.sent = true
+		env.UpdateWorkflow(name, "", &testsuite.TestUpdateCallback{
+			OnReject: func(err error) {
+				outcome.rejected = true
+				outcome.err = err
+			},
+			OnComplete: func(result interface{}, err error) {
+				outcome.order, _ = result.(*types.PizzaOrder)
+				outcome.err = err
+			},
+		}, args...)
+	}, delay)
+	return outcome
+}
+
+// sendPrepSteps sends every standard-template step before DELIVER, a minute apart
+func sendPrepSteps(t *testing.T, env *testsuite.TestWorkflowEnvironment) []*updateOutcome {
+	t.Helper()
+	return []*updateOutcome{
+		sendUpdate(env, 1*time.Minute, UpdateCompletePayment, CompletePaymentInput{}),
+		sendUpdate(env, 2*time.Minute, UpdateMakeDough, MakeDoughInput{DoughType: "thin"}),
+		sendUpdate(env, 3*time.Minute, UpdateAddToppings, AddToppingsInput{Toppings: []string{"basil"}}),
+		sendUpdate(env, 4*time.Minute, UpdateBakePizza, BakePizzaInput{}),
+	}
+}
+
+// requireSucceeded fails the test unless every update completed without error
+func requireSucceeded(t *testing.T, outcomes ...*updateOutcome) {
+	t.Helper()
+	for i, outcome := range outcomes {
+		if !outcome.sent || outcome.err != nil {
+			t.Fatalf("update %d: sent=%v err=%v", i, outcome.sent, outcome.err)
+		}
+	}
+}
+
+// applicationErrorType returns the type of the ApplicationError in err's chain
+func applicationErrorType(err error) string {
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.Type()
+	}
+	return ""
+}
+
+// queryOrder records a copy of the order's state delay after the workflow
+// starts. Update results all point at the live state, so tests that look
+// at the order between updates snapshot it this way.
+func queryOrder(t *testing.T, env *testsuite.TestWorkflowEnvironment, delay time.Duration) *types.PizzaOrder {
+	t.Helper()
+	order := &types.PizzaOrder{}
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(QueryOrderState)
+		if err == nil {
+			err = value.Get(order)
+		}
+		if err != nil {
+			t.Errorf("query at %s: %v", delay, err)
+		}
+	}, delay)
+	return order
+}
+
+func TestPaymentRetryPolicy(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.paymentErr = errors.New("payment gateway unreachable")
+	env := newTestEnv(acts)
+
+	payment := sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	if payment.err == nil {
+		t.Fatal("CompletePayment succeeded with a failing gateway")
+	}
+	if got := applicationErrorType(payment.err); got != ErrPaymentDeclined {
+		t.Errorf("error type = %q, want %q", got, ErrPaymentDeclined)
+	}
+	want := int(paymentRetryPolicy.MaximumAttempts)
+	if got := acts.count(types.ActivityProcessPayment); got != want {
+		t.Errorf("ProcessPayment ran %d times, want MaximumAttempts = %d", got, want)
+	}
+}
+
+func TestPaymentTimeout(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.paymentDelay = time.Second
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.PaymentTimeout = 50 * time.Millisecond
+	input.PaymentMaxAttempts = 1
+	payment := sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	if got := applicationErrorType(payment.err); got != ErrActivityTimeout {
+		t.Errorf("error = %v, want type %q", payment.err, ErrActivityTimeout)
+	}
+	if len(acts.gateway.Charges()) != 0 {
+		t.Error("the timed out attempt reached the gateway")
+	}
+}
+
+// A client that pays straight after creating a delivery order must not race
+// the delivery fee estimate: the update is accepted and charges the fee
+func TestPaymentWhileDeliveryIsPriced(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.feeDelay = 100 * time.Millisecond
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.Fulfillment = types.FulfillmentDelivery
+	input.DeliveryAddress = "12 Long Road"
+	payment := sendUpdate(env, 0, UpdateCompletePayment, CompletePaymentInput{})
+	env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, payment)
+	wantFee := activities.DeliveryFeeFor(activities.DistanceForAddress(input.DeliveryAddress))
+	if payment.order.DeliveryFee != wantFee {
+		t.Errorf("delivery_fee = %v, want %v", payment.order.DeliveryFee, wantFee)
+	}
+	if payment.order.PaymentAmount != payment.order.Total {
+		t.Errorf("charged %v, want the total %v including the fee", payment.order.PaymentAmount, payment.order.Total)
+	}
+}
+
+func TestPaymentOnUndeliverableAddress(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.feeDelay = 100 * time.Millisecond
+	acts.feeErr = temporal.NewNonRetryableApplicationError("address is outside the delivery area", "OutOfArea", nil)
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.Fulfillment = types.FulfillmentDelivery
+	input.DeliveryAddress = "1 Far Away"
+	payment := sendUpdate(env, 0, UpdateCompletePayment, CompletePaymentInput{})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	if env.GetWorkflowError() == nil {
+		t.Error("workflow succeeded with an undeliverable address")
+	}
+	if got := applicationErrorType(payment.err); got != ErrDeliveryUnavailable {
+		t.Errorf("payment error = %v, want type %q", payment.err, ErrDeliveryUnavailable)
+	}
+	if len(acts.gateway.Charges()) != 0 {
+		t.Error("an order that can't be delivered was charged")
+	}
+}
+
+func TestDeliveryRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		scheduleErr error
+		want        int
+	}{
+		{
+			name:        "retryable failure uses every attempt",
+			scheduleErr: errors.New("delivery service unreachable"),
+			want:        int(deliveryRetryPolicy.MaximumAttempts),
+		},
+		{
+			name: "no drivers is not retried, it escalates",
+			scheduleErr: temporal.NewApplicationError("no delivery drivers available in your area",
+				activities.ErrNoDriversAvailable),
+			want: MaxDeliveryEscalation + 1, // One attempt per driver pool
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acts := newTestActivities(t)
+			acts.scheduleErr = tt.scheduleErr
+			env := newTestEnv(acts)
+
+			prep := sendPrepSteps(t, env)
+			deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+			env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+			env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+			requireSucceeded(t, prep...)
+			if got := applicationErrorType(deliver.err); got != ErrDeliveryUnavailable {
+				t.Fatalf("Deliver error = %v (type %q), want %q", deliver.err, got, ErrDeliveryUnavailable)
+			}
+			if got := acts.count(types.ActivityScheduleDelivery); got != tt.want {
+				t.Errorf("ScheduleDelivery ran %d times, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// requireFailedAndRefunded checks that the workflow ended the order FAILED
+// with the payment refunded and the customer told
+func requireFailedAndRefunded(t *testing.T, env *testsuite.TestWorkflowEnvironment, acts *testActivities) {
+	t.Helper()
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	var order types.PizzaOrder
+	if err := env.GetWorkflowResult(&order); err != nil {
+		t.Fatal(err)
+	}
+	if order.State != types.OrderStateFailed {
+		t.Fatalf("state = %s, want %s", order.State, types.OrderStateFailed)
+	}
+	if order.FailureReason == "" {
+		t.Error("failure_reason is empty")
+	}
+	if got := acts.count("RefundPayment"); got != 1 {
+		t.Errorf("RefundPayment ran %d times, want 1", got)
+	}
+	if order.PaymentAmount == 0 || order.RefundAmount != order.PaymentAmount {
+		t.Errorf("refund_amount = %v, want the payment of %v", order.RefundAmount, order.PaymentAmount)
+	}
+	if got := acts.count("SendOrderFailedNotification"); got != 1 {
+		t.Errorf("SendOrderFailedNotification ran %d times, want 1", got)
+	}
+}
+
+func TestDeliveryFailureFailsOrder(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.scheduleErr = errors.New("delivery service unreachable")
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.DeliveryRetryAttempts = 2
+	input.DeliveryRetryInterval = time.Minute
+	prep := sendPrepSteps(t, env)
+	deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, prep...)
+	if got := applicationErrorType(deliver.err); got != ErrDeliveryUnavailable {
+		t.Fatalf("Deliver error = %v (type %q), want %q", deliver.err, got, ErrDeliveryUnavailable)
+	}
+	requireFailedAndRefunded(t, env, acts)
+	want := (input.DeliveryRetryAttempts + 1) * int(deliveryRetryPolicy.MaximumAttempts)
+	if got := acts.count(types.ActivityScheduleDelivery); got != want {
+		t.Errorf("ScheduleDelivery ran %d times, want %d", got, want)
+	}
+}
+
+// Without automatic retries a failed delivery leaves the order waiting for
+// someone to retry it, rather than giving up and refunding it
+func TestManualDeliveryRetryAfterFailure(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.scheduleErr = errors.New("delivery service unreachable")
+	env := newTestEnv(acts)
+
+	prep := sendPrepSteps(t, env)
+	deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+	afterFailure := queryOrder(t, env, 20*time.Minute)
+	env.RegisterDelayedCallback(func() {
+		acts.mu.Lock()
+		acts.scheduleErr = nil
+		acts.mu.Unlock()
+	}, 25*time.Minute)
+	retry := sendUpdate(env, 30*time.Minute, UpdateRetryComponent, types.ComponentDeliver)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, prep...)
+	if got := applicationErrorType(deliver.err); got != ErrDeliveryUnavailable {
+		t.Fatalf("Deliver error = %v (type %q), want %q", deliver.err, got, ErrDeliveryUnavailable)
+	}
+	if afterFailure.State != types.OrderStateInProgress {
+		t.Errorf("state after the failure = %s, want %s", afterFailure.State, types.OrderStateInProgress)
+	}
+	if component, _ := afterFailure.DAG.GetComponent(types.ComponentDeliver); component.State != types.StateIncomplete {
+		t.Errorf("DELIVER after the failure = %s, want %s", component.State, types.StateIncomplete)
+	}
+	requireSucceeded(t, retry)
+
+	var order types.PizzaOrder
+	if err := env.GetWorkflowResult(&order); err != nil {
+		t.Fatal(err)
+	}
+	if order.State != types.OrderStateCompleted {
+		t.Errorf("state = %s, want %s", order.State, types.OrderStateCompleted)
+	}
+	if got := acts.count("RefundPayment"); got != 0 {
+		t.Errorf("RefundPayment ran %d times, want 0", got)
+	}
+}
+
+func TestBakeFailureFailsOrder(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.gourmetBake = func(ctx workflow.Context, input GourmetBakeInput) (*types.StepProgress, error) {
+		return nil, temporal.NewNonRetryableApplicationError("oven stone cracked", "OvenFailure", nil)
+	}
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.Complexity = types.ComplexityGourmet
+	input.Steps = []types.StepDefinition{
+		{Type: types.ComponentPayment, OnCompleteActivity: types.ActivityProcessPayment},
+		{Type: types.ComponentBakePizza, DependsOn: []types.ComponentType{types.ComponentPayment}},
+		{Type: types.ComponentDeliver, DependsOn: []types.ComponentType{types.ComponentBakePizza},
+			OnCompleteActivity: types.ActivityScheduleDelivery},
+	}
+	payment := sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	bake := sendUpdate(env, 2*time.Minute, UpdateBakePizza, BakePizzaInput{})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, payment)
+	if got := applicationErrorType(bake.err); got != ErrStepActivityFailed {
+		t.Fatalf("BakePizza error = %v (type %q), want %q", bake.err, got, ErrStepActivityFailed)
+	}
+	requireFailedAndRefunded(t, env, acts)
+	if got := acts.count(types.ActivityScheduleDelivery); got != 0 {
+		t.Errorf("ScheduleDelivery ran %d times after the bake failed", got)
+	}
+}
+
+func TestDeliverValidatorRejectsBadInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input DeliverInput
+	}{
+		{name: "drive time too long", input: DeliverInput{EstimatedTime: int(MaxDeliveryEstimate.Minutes()) + 1}},
+		{name: "negative drive time", input: DeliverInput{EstimatedTime: -1}},
+		{name: "address out of range", input: DeliverInput{DeliveryAddress: "500 Castro St, San Francisco, CA"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acts := newTestActivities(t)
+			env := newTestEnv(acts)
+
+			prep := sendPrepSteps(t, env)
+			deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, tt.input)
+			env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+			env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+			requireSucceeded(t, prep...)
+			if !deliver.rejected {
+				t.Fatalf("Deliver was admitted, err = %v", deliver.err)
+			}
+			if got := applicationErrorType(deliver.err); got != ErrInvalidInput {
+				t.Errorf("error type = %q, want %q", got, ErrInvalidInput)
+			}
+			if got := acts.count(types.ActivityScheduleDelivery); got != 0 {
+				t.Errorf("ScheduleDelivery ran %d times for a rejected update", got)
+			}
+		})
+	}
+}
+
+func TestDeliverAddressOverride(t *testing.T) {
+	const newAddress = "1 Market St, San Francisco, CA"
+	acts := newTestActivities(t)
+	acts.scheduleErr = errors.New("delivery service unreachable")
+	env := newTestEnv(acts)
+
+	// The first attempt fails at the original address; the retry moves it
+	input := testOrderInput()
+	input.DeliveryRetryAttempts = 1
+	input.DeliveryRetryInterval = time.Hour
+	prep := sendPrepSteps(t, env)
+	first := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+	env.RegisterDelayedCallback(func() { acts.scheduleErr = nil }, 6*time.Minute)
+	retry := sendUpdate(env, 7*time.Minute, UpdateDeliver, DeliverInput{DeliveryAddress: newAddress})
+	env.RegisterDelayedCallback(env.CancelWorkflow, 8*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, prep...)
+	if got := applicationErrorType(first.err); got != ErrDeliveryUnavailable {
+		t.Fatalf("first Deliver error = %v (type %q), want %q", first.err, got, ErrDeliveryUnavailable)
+	}
+	requireSucceeded(t, retry)
+
+	last := acts.scheduled[len(acts.scheduled)-1]
+	if last.DeliveryAddress != newAddress {
+		t.Errorf("retry scheduled to %q, want %q", last.DeliveryAddress, newAddress)
+	}
+	order := retry.order
+	if order.DeliveryAddress != newAddress {
+		t.Errorf("delivery_address = %q, want %q", order.DeliveryAddress, newAddress)
+	}
+	if got := order.Deliveries[0].DeliveryAddress; got != newAddress {
+		t.Errorf("delivery group address = %q, want %q", got, newAddress)
+	}
+	wantFee := activities.DeliveryFeeFor(activities.DistanceForAddress(newAddress))
+	if order.DeliveryFee != wantFee {
+		t.Errorf("delivery_fee = %v, want %v for the new address", order.DeliveryFee, wantFee)
+	}
+	wantTotal, _ := types.ComputeTotal(input.Amount, wantFee, 0, 0, input.TaxRate)
+	if order.Total != wantTotal {
+		t.Errorf("total = %v, want %v", order.Total, wantTotal)
+	}
+	if len(order.Warnings) == 0 {
+		t.Error("no warning that the total changed after payment")
+	}
+}
+
+// itemOrderInput is a paid delivery order of three items adding up to $30
+func itemOrderInput() *PizzaOrderInput {
+	return &PizzaOrderInput{
+		OrderID:      "order-1",
+		CustomerName: "alice",
+		TaxRate:      0.1,
+		Items: []types.OrderItem{
+			{ID: "a", Name: "Margherita", Amount: 10},
+			{ID: "b", Name: "Pepperoni", Amount: 12},
+			{ID: "c", Name: "Veggie", Amount: 8},
+		},
+	}
+}
+
+func TestNormalizeItems(t *testing.T) {
+	in := itemOrderInput()
+	if err := in.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if in.Amount != 30 || in.DAGTemplate != types.TemplateCustom || in.Items[0].ID != "A" {
+		t.Errorf("amount=%v template=%q first id=%q, want 30, custom, A", in.Amount, in.DAGTemplate, in.Items[0].ID)
+	}
+	// The workflow normalizes the input again
+	if err := in.Normalize(); err != nil {
+		t.Fatalf("second Normalize: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*PizzaOrderInput)
+	}{
+		{name: "amount doesn't match", modify: func(in *PizzaOrderInput) { in.Amount = 25 }},
+		{name: "duplicate id", modify: func(in *PizzaOrderInput) { in.Items[1].ID = "A" }},
+		{name: "bad id", modify: func(in *PizzaOrderInput) { in.Items[1].ID = "b_1" }},
+		{name: "unpriced item", modify: func(in *PizzaOrderInput) { in.Items[1].Amount = 0 }},
+		{name: "pickup", modify: func(in *PizzaOrderInput) { in.Fulfillment = types.FulfillmentPickup }},
+		{name: "with steps", modify: func(in *PizzaOrderInput) { in.Steps = []types.StepDefinition{{Type: types.ComponentPayment}} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := itemOrderInput()
+			tt.modify(in)
+			if err := in.Normalize(); err == nil {
+				t.Error("Normalize accepted the input")
+			}
+		})
+	}
+}
+
+func TestRemoveItem(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	bakeA := types.ItemBakeComponent("A")
+	steps := []*updateOutcome{
+		sendUpdate(env, 1*time.Minute, UpdateCompletePayment, CompletePaymentInput{}),
+		sendUpdate(env, 2*time.Minute, CustomStepUpdateName(types.ItemPrepComponent("A")), CustomStepInput{}),
+		sendUpdate(env, 3*time.Minute, CustomStepUpdateName(bakeA), CustomStepInput{}),
+	}
+	baked := sendUpdate(env, 4*time.Minute, UpdateRemoveItem, RemoveItemInput{ItemID: "a"})
+	unknown := sendUpdate(env, 4*time.Minute, UpdateRemoveItem, RemoveItemInput{ItemID: "z"})
+	paid := queryOrder(t, env, 90*time.Second)
+	removeB := sendUpdate(env, 5*time.Minute, UpdateRemoveItem, RemoveItemInput{ItemID: "b", Reason: "changed my mind"})
+	afterB := queryOrder(t, env, 5*time.Minute+30*time.Second)
+	removeC := sendUpdate(env, 6*time.Minute, UpdateRemoveItem, RemoveItemInput{ItemID: "c"})
+	env.RegisterDelayedCallback(env.CancelWorkflow, 7*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, itemOrderInput())
+
+	requireSucceeded(t, steps...)
+	if !baked.rejected || applicationErrorType(baked.err) != ErrItemNotRemovable {
+		t.Errorf("removing a baked item: rejected=%v err=%v, want ErrItemNotRemovable", baked.rejected, baked.err)
+	}
+	if !unknown.rejected || applicationErrorType(unknown.err) != ErrItemNotFound {
+		t.Errorf("removing an unknown item: rejected=%v err=%v, want ErrItemNotFound", unknown.rejected, unknown.err)
+	}
+	requireSucceeded(t, removeB, removeC)
+
+	// B comes off the charged total, and DELIVER still waits on C
+	order := afterB
+	wantTotal, _ := types.ComputeTotal(18, order.DeliveryFee, 0, 0, 0.1)
+	if order.Subtotal != 18 || order.Total != wantTotal {
+		t.Errorf("subtotal=%v total=%v, want 18 and %v", order.Subtotal, order.Total, wantTotal)
+	}
+	wantRefund := math.Round((paid.Total-wantTotal)*100) / 100
+	if order.RefundAmount != wantRefund {
+		t.Errorf("refund_amount = %v, want %v", order.RefundAmount, wantRefund)
+	}
+	if _, err := order.DAG.GetComponent(types.ItemBakeComponent("B")); err == nil {
+		t.Error("ITEM_B_BAKE still in the order")
+	}
+	if deliver, _ := order.DAG.GetComponent(types.ComponentDeliver); deliver.State != types.StateNeedsInit {
+		t.Errorf("DELIVER = %s with C unbaked, want NEEDS_INIT", deliver.State)
+	}
+
+	// With C gone, A was the only item left to wait for, and it's baked
+	order = removeC.order
+	if len(order.Items) != 1 || order.Items[0].ID != "A" {
+		t.Errorf("items = %+v, want only A", order.Items)
+	}
+	if deliver, _ := order.DAG.GetComponent(types.ComponentDeliver); deliver.State != types.StateIncomplete {
+		t.Errorf("DELIVER = %s with every item baked, want INCOMPLETE", deliver.State)
+	}
+	refunds := acts.gateway.PartialRefunds()
+	if len(refunds) != 2 || refunds[0].TransactionID != paid.PaymentTxnID {
+		t.Fatalf("partial refunds = %+v, want two of %s", refunds, paid.PaymentTxnID)
+	}
+	if got := math.Round((refunds[0].Amount+refunds[1].Amount)*100) / 100; got != order.RefundAmount {
+		t.Errorf("refunded %v in total, order says %v", got, order.RefundAmount)
+	}
+}
+
+func TestValidateStepsRejectsEmptyAnyOfGroup(t *testing.T) {
+	_, err := ValidateSteps([]types.StepDefinition{
+		{Type: types.ComponentPayment},
+		{Type: types.ComponentDeliver, DependsOn: []types.ComponentType{types.ComponentPayment}, AnyOf: [][]types.ComponentType{{}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "empty anyOf group") {
+		t.Errorf("ValidateSteps = %v, want an empty anyOf group error", err)
+	}
+}
+
+func TestOrderSummaryQuery(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	prep := sendPrepSteps(t, env)[:2]
+	checked := 0
+	for _, delay := range []time.Duration{30 * time.Second, 90 * time.Second, 150 * time.Second} {
+		env.RegisterDelayedCallback(func() {
+			var full types.PizzaOrder
+			var summary types.OrderSummary
+			value, err := env.QueryWorkflow(QueryOrderState)
+			if err == nil {
+				err = value.Get(&full)
+			}
+			if err == nil {
+				value, err = env.QueryWorkflow(QueryOrderSummary)
+			}
+			if err == nil {
+				err = value.Get(&summary)
+			}
+			if err != nil {
+				t.Errorf("query at %s: %v", delay, err)
+				return
+			}
+			checked++
+
+			components := full.DAG.GetComponents()
+			completed := 0
+			var next types.ComponentType
+			for _, c := range components {
+				if c.State == types.StateCompleted {
+					completed++
+				}
+				if next == "" && c.State == types.StateIncomplete {
+					next = c.Type
+				}
+			}
+			want := types.OrderSummary{
+				OrderID:         full.OrderID,
+				CustomerName:    full.CustomerName,
+				State:           full.State,
+				NextStep:        next,
+				ProgressPercent: completed * 100 / len(components),
+			}
+			if summary != want {
+				t.Errorf("summary at %s = %+v, want %+v", delay, summary, want)
+			}
+		}, delay)
+	}
+	env.RegisterDelayedCallback(env.CancelWorkflow, 3*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, prep...)
+	if checked != 3 {
+		t.Errorf("checked the summary %d times, want 3", checked)
+	}
+}
+
+func TestCompleteStepOnCancelledOrder(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	payment := sendUpdate(env, 1*time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	cancel := sendUpdate(env, 2*time.Minute, UpdateCancelOrder, CancelOrderInput{Reason: "changed my mind"})
+	dough := sendUpdate(env, 2*time.Minute, UpdateMakeDough, MakeDoughInput{DoughType: "thin"})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, payment, cancel)
+	// Dough was admitted while the cancel was refunding, so it waits for the
+	// cancel and then fails in the handler rather than the validator
+	if applicationErrorType(dough.err) != ErrOrderNotActive {
+		t.Fatalf("dough on a cancelled order: err = %v, want ErrOrderNotActive", dough.err)
+	}
+	var order types.PizzaOrder
+	if err := env.GetWorkflowResult(&order); err != nil {
+		t.Fatal(err)
+	}
+	if dough, _ := order.DAG.GetComponent(types.ComponentMakeDough); dough.State == types.StateCompleted {
+		t.Error("MAKE_DOUGH completed on a cancelled order")
+	}
+}
+
+// Once the cancel has gone through, step validators reject outright
+func TestCheckStepReadyOnCancelledOrder(t *testing.T) {
+	order := &types.PizzaOrder{OrderID: "order-1", State: types.OrderStateCancelled, DAG: types.NewPizzaOrderDAG(types.Now())}
+	if err := checkStepReady(order, types.ComponentPayment); applicationErrorType(err) != ErrOrderNotActive {
+		t.Errorf("checkStepReady = %v, want ErrOrderNotActive", err)
+	}
+}
+
+func TestFreeOrderSkipsPayment(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	input := testOrderInput()
+	input.Amount = 0
+	input.CompReason = "birthday"
+	started := queryOrder(t, env, time.Second)
+	payment := sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	env.RegisterDelayedCallback(env.CancelWorkflow, 2*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	if started.DAG == nil {
+		t.Fatal("no state queried")
+	}
+	states := map[types.ComponentType]types.ComponentState{}
+	for _, c := range started.DAG.GetComponents() {
+		states[c.Type] = c.State
+	}
+	if states[types.ComponentPayment] != types.StateCompleted || states[types.ComponentMakeDough] != types.StateIncomplete {
+		t.Errorf("PAYMENT = %s, MAKE_DOUGH = %s; want COMPLETED and INCOMPLETE", states[types.ComponentPayment], states[types.ComponentMakeDough])
+	}
+	if started.CompReason != "birthday" || started.PaymentTxnID != "" {
+		t.Errorf("comp_reason = %q, txn = %q; want birthday and no charge", started.CompReason, started.PaymentTxnID)
+	}
+
+	// A payment request on a free order returns the order without charging
+	requireSucceeded(t, payment)
+	if n := acts.count(types.ActivityProcessPayment); n != 0 || len(acts.gateway.Charges()) != 0 {
+		t.Errorf("ProcessPayment ran %d times with %d charges, want none", n, len(acts.gateway.Charges()))
+	}
+}
+
+func TestFreeOrderNeedsCompReason(t *testing.T) {
+	input := testOrderInput()
+	input.Amount = 0
+	if err := input.Normalize(); err == nil || !strings.Contains(err.Error(), "comp_reason") {
+		t.Errorf("Normalize = %v, want a comp_reason error", err)
+	}
+}
+
+func TestConcurrentBakeUpdates(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	prep := sendPrepSteps(t, env)[:3]
+	bakes := []*updateOutcome{
+		sendUpdate(env, 4*time.Minute, UpdateBakePizza, BakePizzaInput{}),
+		sendUpdate(env, 4*time.Minute, UpdateBakePizza, BakePizzaInput{}),
+	}
+	after := queryOrder(t, env, 5*time.Minute)
+	env.RegisterDelayedCallback(env.CancelWorkflow, 6*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, prep...)
+	// The loser sees BAKE_PIZZA already completed and gets the order back
+	requireSucceeded(t, bakes...)
+	for i, bake := range bakes {
+		if bake.order == nil {
+			t.Fatalf("bake %d returned no order", i)
+		}
+	}
+
+	completions := 0
+	for _, event := range after.History {
+		switch event.Type {
+		case types.ComponentCompletedEvent(types.ComponentBakePizza):
+			completions++
+		case types.EventStepFailed:
+			t.Errorf("step failed: %s", event.Message)
+		}
+	}
+	if completions != 1 {
+		t.Errorf("BAKE_PIZZA completed %d times, want once", completions)
+	}
+	if bake, _ := after.DAG.GetComponent(types.ComponentBakePizza); bake.State != types.StateCompleted {
+		t.Errorf("BAKE_PIZZA = %s, want COMPLETED", bake.State)
+	}
+}
+
+func TestStepValidatorRejections(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	// The standard template has no PROOF_DOUGH, and nothing but PAYMENT is ready
+	notReady := sendUpdate(env, time.Minute, UpdateBakePizza, BakePizzaInput{})
+	notInDAG := sendUpdate(env, time.Minute, UpdateProofDough, ProofDoughInput{})
+	after := queryOrder(t, env, 2*time.Minute)
+	env.RegisterDelayedCallback(env.CancelWorkflow, 3*time.Minute)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	tests := []struct {
+		name    string
+		outcome *updateOutcome
+		want    string
+	}{
+		{name: "not ready", outcome: notReady, want: ErrComponentNotReady},
+		{name: "not in the DAG", outcome: notInDAG, want: ErrComponentNotFound},
+	}
+	for _, tt := range tests {
+		if !tt.outcome.rejected || applicationErrorType(tt.outcome.err) != tt.want {
+			t.Errorf("%s: rejected=%v err=%v, want rejected with %s", tt.name, tt.outcome.rejected, tt.outcome.err, tt.want)
+		}
+	}
+
+	// Rejected updates never reach the handler, so they leave no trace
+	for _, event := range after.History {
+		if event.Type == types.EventStepFailed {
+			t.Errorf("rejected update recorded %s: %s", event.Type, event.Message)
+		}
+	}
+	if bake, _ := after.DAG.GetComponent(types.ComponentBakePizza); bake.State != types.StateNeedsInit {
+		t.Errorf("BAKE_PIZZA = %s, want NEEDS_INIT", bake.State)
+	}
+}
+
+func TestCustomStepActivities(t *testing.T) {
+	steps := []types.StepDefinition{
+		{Type: types.ComponentPayment, OnCompleteActivity: types.ActivityProcessPayment},
+		{Type: "BOX", DependsOn: []types.ComponentType{types.ComponentPayment}},
+		{Type: "HAND_OFF", DependsOn: []types.ComponentType{"BOX"}, OnCompleteActivity: types.ActivityScheduleDelivery},
+	}
+	tests := []struct {
+		name        string
+		scheduleErr error
+		wantHandOff types.ComponentState
+		wantErrType string
+	}{
+		{name: "activity succeeds", wantHandOff: types.StateCompleted},
+		{name: "activity fails", scheduleErr: errors.New("no drivers"), wantHandOff: types.StateIncomplete,
+			wantErrType: ErrStepActivityFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acts := newTestActivities(t)
+			acts.scheduleErr = tt.scheduleErr
+			env := newTestEnv(acts)
+
+			input := testOrderInput()
+			input.Steps = steps
+			payment := sendUpdate(env, 1*time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+			box := sendUpdate(env, 2*time.Minute, CustomStepUpdateName("BOX"), CustomStepInput{})
+			boxed := queryOrder(t, env, 2*time.Minute+30*time.Second)
+			var scheduledByBox int
+			env.RegisterDelayedCallback(func() {
+				scheduledByBox = acts.count(types.ActivityScheduleDelivery)
+			}, 2*time.Minute+30*time.Second)
+			handOff := sendUpdate(env, 3*time.Minute, CustomStepUpdateName("HAND_OFF"), CustomStepInput{})
+			failed := queryOrder(t, env, 4*time.Minute) // A successful HAND_OFF finishes the order
+			env.RegisterDelayedCallback(env.CancelWorkflow, 5*time.Minute)
+			env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+			// BOX names no activity, so it just completes
+			requireSucceeded(t, payment, box)
+			if c, _ := boxed.DAG.GetComponent("BOX"); c.State != types.StateCompleted {
+				t.Errorf("BOX = %s, want COMPLETED", c.State)
+			}
+			if scheduledByBox != 0 {
+				t.Errorf("BOX ran ScheduleDelivery %d times, want none", scheduledByBox)
+			}
+
+			if got := applicationErrorType(handOff.err); got != tt.wantErrType {
+				t.Errorf("HAND_OFF error = %v, want type %q", handOff.err, tt.wantErrType)
+			}
+			after := handOff.order
+			if after == nil {
+				after = failed
+			}
+			if c, _ := after.DAG.GetComponent("HAND_OFF"); c.State != tt.wantHandOff {
+				t.Errorf("HAND_OFF = %s, want %s", c.State, tt.wantHandOff)
+			}
+			if acts.count(types.ActivityScheduleDelivery) == 0 {
+				t.Error("HAND_OFF didn't run ScheduleDelivery")
+			}
+		})
+	}
+}
+
+func TestCancelDuringDeliverySuppressesNotification(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.scheduleDelay = 200 * time.Millisecond
+	env := newTestEnv(acts)
+
+	prep := sendPrepSteps(t, env)
+	// DELIVER starts scheduling, then the cancel refunds while it's in flight
+	deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+	cancel := sendUpdate(env, 5*time.Minute, UpdateCancelOrder, CancelOrderInput{Reason: "changed my mind"})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, prep...)
+	requireSucceeded(t, cancel)
+	if acts.count(types.ActivityScheduleDelivery) != 1 {
+		t.Fatalf("ScheduleDelivery ran %d times, want it in flight during the cancel", acts.count(types.ActivityScheduleDelivery))
+	}
+	if got := acts.count("SendDeliveryNotification"); got != 0 {
+		t.Errorf("sent %d delivery notifications for a cancelled order", got)
+	}
+	if applicationErrorType(deliver.err) != ErrOrderNotActive {
+		t.Errorf("deliver err = %v, want ErrOrderNotActive", deliver.err)
+	}
+
+	var order types.PizzaOrder
+	if err := env.GetWorkflowResult(&order); err != nil {
+		t.Fatal(err)
+	}
+	if order.State != types.OrderStateCancelled {
+		t.Errorf("state = %s, want CANCELLED", order.State)
+	}
+	if d, _ := order.DAG.GetComponent(types.ComponentDeliver); d.State == types.StateCompleted {
+		t.Error("DELIVER completed on a cancelled order")
+	}
+}
+
+func TestCancelRefundsPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		pay        bool
+		refundErr  error
+		wantState  types.OrderState
+		wantRefund bool
+		wantErr    string
+	}{
+		{name: "paid", pay: true, wantState: types.OrderStateCancelled, wantRefund: true},
+		{name: "not paid yet", wantState: types.OrderStateCancelled},
+		{name: "refund fails", pay: true, refundErr: errors.New("gateway down"),
+			wantState: types.OrderStateInProgress, wantErr: ErrStepActivityFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acts := newTestActivities(t)
+			acts.refundErr = tt.refundErr
+			env := newTestEnv(acts)
+
+			var payment *updateOutcome
+			if tt.pay {
+				payment = sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+			}
+			cancel := sendUpdate(env, 2*time.Minute, UpdateCancelOrder, CancelOrderInput{Reason: "changed my mind"})
+			after := queryOrder(t, env, 3*time.Minute)
+			env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+			env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+			if payment != nil {
+				requireSucceeded(t, payment)
+			}
+			if got := applicationErrorType(cancel.err); got != tt.wantErr {
+				t.Fatalf("cancel err = %v, want type %q", cancel.err, tt.wantErr)
+			}
+			order := cancel.order
+			if order == nil {
+				order = after // The cancel failed, so the order is still running
+			}
+			if order.State != tt.wantState {
+				t.Errorf("state = %s, want %s", order.State, tt.wantState)
+			}
+
+			refunds := acts.gateway.Refunds()
+			switch {
+			case tt.wantRefund:
+				if len(refunds) != 1 || refunds[0] != order.PaymentTxnID {
+					t.Errorf("refunds = %v, want %s", refunds, order.PaymentTxnID)
+				}
+				if order.RefundAmount == 0 || order.RefundAmount != order.PaymentAmount {
+					t.Errorf("refund_amount = %v, want the payment of %v", order.RefundAmount, order.PaymentAmount)
+				}
+				if order.CancelReason != "changed my mind" {
+					t.Errorf("cancel_reason = %q", order.CancelReason)
+				}
+			case len(refunds) != 0 || order.RefundAmount != 0:
+				t.Errorf("refunds = %v, refund_amount = %v; want none", refunds, order.RefundAmount)
+			}
+		})
+	}
+}
+
+func TestNotificationPreference(t *testing.T) {
+	for _, preference := range []string{"", types.NotificationSMS, types.NotificationEmail, types.NotificationPush, types.NotificationNone} {
+		name := preference
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			acts := newTestActivities(t)
+			env := newTestEnv(acts)
+
+			input := testOrderInput()
+			input.NotificationPreference = preference
+			prep := sendPrepSteps(t, env)
+			deliver := sendUpdate(env, 5*time.Minute, UpdateDeliver, DeliverInput{})
+			env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+			env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+			requireSucceeded(t, append(prep, deliver)...)
+
+			sent := acts.count("SendOrderConfirmation") + acts.count("SendDeliveryNotification")
+			if preference == types.NotificationNone {
+				if sent != 0 || len(acts.notifications) != 0 {
+					t.Errorf("sent %d notifications to a customer who opted out", len(acts.notifications))
+				}
+				return
+			}
+			if acts.count("SendOrderConfirmation") != 1 || acts.count("SendDeliveryNotification") != 1 {
+				t.Errorf("confirmations = %d, delivery notifications = %d; want one each",
+					acts.count("SendOrderConfirmation"), acts.count("SendDeliveryNotification"))
+			}
+			// An empty channel lets each message use its own default
+			for _, recipient := range acts.notifications {
+				if recipient.Channel != preference {
+					t.Errorf("notified on %q, want %q", recipient.Channel, preference)
+				}
+			}
+		})
+	}
+}
+
+func TestDAGFailureTypes(t *testing.T) {
+	dag := types.NewPizzaOrderDAG(types.Now())
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "not found", err: dag.CompleteComponent(types.ComponentAddSauce), want: ErrComponentNotFound},
+		{name: "dependencies not met", err: dag.CompleteComponent(types.ComponentBakePizza), want: ErrComponentNotReady},
+		{name: "not ready", err: dag.ResetComponent(types.ComponentPayment), want: ErrComponentNotReady},
+		{name: "wrapped", err: fmt.Errorf("redo: %w", dag.ResetComponent(types.ComponentAddSauce)), want: ErrComponentNotFound},
+		{name: "other", err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := applicationErrorType(dagFailure(tt.err)); got != tt.want {
+				t.Errorf("dagFailure(%v) type = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateStepIsReplayed(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	// The order moves on between the keyed request and its repeat
+	keyed := MakeDoughInput{DoughType: "thin", StepOptions: StepOptions{IdempotencyKey: "dough-1"}}
+	payment := sendUpdate(env, time.Minute, UpdateCompletePayment, CompletePaymentInput{})
+	first := sendUpdate(env, 2*time.Minute, UpdateMakeDough, keyed)
+	toppings := sendUpdate(env, 3*time.Minute, UpdateAddToppings, AddToppingsInput{Toppings: []string{"basil"}})
+	sameKey := sendUpdate(env, 4*time.Minute, UpdateMakeDough, keyed)
+	noKey := sendUpdate(env, 5*time.Minute, UpdateMakeDough, MakeDoughInput{DoughType: "thin"})
+	env.RegisterDelayedCallback(env.CancelWorkflow, time.Hour)
+	env.ExecuteWorkflow(PizzaOrderWorkflow, testOrderInput())
+
+	requireSucceeded(t, payment, first, toppings, sameKey, noKey)
+	if first.order.Replayed {
+		t.Error("the request that made the dough is marked replayed")
+	}
+	if !sameKey.order.Replayed || !noKey.order.Replayed {
+		t.Errorf("replayed = %v (same key), %v (no key), want both true", sameKey.order.Replayed, noKey.order.Replayed)
+	}
+
+	// The same key gets the original response; without one it's the order now
+	toppingsState := func(order *types.PizzaOrder) types.ComponentState {
+		component, err := order.DAG.GetComponent(types.ComponentAddToppings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return component.State
+	}
+	if got := toppingsState(sameKey.order); got != types.StateIncomplete {
+		t.Errorf("same key: ADD_TOPPINGS = %s, want %s as the first request returned it", got, types.StateIncomplete)
+	}
+	if !sameKey.order.UpdateTime.Equal(first.order.UpdateTime) {
+		t.Errorf("same key: update_time = %v, want the first response's %v", sameKey.order.UpdateTime, first.order.UpdateTime)
+	}
+	if got := toppingsState(noKey.order); got != types.StateCompleted {
+		t.Errorf("no key: ADD_TOPPINGS = %s, want %s", got, types.StateCompleted)
+	}
+	if got := acts.count(types.ActivityProcessPayment); got != 1 {
+		t.Errorf("ProcessPayment ran %d times, want 1", got)
+	}
+}
+
+// exportedOrder is a paid standard delivery order as another system exported
+// it, decoded the way POST /orders/import decodes the bundle
+func exportedOrder(t *testing.T) *types.OrderBundle {
+	t.Helper()
+	dag := types.NewPizzaOrderDAG(types.Now())
+	if err := dag.CompleteComponent(types.ComponentPayment); err != nil {
+		t.Fatal(err)
+	}
+	order := &types.PizzaOrder{
+		OrderID:         "pizza-orders/exported",
+		CustomerName:    "alice",
+		DeliveryAddress: "12 Long Road",
+		State:           types.OrderStateInProgress,
+		DAG:             dag,
+		Complexity:      types.ComplexitySimple,
+		Fulfillment:     types.FulfillmentDelivery,
+		Template:        types.TemplateStandard,
+		Subtotal:        20,
+		DeliveryFee:     5,
+		Total:           25,
+		PaymentTxnID:    "TXN-EXPORTED",
+		PaymentAmount:   25,
+	}
+	data, err := json.Marshal(types.NewOrderBundle(order, types.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle types.OrderBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	return &bundle
+}
+
+func TestImportInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*types.OrderBundle)
+		wantErr string
+	}{
+		{name: "in progress"},
+		{name: "unknown version", edit: func(b *types.OrderBundle) { b.Version++ }, wantErr: "version"},
+		{name: "no order", edit: func(b *types.OrderBundle) { b.Order = nil }, wantErr: "no order components"},
+		{name: "finished", edit: func(b *types.OrderBundle) { b.Order.State = types.OrderStateCompleted }, wantErr: "only IN_PROGRESS"},
+		{name: "steps don't match", edit: func(b *types.OrderBundle) { b.Steps = b.Steps[1:] }, wantErr: "don't match"},
+		{name: "invalid order", edit: func(b *types.OrderBundle) { b.Order.CustomerName = "" }, wantErr: "customer_name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := exportedOrder(t)
+			if tt.edit != nil {
+				tt.edit(bundle)
+			}
+			input, err := ImportInput(bundle)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if input.Imported != bundle.Order || input.DAGTemplate != types.TemplateCustom {
+				t.Errorf("input = %+v, want the bundle's order and graph", input)
+			}
+		})
+	}
+}
+
+func TestImportedOrderResumes(t *testing.T) {
+	acts := newTestActivities(t)
+	env := newTestEnv(acts)
+
+	input, err := ImportInput(exportedOrder(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.OrderID = "order-2"
+	steps := []*updateOutcome{
+		sendUpdate(env, 1*time.Minute, UpdateMakeDough, MakeDoughInput{DoughType: "thin"}),
+		sendUpdate(env, 2*time.Minute, UpdateAddToppings, AddToppingsInput{Toppings: []string{"basil"}}),
+		sendUpdate(env, 3*time.Minute, UpdateBakePizza, BakePizzaInput{}),
+		sendUpdate(env, 4*time.Minute, UpdateDeliver, DeliverInput{}),
+	}
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, steps...)
+	var order types.PizzaOrder
+	if err := env.GetWorkflowResult(&order); err != nil {
+		t.Fatal(err)
+	}
+	if order.State != types.OrderStateCompleted || order.OrderID != "order-2" {
+		t.Errorf("order %s is %s, want order-2 COMPLETED", order.OrderID, order.State)
+	}
+	if acts.count(types.ActivityProcessPayment) != 0 || acts.count("EstimateDeliveryFee") != 0 {
+		t.Error("the imported order was charged or priced again")
+	}
+	if order.PaymentTxnID != "TXN-EXPORTED" || order.Total != 25 {
+		t.Errorf("payment %s for %v, want the exported TXN-EXPORTED for 25", order.PaymentTxnID, order.Total)
+	}
+	if order.History[0].Type != types.EventOrderImported || order.History[0].Message != "pizza-orders/exported" {
+		t.Errorf("first event = %+v, want ORDER_IMPORTED from pizza-orders/exported", order.History[0])
+	}
+}
+
+func TestImportedOrderFailureRefunds(t *testing.T) {
+	acts := newTestActivities(t)
+	acts.scheduleErr = errors.New("delivery service unreachable")
+	env := newTestEnv(acts)
+
+	input, err := ImportInput(exportedOrder(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.OrderID = "order-2"
+	input.DeliveryRetryAttempts = 1
+	input.DeliveryRetryInterval = time.Minute
+	steps := []*updateOutcome{
+		sendUpdate(env, 1*time.Minute, UpdateMakeDough, MakeDoughInput{DoughType: "thin"}),
+		sendUpdate(env, 2*time.Minute, UpdateAddToppings, AddToppingsInput{Toppings: []string{"basil"}}),
+		sendUpdate(env, 3*time.Minute, UpdateBakePizza, BakePizzaInput{}),
+	}
+	sendUpdate(env, 4*time.Minute, UpdateDeliver, DeliverInput{})
+	env.ExecuteWorkflow(PizzaOrderWorkflow, input)
+
+	requireSucceeded(t, steps...)
+	requireFailedAndRefunded(t, env, acts)
+	if refunds := acts.gateway.Refunds(); len(refunds) != 1 || refunds[0] != "TXN-EXPORTED" {
+		t.Errorf("refunds = %v, want the exported TXN-EXPORTED", refunds)
+	}
+}
@@ -0,0 +1,184 @@
+package workflow
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"pizza-order-dag-demo/types"
+
+	commonpb "go.temporal.io/api/common/v1"
+	enumspb "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	taskqueuepb "go.temporal.io/api/taskqueue/v1"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const dagClockWorkflowName = "dagClockWorkflow"
+
+// dagClockWorkflow builds an order's DAG the way PizzaOrderWorkflow does, then
+// sleeps a minute only if every timestamp in it is the workflow's clock. A
+// wall-clock timestamp skips the timer, which replay reports as nondeterminism.
+func dagClockWorkflow(ctx workflow.Context, input *PizzaOrderInput) error {
+	if err := input.Normalize(); err != nil {
+		return err
+	}
+	dag, err := input.BuildDAG(workflow.Now(ctx))
+	if err != nil {
+		return err
+	}
+	if err := dag.AddAgeVerification(workflow.Now(ctx)); err != nil {
+		return err
+	}
+
+	for _, c := range dag.GetComponents() {
+		if !c.UpdateTime.Equal(workflow.Now(ctx)) || (c.ReadyTime != nil && !c.ReadyTime.Equal(workflow.Now(ctx))) {
+			return nil
+		}
+	}
+	return workflow.Sleep(ctx, time.Minute)
+}
+
+// dagClockHistory is the history of a dagClockWorkflow run started at start:
+// one workflow task that starts the timer, and one that completes the run
+func dagClockHistory(t *testing.T, start time.Time, input *PizzaOrderInput) *historypb.History {
+	t.Helper()
+	payloads, err := converter.GetDefaultDataConverter().ToPayloads(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskQueue := &taskqueuepb.TaskQueue{Name: PizzaOrderTaskQueue}
+	fired := start.Add(time.Minute)
+
+	events := []*historypb.HistoryEvent{
+		{
+			EventTime: timestamppb.New(start),
+			EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_STARTED,
+			Attributes: &historypb.HistoryEvent_WorkflowExecutionStartedEventAttributes{
+				WorkflowExecutionStartedEventAttributes: &historypb.WorkflowExecutionStartedEventAttributes{
+					WorkflowType:        &commonpb.WorkflowType{Name: dagClockWorkflowName},
+					TaskQueue:           taskQueue,
+					Input:               payloads,
+					WorkflowTaskTimeout: durationpb.New(10 * time.Second),
+					Attempt:             1,
+				},
+			},
+		},
+	}
+	// addWorkflowTask appends a scheduled, started and completed workflow task
+	addWorkflowTask := func(at time.Time) int64 {
+		scheduled := int64(len(events) + 1)
+		events = append(events,
+			&historypb.HistoryEvent{
+				EventTime: timestamppb.New(at),
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_SCHEDULED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskScheduledEventAttributes{
+					WorkflowTaskScheduledEventAttributes: &historypb.WorkflowTaskScheduledEventAttributes{
+						TaskQueue:           taskQueue,
+						StartToCloseTimeout: durationpb.New(10 * time.Second),
+						Attempt:             1,
+					},
+				},
+			},
+			&historypb.HistoryEvent{
+				EventTime: timestamppb.New(at),
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_STARTED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskStartedEventAttributes{
+					WorkflowTaskStartedEventAttributes: &historypb.WorkflowTaskStartedEventAttributes{
+						ScheduledEventId: scheduled,
+					},
+				},
+			},
+			&historypb.HistoryEvent{
+				EventTime: timestamppb.New(at),
+				EventType: enumspb.EVENT_TYPE_WORKFLOW_TASK_COMPLETED,
+				Attributes: &historypb.HistoryEvent_WorkflowTaskCompletedEventAttributes{
+					WorkflowTaskCompletedEventAttributes: &historypb.WorkflowTaskCompletedEventAttributes{
+						ScheduledEventId: scheduled,
+						StartedEventId:   scheduled + 1,
+					},
+				},
+			},
+		)
+		return scheduled + 2
+	}
+
+	completed := addWorkflowTask(start)
+	timerStarted := int64(len(events) + 1)
+	timerID := strconv.FormatInt(timerStarted, 10) // The SDK names timers by the event that starts them
+	events = append(events,
+		&historypb.HistoryEvent{
+			EventTime: timestamppb.New(start),
+			EventType: enumspb.EVENT_TYPE_TIMER_STARTED,
+			Attributes: &historypb.HistoryEvent_TimerStartedEventAttributes{
+				TimerStartedEventAttributes: &historypb.TimerStartedEventAttributes{
+					TimerId:                      timerID,
+					StartToFireTimeout:           durationpb.New(time.Minute),
+					WorkflowTaskCompletedEventId: completed,
+				},
+			},
+		},
+		&historypb.HistoryEvent{
+			EventTime: timestamppb.New(fired),
+			EventType: enumspb.EVENT_TYPE_TIMER_FIRED,
+			Attributes: &historypb.HistoryEvent_TimerFiredEventAttributes{
+				TimerFiredEventAttributes: &historypb.TimerFiredEventAttributes{
+					TimerId:        timerID,
+					StartedEventId: timerStarted,
+				},
+			},
+		},
+	)
+	completed = addWorkflowTask(fired)
+	events = append(events, &historypb.HistoryEvent{
+		EventTime: timestamppb.New(fired),
+		EventType: enumspb.EVENT_TYPE_WORKFLOW_EXECUTION_COMPLETED,
+		Attributes: &historypb.HistoryEvent_WorkflowExecutionCompletedEventAttributes{
+			WorkflowExecutionCompletedEventAttributes: &historypb.WorkflowExecutionCompletedEventAttributes{
+				WorkflowTaskCompletedEventId: completed,
+			},
+		},
+	})
+
+	for i, event := range events {
+		event.EventId = int64(i + 1)
+	}
+	return &historypb.History{Events: events}
+}
+
+// TestDAGTimestampsReplay replays a recorded run in which every DAG timestamp
+// came from the workflow clock. Building the DAG from the wall clock instead
+// would give different timestamps on replay, and fail it.
+func TestDAGTimestampsReplay(t *testing.T) {
+	tests := []struct {
+		name  string
+		input *PizzaOrderInput
+	}{
+		{name: "template", input: &PizzaOrderInput{CustomerName: "alice", Amount: 20, ContainsAlcohol: true}},
+		{name: "custom steps", input: &PizzaOrderInput{
+			CustomerName:    "alice",
+			Amount:          20,
+			ContainsAlcohol: true,
+			Steps: []types.StepDefinition{
+				{Type: types.ComponentPayment},
+				{Type: "PREP", DependsOn: []types.ComponentType{types.ComponentPayment}},
+				{Type: types.ComponentDeliver, DependsOn: []types.ComponentType{"PREP"}},
+			},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Recorded well in the past, so a wall-clock timestamp can't match it
+			start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+			replayer := worker.NewWorkflowReplayer()
+			replayer.RegisterWorkflowWithOptions(dagClockWorkflow, workflow.RegisterOptions{Name: dagClockWorkflowName})
+			if err := replayer.ReplayWorkflowHistory(nil, dagClockHistory(t, start, tt.input)); err != nil {
+				t.Fatalf("replay failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package workflow
+
+import (
+	"fmt"
+
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// compensation undoes the side effect of a completed step, e.g. refunds a charge
+type compensation struct {
+	name string
+	run  func(ctx workflow.Context) error
+}
+
+// compensations is the order's saga stack: steps push an entry once their side
+// effect has happened, and failOrder runs them newest first
+type compensations []compensation
+
+// push adds a compensation to run if the order fails later
+func (c *compensations) push(name string, run func(ctx workflow.Context) error) {
+	*c = append(*c, compensation{name: name, run: run})
+}
+
+// runCompensations runs the stack in reverse order. A compensation that fails
+// is logged and left as a warning on the order; the rest still run.
+func runCompensations(ctx workflow.Context, state *types.PizzaOrder, stack compensations) {
+	logger := orderLogger(ctx)
+	for i := len(stack) - 1; i >= 0; i-- {
+		c := stack[i]
+		logger.Info("Running compensation", "compensation", c.name)
+		if err := c.run(ctx); err != nil {
+			logger.Error("Compensation failed", "compensation", c.name, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf("compensation %q failed: %v", c.name, err))
+		}
+	}
+}
+
+// failOrder gives up on an order that can't be fulfilled: it is marked FAILED,
+// so no more steps are accepted, and then every compensation runs
+func failOrder(ctx workflow.Context, state *types.PizzaOrder, stack compensations, reason string) {
+	logger := orderLogger(ctx)
+	logger.Warn("Order failed, compensating", "reason", reason, "compensations", len(stack))
+
+	state.State = types.OrderStateFailed
+	state.FailureReason = reason
+	state.UpdateTime = workflow.Now(ctx)
+	state.RecordEvent(state.UpdateTime, types.EventOrderFailed, reason)
+
+	runCompensations(ctx, state, stack)
+	state.UpdateTime = workflow.Now(ctx)
+	logger.Info("Order compensated", "refund", state.RefundAmount)
+}
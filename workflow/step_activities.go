@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// stepActivityInputs builds the input for each activity a component can name
+// as its OnCompleteActivity. Naming an activity without a builder is an error.
+var stepActivityInputs = map[string]func(state *types.PizzaOrder) interface{}{
+	types.ActivityProcessPayment: func(state *types.PizzaOrder) interface{} {
+		return activities.PaymentInput{
+			OrderID:      state.OrderID,
+			CustomerName: state.CustomerName,
+			Amount:       state.Total, // Tax included
+
+			IdempotencyKey: paymentIdempotencyKey(state, ""),
+		}
+	},
+	types.ActivityScheduleDelivery: func(state *types.PizzaOrder) interface{} {
+		return activities.DeliveryInput{
+			OrderID:         state.OrderID,
+			CustomerName:    state.CustomerName,
+			DeliveryAddress: state.DeliveryAddress,
+			Zone:            activities.ZoneForAddress(state.DeliveryAddress),
+			EstimatedTime:   int(DeliveryTravelTime.Minutes()),
+			WindowStart:     state.DeliveryWindowStart,
+			WindowEnd:       state.DeliveryWindowEnd,
+		}
+	},
+}
+
+// paymentIdempotencyKey derives the key for a charge from the order and the
+// PAYMENT component, so every retry of the same charge sends the same key.
+// part tells payment splits apart and is empty for a single charge.
+func paymentIdempotencyKey(state *types.PizzaOrder, part string) string {
+	key := state.OrderID + "/" + string(types.ComponentPayment)
+	if part != "" {
+		key += "/" + part
+	}
+	return key
+}
+
+// stepActivityName returns the activity the component runs on completion, if any
+func stepActivityName(state *types.PizzaOrder, componentType types.ComponentType) string {
+	component, err := state.DAG.GetComponent(componentType)
+	if err != nil {
+		return ""
+	}
+	return component.OnCompleteActivity
+}
+
+// stepActivityTimeout returns the StartToCloseTimeout for the component's
+// activity: its own ActivityTimeout, or DefaultActivityTimeout
+func stepActivityTimeout(state *types.PizzaOrder, componentType types.ComponentType) time.Duration {
+	component, err := state.DAG.GetComponent(componentType)
+	if err != nil || component.ActivityTimeout <= 0 {
+		return DefaultActivityTimeout
+	}
+	return component.ActivityTimeout
+}
+
+// runStepActivity executes the component's OnCompleteActivity and decodes its
+// result into valuePtr (nil discards it). Returns false if the component has
+// no activity. ctx carries the caller's activity options.
+func runStepActivity(ctx workflow.Context, state *types.PizzaOrder, componentType types.ComponentType, valuePtr interface{}) (bool, error) {
+	name := stepActivityName(state, componentType)
+	if name == "" {
+		return false, nil
+	}
+	buildInput, ok := stepActivityInputs[name]
+	if !ok {
+		return false, fmt.Errorf("component %s names unknown activity %q", componentType, name)
+	}
+	return true, workflow.ExecuteActivity(ctx, name, buildInput(state)).Get(ctx, valuePtr)
+}
+
+// completeWithActivity is the generic step completion: run the component's
+// activity, if it names one, then mark the component complete
+func completeWithActivity(ctx workflow.Context, state *types.PizzaOrder, componentType types.ComponentType) error {
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: stepActivityTimeout(state, componentType),
+	})
+	if _, err := runStepActivity(activityCtx, state, componentType, nil); err != nil {
+		return activityFailure(fmt.Sprintf("%s activity failed", componentType), err, ErrStepActivityFailed)
+	}
+	return completeComponent(ctx, state, componentType)
+}
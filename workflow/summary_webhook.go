@@ -0,0 +1,71 @@
+package workflow
+
+import (
+	"time"
+
+	"pizza-order-dag-demo/activities"
+	"pizza-order-dag-demo/types"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// SummaryWebhookDeadline is how long the order summary webhook keeps being
+// retried before it is marked FAILED and the workflow closes anyway
+const SummaryWebhookDeadline = 24 * time.Hour
+
+// summaryWebhookRetryPolicy retries until the receiver acknowledges, backing
+// off to one attempt every 10 minutes; SummaryWebhookDeadline bounds it
+var summaryWebhookRetryPolicy = &temporal.RetryPolicy{
+	InitialInterval:    time.Second,
+	BackoffCoefficient: 2,
+	MaximumInterval:    10 * time.Minute,
+}
+
+// sendSummaryWebhook fires the order summary webhook once the order is in a
+// terminal state. finishOrder calls it exactly once per order; the activity's
+// retries make delivery at-least-once, and the event ID (fixed per order and
+// state) lets the receiver drop duplicates. The outcome is recorded in
+// state.SummaryWebhook and never fails the order.
+func sendSummaryWebhook(ctx workflow.Context, state *types.PizzaOrder) {
+	state.SummaryWebhook = &types.WebhookDelivery{Status: types.WebhookPending}
+
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout:    DefaultActivityTimeout,
+		ScheduleToCloseTimeout: SummaryWebhookDeadline,
+		RetryPolicy:            summaryWebhookRetryPolicy,
+	})
+	event := activities.OrderSummaryEvent{
+		EventID:       state.OrderID + "/" + string(state.State),
+		OrderID:       state.OrderID,
+		State:         string(state.State),
+		CustomerName:  state.CustomerName,
+		CustomerEmail: state.CustomerEmail,
+		FinishedAt:    state.UpdateTime,
+		Subtotal:      state.Subtotal,
+		DeliveryFee:   state.DeliveryFee,
+		Tax:           state.TaxAmount,
+		Total:         state.Total,
+		PaymentAmount: state.PaymentAmount,
+		PaymentTxnID:  state.PaymentTxnID,
+		CompReason:    state.CompReason,
+		LoyaltyPoints: state.LoyaltyPoints,
+	}
+
+	var result activities.WebhookResult
+	err := workflow.ExecuteActivity(activityCtx, "SendOrderSummaryWebhook", event).Get(activityCtx, &result)
+	switch {
+	case err != nil:
+		workflow.GetLogger(ctx).Warn("Order summary webhook failed", "error", err)
+		state.SummaryWebhook.Status = types.WebhookFailed
+		state.SummaryWebhook.Error = err.Error()
+		state.Warnings = append(state.Warnings, "order summary webhook was not acknowledged")
+	case !result.Delivered:
+		state.SummaryWebhook.Status = types.WebhookDisabled
+	default:
+		now := workflow.Now(ctx)
+		state.SummaryWebhook.Status = types.WebhookDelivered
+		state.SummaryWebhook.Attempts = result.Attempts
+		state.SummaryWebhook.DeliveredAt = &now
+	}
+}